@@ -0,0 +1,169 @@
+// Command dbtool inspects a database file offline, while no server has it open: it reports the shape of
+// the B-tree, decodes single blocks, prints the tree and its keys, and checks its invariants.
+package main
+
+import (
+	"bufio"
+	db "database/database"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// dataPath is the database file the commands inspect by default, the one the server serves.
+const dataPath = "../data/db"
+
+// command is a command of dbtool.
+type command struct {
+	args  string                                // Arguments after the flags, for the usage message.
+	help  string                                // What the command does.
+	run   func(f *db.File, args []string) error // Runs the command on the open file with the arguments after the flags.
+	nargs int                                   // Number of arguments after the flags.
+}
+
+// commands are the commands of dbtool by name.
+var commands = map[string]command{
+	"stat":      {"", "Reports the pages of the file and the shape of the tree.", statCommand, 0},
+	"dump-page": {" N", "Decodes block N of the file.", dumpPageCommand, 1},
+	"walk":      {"", "Prints every node reachable from the root, indented by depth.", walkCommand, 0},
+	"check":     {"", "Checks the invariants of the tree and lists the violations.", checkCommand, 0},
+	"keys":      {"", "Lists the keys in the order of the tree.", keysCommand, 0},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: dbtool %s [flags]%s\n%s\n", name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	path := fs.String("db-path", dataPath, "path of the database file")
+	fs.Parse(os.Args[2:])
+	if fs.NArg() != cmd.nargs {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := db.OpenFile(*path)
+	if err == nil {
+		err = cmd.run(f, fs.Args())
+		f.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the commands and exits.
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dbtool <command> [flags] [arguments]\nInspects a database file no server has open.\n\ncommands:")
+	for _, name := range []string{"stat", "dump-page", "walk", "check", "keys"} {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", name+commands[name].args, commands[name].help)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun dbtool <command> -h for the flags of a command.")
+	os.Exit(2)
+}
+
+// statCommand reports the pages of the file and the shape of the tree.
+func statCommand(f *db.File, args []string) error {
+	stats, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("file size:      %d bytes\n", stats.FileSize)
+	fmt.Printf("pages:          %d\n", stats.Pages)
+	fmt.Printf("nodes:          %d\n", stats.Nodes)
+	fmt.Printf("orphaned pages: %d\n", stats.Orphaned)
+	fmt.Printf("keys:           %d\n", stats.Keys)
+	fmt.Printf("depth:          %d\n", stats.Depth)
+	fmt.Printf("fill factor:    %.2f\n", stats.Fill)
+	return nil
+}
+
+// dumpPageCommand decodes the block whose number is the argument.
+func dumpPageCommand(f *db.File, args []string) error {
+	number, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid block number %q", args[0])
+	}
+	page, err := f.Page(number)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("block:    %d\n", page.Number)
+	fmt.Printf("id:       %d\n", page.ID)
+	fmt.Printf("pairs:    %d\n", len(page.Pairs))
+	fmt.Printf("children: %d\n", len(page.Children))
+	for i, p := range page.Pairs {
+		fmt.Printf("pair %d: %q = %q\n", i, p.Key, p.Value)
+	}
+	for i, child := range page.Children {
+		fmt.Printf("child %d: %d\n", i, child)
+	}
+	return nil
+}
+
+// walkCommand prints every node reachable from the root with the range of its keys, indented by depth.
+func walkCommand(f *db.File, args []string) error {
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	return f.Walk(func(page *db.Page, depth int) error {
+		kind := fmt.Sprintf("%d children", len(page.Children))
+		if len(page.Children) == 0 {
+			kind = "leaf"
+		}
+		keys := "no keys"
+		if n := len(page.Pairs); n > 0 {
+			keys = fmt.Sprintf("%d keys %q .. %q", n, page.Pairs[0].Key, page.Pairs[n-1].Key)
+		}
+		_, err := fmt.Fprintf(w, "%sblock %d: %s, %s\n", strings.Repeat("  ", depth-1), page.Number, kind, keys)
+		return err
+	})
+}
+
+// checkCommand lists the violations of the invariants of the tree, failing if there is any. The blocks
+// not reachable from the root, which splits and merges leave behind, are only counted: they waste space
+// until COMPACT reclaims it, but do not break the tree.
+func checkCommand(f *db.File, args []string) error {
+	violations, err := f.Check()
+	if err != nil {
+		return err
+	}
+	broken, unreachable := 0, 0
+	for _, v := range violations {
+		if v.Kind == db.ViolationUnreachable {
+			unreachable++
+			continue
+		}
+		broken++
+		fmt.Println(v)
+	}
+	if unreachable > 0 {
+		fmt.Printf("%d blocks not reachable from the root, which COMPACT reclaims\n", unreachable)
+	}
+	if broken > 0 {
+		return fmt.Errorf("%d violations of the invariants of the tree", broken)
+	}
+	fmt.Println("no violations")
+	return nil
+}
+
+// keysCommand lists the keys in the order of the tree, one per line.
+func keysCommand(f *db.File, args []string) error {
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	return f.Keys(func(key, value string) error {
+		_, err := fmt.Fprintln(w, key)
+		return err
+	})
+}
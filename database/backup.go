@@ -0,0 +1,148 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// blockBackup is a copy of the database file in progress. Blocks are copied in order, and a block
+// that is about to be overwritten before it is copied has its old contents kept for the copy,
+// so the copy shows the file as it was when the backup started.
+type blockBackup struct {
+	size  int64            // Number of blocks in the file when the backup started.
+	next  int64            // Next block to copy; the blocks before it are no longer needed.
+	saved map[int64][]byte // Old contents of the blocks overwritten since the backup started.
+}
+
+// backups tracks the copies in progress of a block storage file.
+type backups struct {
+	mu     sync.Mutex                // Mutex to protect active and the state of the copies.
+	active map[*blockBackup]struct{} // Copies in progress.
+}
+
+// Backup writes a consistent copy of the database file to w, as it was when Backup was called.
+// Writers are only held back while the backup starts: the blocks they overwrite afterwards are kept
+// in memory until they are copied. The copy is a valid database file that Restore or Open accept.
+func (db *DB) Backup(w io.Writer) error {
+	db.mu.RLock()
+	if db.storage == nil {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	bs := db.blocks
+	if bs == nil {
+		db.mu.RUnlock()
+		return errors.New("unexpected root node type")
+	}
+	b, err := bs.startBackup()
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer bs.endBackup(b)
+
+	for b.next < b.size {
+		buffer, err := bs.backupBlock(b)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(buffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore replaces every key-value pair with the contents of a copy written by Backup or WriteSnapshot,
+// and syncs the database file. A copy that is not made of whole blocks is rejected with ErrInvalidBlock
+// and leaves the database untouched. Watchers are not notified of the keys that change.
+func (db *DB) Restore(r io.Reader) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.loadSnapshot(r); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := db.blocks.file.Sync()
+	db.reportLatency(LatencyFsync, start)
+	return err
+}
+
+// startBackup registers a copy of the blocks currently in the file; the caller keeps writers out.
+func (bs *blockService) startBackup() (*blockBackup, error) {
+	latest, err := bs.getLatestBlockID()
+	if err != nil {
+		return nil, err
+	}
+	b := &blockBackup{size: latest + 1, saved: make(map[int64][]byte)}
+	bs.backups.mu.Lock()
+	defer bs.backups.mu.Unlock()
+	if bs.backups.active == nil {
+		bs.backups.active = make(map[*blockBackup]struct{})
+	}
+	bs.backups.active[b] = struct{}{}
+	return b, nil
+}
+
+// endBackup forgets a finished or failed copy, releasing the blocks kept for it.
+func (bs *blockService) endBackup(b *blockBackup) {
+	bs.backups.mu.Lock()
+	defer bs.backups.mu.Unlock()
+	delete(bs.backups.active, b)
+}
+
+// backupBlock returns the contents of the next block of a copy: the kept old contents if the block
+// was overwritten, or else the block as it is in the file, which cannot change while it is read.
+func (bs *blockService) backupBlock(b *blockBackup) ([]byte, error) {
+	bs.backups.mu.Lock()
+	defer bs.backups.mu.Unlock()
+	id := b.next
+	buffer, ok := b.saved[id]
+	if !ok {
+		buffer = make([]byte, blockSize)
+		if _, err := bs.file.ReadAt(buffer, id*blockSize); err != nil {
+			return nil, &BlockError{Path: bs.file.Name(), Block: id, Err: err}
+		}
+	}
+	delete(b.saved, id)
+	b.next++
+	return buffer, nil
+}
+
+// preserveBlocks keeps the current contents of the blocks from first to last, included, for the copies
+// in progress that still need them. It is called before the blocks are overwritten or truncated.
+func (bs *blockService) preserveBlocks(first, last int64) error {
+	bs.backups.mu.Lock()
+	defer bs.backups.mu.Unlock()
+	for id := first; id <= last; id++ {
+		var buffer []byte
+		for b := range bs.backups.active {
+			if _, ok := b.saved[id]; ok || id < b.next || id >= b.size {
+				continue
+			}
+			if buffer == nil {
+				buffer = make([]byte, blockSize)
+				if _, err := bs.file.ReadAt(buffer, id*blockSize); err != nil {
+					return &BlockError{Path: bs.file.Name(), Block: id, Err: err}
+				}
+			}
+			b.saved[id] = buffer
+		}
+	}
+	return nil
+}
+
+// preserveFile keeps the blocks the copies in progress still need, before the file is truncated.
+func (bs *blockService) preserveFile() error {
+	bs.backups.mu.Lock()
+	last := int64(-1)
+	for b := range bs.backups.active {
+		last = max(last, b.size-1)
+	}
+	bs.backups.mu.Unlock()
+	return bs.preserveBlocks(0, last)
+}
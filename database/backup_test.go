@@ -0,0 +1,105 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupIsPointInTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "source.db")
+	src, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close(path)
+	for i := 0; i < 200; i++ {
+		if err := src.Put(fmt.Sprintf("key%03d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The copy is read a block at a time, and the database keeps changing in between
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := src.Backup(pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	var backup bytes.Buffer
+	if _, err := io.CopyN(&backup, pr, blockSize); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := src.Put(fmt.Sprintf("key%03d-new", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.Update(func(tx *Tx) error { return tx.Del("key000") }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(&backup, pr); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	dstPath := filepath.Join(t.TempDir(), "destination.db")
+	dst, err := Open(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close(dstPath)
+	if err := dst.Restore(bytes.NewReader(backup.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	dst.ForEach(func(key, value string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if len(keys) != 200 || keys[0] != "key000" || keys[199] != "key199" {
+		t.Errorf("expected the 200 keys present when the backup started, got %d keys", len(keys))
+	}
+}
+
+func TestBackupWhileCleared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "source.db")
+	src, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close(path)
+	for i := 0; i < 100; i++ {
+		src.Put(fmt.Sprintf("key%03d", i), fmt.Sprint(i))
+	}
+
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(src.Backup(pw)) }()
+	var backup bytes.Buffer
+	if _, err := io.CopyN(&backup, pr, blockSize); err != nil {
+		t.Fatal(err)
+	}
+	// Truncating the file keeps the blocks the copy still needs
+	if err := src.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(&backup, pr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := src.Restore(bytes.NewReader(backup.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, _ := src.Get("key099"); !ok || value != "99" {
+		t.Errorf("expected the restored key, got %q, %v", value, ok)
+	}
+	if err := src.Restore(bytes.NewReader([]byte("short"))); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("expected a partial block to be rejected, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"encoding/binary"
 	"os"
+	"sync/atomic"
 )
 
 const blockSize = 4096 // Fixed block size for storage on disk.
@@ -20,8 +21,15 @@ type diskBlock struct {
 
 // blockService provides functionality to manage disk blocks.
 // It allows reading, writing, and managing blocks stored in a file.
+// The service counts the blocks read from and written to disk, and the node splits and merges of the tree
+// stored in the file.
 type blockService struct {
-	file *os.File // File handle for the block storage file.
+	file        *os.File      // File handle for the block storage file.
+	blockReads  atomic.Uint64 // Number of blocks read from disk.
+	blockWrites atomic.Uint64 // Number of blocks written to disk.
+	splits      atomic.Uint64 // Number of nodes split because they overflowed.
+	merges      atomic.Uint64 // Number of nodes merged with a sibling because they underflowed.
+	backups     backups       // Copies of the file in progress, which keep the blocks overwritten while they run.
 }
 
 // isRootNode checks whether the given DiskNode is the root node.
@@ -84,18 +92,15 @@ func (bs *blockService) getRootBlock() (*diskBlock, error) {
 // It calculates the byte offset and reads the block into memory.
 func (bs *blockService) getBlockFromDiskByBlockNumber(index int64) (*diskBlock, error) {
 	if index < 0 {
-		panic("Index less than 0 requested")
-	}
-	offset := index * blockSize
-	_, err := bs.file.Seek(offset, 0)
-	if err != nil {
-		return nil, err
+		return nil, &BlockError{Path: bs.file.Name(), Block: index, Err: ErrInvalidBlock}
 	}
+	bs.blockReads.Add(1)
 
+	offset := index * blockSize
 	blockBuffer := make([]byte, blockSize)
-	_, err = bs.file.Read(blockBuffer)
+	_, err := bs.file.ReadAt(blockBuffer, offset)
 	if err != nil {
-		return nil, err
+		return nil, &BlockError{Path: bs.file.Name(), Block: index, Err: err}
 	}
 	// Deserialize the block from the buffer.
 	return bs.getBlockFromBuffer(blockBuffer), nil
@@ -188,14 +193,14 @@ func (bs *blockService) newBlock() (*diskBlock, error) {
 func (bs *blockService) writeBlockToDisk(block *diskBlock) error {
 	seekOffset := blockSize * block.id
 	blockBuffer := bs.getBufferFromBlock(block)
-	_, err := bs.file.Seek(int64(seekOffset), 0)
-	if err != nil {
+	if err := bs.preserveBlocks(int64(block.id), int64(block.id)); err != nil {
 		return err
 	}
-	_, err = bs.file.Write(blockBuffer)
+	_, err := bs.file.WriteAt(blockBuffer, int64(seekOffset))
 	if err != nil {
 		return err
 	}
+	bs.blockWrites.Add(1)
 	return nil
 }
 
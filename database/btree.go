@@ -1,6 +1,9 @@
 package db
 
-import "os"
+import (
+	"errors"
+	"os"
+)
 
 // btree represents the in-memory B-tree structure.
 // It manages the root node and provides methods for interacting with the tree.
@@ -11,32 +14,32 @@ type btree struct {
 // node defines the interface that all nodes (e.g., leaf and internal) must implement.
 // It provides methods for inserting, deleting, retrieving, and printing elements in the tree.
 type node interface {
-	// InsertPair inserts a key-value pair into the node.
+	// insertPair inserts a key-value pair into the node.
 	// The node may split if it exceeds its capacity, and the split may propagate upwards.
 	// Parameters:
 	// - value: A pointer to the key-value pair to be inserted.
 	// - bt: A reference to the B-tree to which the node belongs.
 	// Returns: An error if the insertion fails.
-	InsertPair(value *pairs, bt *btree) error
+	insertPair(value *pairs, bt *btree) error
 
-	// Delete removes a key-value pair from the node.
+	// delete removes a key-value pair from the node.
 	// The node may merge with its siblings if it becomes underfilled, and the merge may propagate upwards.
 	// Parameters:
 	// - key: The key to be Deleted from the node.
 	// - bt: A reference to the B-tree to which the node belongs.
 	// Returns: An error if the deletion fails.
-	Delete(key string, bt *btree) error
+	delete(key string, bt *btree) error
 
-	// GetValue retrieves the value associated with a key in the node.
+	// getValue retrieves the value associated with a key in the node.
 	// Parameters:
 	// - key: The key to search for in the node.
 	// Returns: The value associated with the key, and an error if the key is not found.
-	GetValue(key string) (string, error)
+	getValue(key string) (string, error)
 
-	// PrintTree prints the structure of the node and its descendants.
+	// printTree prints the structure of the node and its descendants, stopping at the first child that cannot be read.
 	// Parameters:
 	// - level: The depth level of the node in the tree (used for indentation).
-	PrintTree(level int)
+	printTree(level int) error
 }
 
 // isRootNode checks if a given node is the root node of the B-tree.
@@ -62,11 +65,16 @@ func initializeBtree(path ...string) (*btree, error) {
 	if err != nil {
 		return nil, err
 	}
-	dns := NewDiskNodeService(file)
+	dns := newDiskNodeService(file)
 
 	root, err := dns.getRootNodeFromDisk()
 	if err != nil {
-		panic(err)
+		file.Close()
+		var blockErr *BlockError
+		if !errors.As(err, &blockErr) {
+			err = &BlockError{Path: path[0], Block: 0, Err: err}
+		}
+		return nil, err
 	}
 	return &btree{root: root}, nil
 }
@@ -77,7 +85,7 @@ func initializeBtree(path ...string) (*btree, error) {
 // - value: A pointer to the key-value pair to be inserted.
 // Returns: An error if the insertion fails.
 func (bt *btree) insert(value *pairs) error {
-	return bt.root.InsertPair(value, bt)
+	return bt.root.insertPair(value, bt)
 }
 
 // get retrieves the value associated with a key in the B-tree.
@@ -86,7 +94,7 @@ func (bt *btree) insert(value *pairs) error {
 // - key: The key to search for in the B-tree.
 // Returns: The value associated with the key, a boolean indicating if the key was found, and an error if the operation fails.
 func (bt *btree) get(key string) (string, bool, error) {
-	value, err := bt.root.GetValue(key)
+	value, err := bt.root.getValue(key)
 	if err != nil {
 		return "", false, err
 	}
@@ -110,6 +118,6 @@ func (bt *btree) setRootNode(n node) {
 // - key: The key to be Deleted from the B-tree.
 // Returns: An error if the deletion fails.
 func (bt *btree) del(key string) error {
-	err := bt.root.Delete(key, bt)
+	err := bt.root.delete(key, bt)
 	return err
 }
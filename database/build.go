@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+const (
+	minKeys           = (maxLeafSize + 1) / 2 // Fewest keys of a node other than the root; deletions merge the nodes below it.
+	defaultFillFactor = 0.9                   // Share of the keys of a full node that rebuilt nodes hold, leaving room for insertions.
+)
+
+// WithFillFactor sets the share of a full node that Compact and BulkLoad fill the nodes they write with.
+// Values outside 0.5 to 1 are clamped, since fuller nodes would overflow and emptier ones would be merged.
+func WithFillFactor(f float64) Option {
+	return func(db *DB) {
+		db.fillFactor = f
+	}
+}
+
+// fillKeys returns the number of keys rebuilt nodes hold for a fill factor.
+func fillKeys(f float64) int {
+	return min(max(int(math.Ceil(f*maxLeafSize)), minKeys), maxLeafSize)
+}
+
+// treeBuilder writes a B-tree bottom-up to an empty block storage file, from pairs added in key order.
+// Leaves are packed with fill keys in turn; the key after a full leaf moves up to the level above as the
+// separator to the next leaf, and the levels above are packed the same way, so every node is written once.
+// The last two nodes of each level are held back and rebalanced when the input ends, so no node other than
+// the root holds fewer than minKeys keys. The root is written last to block 0, which is set aside first.
+type treeBuilder struct {
+	ctx    context.Context // Abandons the build once canceled.
+	bs     *blockService   // The block storage of the new file.
+	fill   int             // Number of keys packed in a node.
+	levels []*buildLevel   // The nodes not written yet, from the leaves up.
+	last   string          // The last key added.
+	count  int             // Number of pairs added.
+}
+
+// buildLevel holds the nodes of a level of the tree that are not written yet.
+type buildLevel struct {
+	node      *DiskNode // The node being filled.
+	pending   *DiskNode // The previous node, held back until node is full, so the last two can be rebalanced.
+	separator *pairs    // The key between pending and node.
+}
+
+// newTreeBuilder creates a builder writing to the empty block storage, with fill keys per node.
+func newTreeBuilder(ctx context.Context, bs *blockService, fill int) (*treeBuilder, error) {
+	if _, err := bs.newBlock(); err != nil {
+		return nil, err
+	}
+	b := &treeBuilder{ctx: ctx, bs: bs, fill: fill}
+	b.levels = []*buildLevel{{node: b.newNode()}}
+	return b, nil
+}
+
+// newNode returns an empty node of the new file, which gets its block when it is written.
+func (b *treeBuilder) newNode() *DiskNode {
+	return &DiskNode{blockService: b.bs}
+}
+
+// add adds a pair, which must come after the pairs added before it in key order.
+func (b *treeBuilder) add(key, value string) error {
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+	if b.count > 0 && key <= b.last {
+		return fmt.Errorf("key %q after %q: %w", key, b.last, ErrUnsorted)
+	}
+	pair := newPair(key, value)
+	if err := pair.validate(); err != nil {
+		return err
+	}
+	b.last = key
+	b.count++
+	return b.push(0, 0, pair)
+}
+
+// push adds a key to a level; above the leaves, the key comes with the child to its left.
+func (b *treeBuilder) push(level int, child uint64, key *pairs) error {
+	if level == len(b.levels) {
+		b.levels = append(b.levels, &buildLevel{node: b.newNode()})
+	}
+	l := b.levels[level]
+	if level > 0 {
+		l.node.childrenBlockIDs = append(l.node.childrenBlockIDs, child)
+	}
+	if len(l.node.keys) < b.fill {
+		l.node.keys = append(l.node.keys, key)
+		return nil
+	}
+	// The node is full, so the key separates it from the next one
+	if l.pending != nil {
+		if err := b.bs.saveNewNodeToDisk(l.pending); err != nil {
+			return err
+		}
+		if err := b.push(level+1, l.pending.blockID, l.separator); err != nil {
+			return err
+		}
+	}
+	l.pending, l.separator, l.node = l.node, key, b.newNode()
+	return nil
+}
+
+// finish writes the nodes held back, level by level from the leaves up, and the root.
+func (b *treeBuilder) finish() error {
+	for level := 0; level < len(b.levels); level++ {
+		l := b.levels[level]
+		if l.pending == nil {
+			// A single node is left on the top level
+			return b.bs.updateRootNode(l.node)
+		}
+		nodes, separator := rebalance(l.pending, l.separator, l.node)
+		if len(nodes) == 1 && level == len(b.levels)-1 {
+			return b.bs.updateRootNode(nodes[0])
+		}
+		if len(nodes) == 2 {
+			if err := b.bs.saveNewNodeToDisk(nodes[0]); err != nil {
+				return err
+			}
+			if err := b.push(level+1, nodes[0].blockID, separator); err != nil {
+				return err
+			}
+		}
+		last := nodes[len(nodes)-1]
+		if err := b.bs.saveNewNodeToDisk(last); err != nil {
+			return err
+		}
+		above := b.levels[level+1].node
+		above.childrenBlockIDs = append(above.childrenBlockIDs, last.blockID)
+	}
+	return nil
+}
+
+// rebalance returns the last two nodes of a level, given the full node before the last one and the key
+// between them. A last node with fewer than minKeys keys is merged with the one before it, or if they do not
+// fit in a node together, their keys are split evenly between them around a new separator.
+func rebalance(full *DiskNode, separator *pairs, last *DiskNode) ([]*DiskNode, *pairs) {
+	if len(last.keys) >= minKeys {
+		return []*DiskNode{full, last}, separator
+	}
+	keys := append(append(append([]*pairs(nil), full.keys...), separator), last.keys...)
+	children := append(append([]uint64(nil), full.childrenBlockIDs...), last.childrenBlockIDs...)
+	if len(keys) <= maxLeafSize {
+		return []*DiskNode{{keys: keys, childrenBlockIDs: children, blockService: full.blockService}}, nil
+	}
+	middle := (len(keys) - 1) / 2
+	left := &DiskNode{keys: keys[:middle], blockService: full.blockService}
+	right := &DiskNode{keys: keys[middle+1:], blockService: full.blockService}
+	if len(children) > 0 {
+		left.childrenBlockIDs, right.childrenBlockIDs = children[:middle+1], children[middle+1:]
+	}
+	return []*DiskNode{left, right}, keys[middle]
+}
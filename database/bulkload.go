@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"time"
+)
+
+const LoadSuffix = ".load" // Suffix of the file a bulk load writes next to the database file before swapping it in.
+
+// BulkLoad replaces every key-value pair with the pairs of seq, which must come in strictly increasing
+// key order, and returns the number of pairs loaded. Instead of inserting the pairs one at a time, it packs
+// them into leaves in turn, to the fill factor set with WithFillFactor, and writes the internal levels
+// bottom-up as the leaves fill, so every node is written once and memory use does not grow with the input.
+// The tree is written to path.load and renamed over the database file once complete, as Compact does:
+// readers keep working on the previous contents meanwhile, and writers wait until BulkLoad returns.
+// A key that does not come after the one before it fails with an error wrapping ErrUnsorted, and an
+// invalid pair with the error Put would return; either way the database is left untouched.
+// Watchers are not notified of the keys that change.
+func (db *DB) BulkLoad(seq iter.Seq2[string, string]) (int, error) {
+	return db.bulkLoad(func(add func(key, value string) error) error {
+		for key, value := range seq {
+			if err := add(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// bulkLoad replaces every pair with the pairs that pairs passes to add, unless pairs fails.
+func (db *DB) bulkLoad(pairs func(add func(key, value string) error) error) (int, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	db.mu.RLock()
+	if db.storage == nil {
+		db.mu.RUnlock()
+		return 0, ErrClosed
+	}
+	bs := db.blocks
+	db.mu.RUnlock()
+	if bs == nil {
+		return 0, errors.New("unexpected root node type")
+	}
+
+	start := time.Now()
+	path := bs.file.Name()
+	count := 0
+	f, err := writeTree(context.Background(), path+LoadSuffix, fillKeys(db.fillFactor), func(add func(key, value string) error) error {
+		return pairs(func(key, value string) error {
+			if err := add(key, value); err != nil {
+				return err
+			}
+			count++
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	_, size, err := db.swapFile(f, path)
+	if err != nil {
+		return 0, err
+	}
+	db.keys.Store(int64(count))
+	db.logger.Info("bulk loaded database", "path", path, "pairs", count, "size", size, "duration", time.Since(start))
+	return count, nil
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sortedPairs yields n pairs with keys in increasing order.
+func sortedPairs(n int) func(yield func(string, string) bool) {
+	return func(yield func(string, string) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(fmt.Sprintf("key%06d", i), fmt.Sprint(i)) {
+				return
+			}
+		}
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bulk.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	if err := db.Put("stale", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := db.BulkLoad(sortedPairs(20000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 20000 {
+		t.Errorf("expected 20000 pairs loaded, got %d", count)
+	}
+	db.mu.RLock()
+	keys, _ := checkShape(t, db.storage.root.(*DiskNode), true)
+	db.mu.RUnlock()
+	if len(keys) != 20000 {
+		t.Fatalf("expected 20000 keys, got %d", len(keys))
+	}
+	if _, ok, _ := db.Get("stale"); ok {
+		t.Error("expected the previous contents to be replaced")
+	}
+
+	// The loaded tree takes further writes, and is read back after reopening
+	if err := db.Put("key010000a", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(path); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"key000000", "key010000", "key010000a", "key019999"} {
+		if _, ok, err := db.Get(key); err != nil || !ok {
+			t.Errorf("expected %s after reopening, got %v, %v", key, ok, err)
+		}
+	}
+}
+
+func TestBulkLoadRejectsInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bulk.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	if err := db.Put("kept", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	unsorted := func(yield func(string, string) bool) {
+		for _, key := range []string{"a", "c", "b"} {
+			if !yield(key, "v") {
+				return
+			}
+		}
+	}
+	_, err = db.BulkLoad(unsorted)
+	if !errors.Is(err, ErrUnsorted) || !strings.Contains(err.Error(), `"b" after "c"`) {
+		t.Errorf("expected the out-of-order key to be reported, got %v", err)
+	}
+	duplicate := func(yield func(string, string) bool) {
+		_ = yield("a", "v") && yield("a", "w")
+	}
+	if _, err := db.BulkLoad(duplicate); !errors.Is(err, ErrUnsorted) {
+		t.Errorf("expected a duplicate key to be rejected, got %v", err)
+	}
+	long := func(yield func(string, string) bool) {
+		yield(strings.Repeat("k", maxKeyLength+1), "v")
+	}
+	if _, err := db.BulkLoad(long); err == nil {
+		t.Error("expected a key that is too long to be rejected")
+	}
+
+	if value, ok, _ := db.Get("kept"); !ok || value != "1" {
+		t.Errorf("expected the database to be untouched, got %q, %v", value, ok)
+	}
+	if _, ok, _ := db.Get("a"); ok {
+		t.Error("expected no pair of the rejected input")
+	}
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ViolationKind classifies the invariants of the B-tree that Check verifies.
+type ViolationKind string
+
+const (
+	ViolationBlock       ViolationKind = "block"       // A block that cannot be decoded, or that records another block ID than its own.
+	ViolationOrder       ViolationKind = "order"       // Keys out of order within a node.
+	ViolationRange       ViolationKind = "range"       // A key outside the range the separators of the ancestors of its node leave to it.
+	ViolationDuplicate   ViolationKind = "duplicate"   // A key stored more than once.
+	ViolationChildren    ViolationKind = "children"    // An internal node whose number of children is not its number of keys plus one.
+	ViolationPointer     ViolationKind = "pointer"     // A child block ID past the end of the file, at the root, or at a node reached before.
+	ViolationDepth       ViolationKind = "depth"       // Leaves at different depths.
+	ViolationFill        ViolationKind = "fill"        // A node other than the root under the minimum fill, or an internal root without keys.
+	ViolationUnreachable ViolationKind = "unreachable" // A block not reachable from the root, such as the ones splits and merges leave until Compact.
+)
+
+// Violation is a broken invariant of the B-tree stored in a database file.
+type Violation struct {
+	Kind    ViolationKind // Invariant broken.
+	Block   int64         // Number of the block at fault, or -1 for the file as a whole.
+	Problem string        // Description of the violation.
+}
+
+// String returns the violation as the block at fault, the kind and the problem.
+func (v Violation) String() string {
+	if v.Block < 0 {
+		return fmt.Sprintf("%s: %s", v.Kind, v.Problem)
+	}
+	return fmt.Sprintf("block %d: %s: %s", v.Block, v.Kind, v.Problem)
+}
+
+// checker walks the tree stored in a file and collects the violations of its invariants.
+type checker struct {
+	bs         *blockService // Reads the blocks of the file.
+	pages      int64         // Number of blocks in the file.
+	seen       []bool        // Whether each block was reached from the root.
+	leafDepth  int           // Depth of the first leaf reached, 0 before.
+	violations []Violation   // Violations found so far.
+}
+
+// separator is a key of a node bounding the keys of the subtree under one of its children.
+type separator struct {
+	key   string // The key.
+	block int64  // Number of the block holding the key.
+	set   bool   // Whether there is such a key; the first and last subtrees are unbounded on one side.
+}
+
+// checkTree checks the tree stored in the file of the block service, reading its blocks straight from
+// the file. Blocks that cannot be decoded are reported as violations; the error reports a file that
+// cannot be read.
+func checkTree(bs *blockService) ([]Violation, error) {
+	fi, err := bs.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	c := &checker{bs: bs, pages: fi.Size() / blockSize}
+	c.seen = make([]bool, c.pages)
+	if fi.Size()%blockSize != 0 {
+		c.report(ViolationBlock, -1, "the file size %d is not a multiple of the block size %d", fi.Size(), blockSize)
+	}
+	if c.pages == 0 {
+		return c.violations, nil
+	}
+	if err := c.check(0, 1, separator{}, separator{}); err != nil {
+		return nil, err
+	}
+	for number, seen := range c.seen {
+		if !seen {
+			c.report(ViolationUnreachable, int64(number), "not reachable from the root")
+		}
+	}
+	return c.violations, nil
+}
+
+// report records a violation.
+func (c *checker) report(kind ViolationKind, block int64, format string, args ...any) {
+	c.violations = append(c.violations, Violation{Kind: kind, Block: block, Problem: fmt.Sprintf(format, args...)})
+}
+
+// check checks the subtree rooted at the block, found at the given depth, whose keys must come after
+// low and before high.
+func (c *checker) check(number int64, depth int, low, high separator) error {
+	c.seen[number] = true
+	block, err := c.bs.readBlock(number)
+	if errors.Is(err, ErrCorruptBlock) {
+		c.report(ViolationBlock, number, "%v", errors.Unwrap(err))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if block.id != uint64(number) {
+		c.report(ViolationBlock, number, "records block ID %d", block.id)
+	}
+
+	keys, children := block.dataSet, block.childrenBlockIds
+	if number != 0 && len(keys) < minKeys {
+		c.report(ViolationFill, number, "holds %d keys, fewer than the minimum %d", len(keys), minKeys)
+	}
+	if number == 0 && len(keys) == 0 && len(children) > 0 {
+		c.report(ViolationFill, number, "the root has children but no keys")
+	}
+	for i, p := range keys {
+		if i > 0 && p.key == keys[i-1].key {
+			c.report(ViolationDuplicate, number, "key %q is stored twice", p.key)
+		} else if i > 0 && p.key < keys[i-1].key {
+			c.report(ViolationOrder, number, "key %q comes after %q", p.key, keys[i-1].key)
+		}
+		if low.set && p.key == low.key || high.set && p.key == high.key {
+			c.report(ViolationDuplicate, number, "key %q is also a separator of an ancestor", p.key)
+		} else if low.set && p.key < low.key {
+			c.report(ViolationRange, number, "key %q is not after the separator %q in block %d", p.key, low.key, low.block)
+		} else if high.set && p.key > high.key {
+			c.report(ViolationRange, number, "key %q is not before the separator %q in block %d", p.key, high.key, high.block)
+		}
+	}
+
+	if len(children) == 0 {
+		if c.leafDepth == 0 {
+			c.leafDepth = depth
+		} else if depth != c.leafDepth {
+			c.report(ViolationDepth, number, "leaf at depth %d, while the first leaf is at depth %d", depth, c.leafDepth)
+		}
+		return nil
+	}
+	if len(children) != len(keys)+1 {
+		c.report(ViolationChildren, number, "has %d keys and %d children", len(keys), len(children))
+	}
+	for i, child := range children {
+		switch {
+		case child >= uint64(c.pages):
+			c.report(ViolationPointer, number, "child %d points at block %d, past the end of the file of %d blocks", i, child, c.pages)
+		case child == 0:
+			c.report(ViolationPointer, number, "child %d points at the root", i)
+		case c.seen[child]:
+			c.report(ViolationPointer, number, "child %d points at block %d, which was reached before", i, child)
+		default:
+			childLow, childHigh := low, high
+			if i > 0 && i <= len(keys) {
+				childLow = separator{key: keys[i-1].key, block: number, set: true}
+			}
+			if i < len(keys) {
+				childHigh = separator{key: keys[i].key, block: number, set: true}
+			}
+			if err := c.check(int64(child), depth+1, childLow, childHigh); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Check checks the invariants of the B-tree and returns the violations found. A sound tree has none but
+// the blocks of kind ViolationUnreachable, which splits and merges leave behind until Compact reclaims
+// them. Check holds the read lock while it reads every block of the file, bypassing the block cache, so
+// it is meant for tests and for verifying a database after a crash, not for frequent monitoring.
+func (db *DB) Check() ([]Violation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.storage == nil {
+		return nil, ErrClosed
+	}
+	return checkTree(db.blocks)
+}
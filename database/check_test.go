@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// buildTestFile writes a tree of size keys, filling every node with fill keys, to a new file at path,
+// and returns the block service of the file.
+func buildTestFile(t *testing.T, path string, size, fill int) *blockService {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	bs := newBlockService(f)
+	b, err := newTreeBuilder(context.Background(), bs, fill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < size; i++ {
+		if err := b.add(fmt.Sprintf("key%05d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.finish(); err != nil {
+		t.Fatal(err)
+	}
+	return bs
+}
+
+// writeRawBlock overwrites the block with the given number with the block, bypassing the checks of
+// writeBlockToDisk, so the block can record another ID than its number.
+func writeRawBlock(t *testing.T, bs *blockService, number int64, block *diskBlock) {
+	t.Helper()
+	if _, err := bs.file.WriteAt(bs.getBufferFromBlock(block), number*blockSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	// Each test breaks a tree of three levels, of which n holds the root, its first child and the first leaf
+	tests := []struct {
+		name    string
+		kind    ViolationKind
+		corrupt func(t *testing.T, bs *blockService, n [3]*DiskNode)
+	}{
+		{"sound", "", func(t *testing.T, bs *blockService, n [3]*DiskNode) {}},
+		{"order", ViolationOrder, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			n[2].keys[0], n[2].keys[1] = n[2].keys[1], n[2].keys[0]
+			bs.updateNodeToDisk(n[2])
+		}},
+		{"range", ViolationRange, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			n[2].keys[len(n[2].keys)-1] = newPair("zzz", "1")
+			bs.updateNodeToDisk(n[2])
+		}},
+		{"duplicate", ViolationDuplicate, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			n[2].keys[1] = n[2].keys[0]
+			bs.updateNodeToDisk(n[2])
+		}},
+		{"duplicate separator", ViolationDuplicate, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			n[2].keys[len(n[2].keys)-1] = n[1].keys[0]
+			bs.updateNodeToDisk(n[2])
+		}},
+		{"fill", ViolationFill, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			n[2].keys = n[2].keys[:minKeys-1]
+			bs.updateNodeToDisk(n[2])
+		}},
+		{"children", ViolationChildren, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			n[1].childrenBlockIDs = n[1].childrenBlockIDs[:len(n[1].childrenBlockIDs)-1]
+			bs.updateNodeToDisk(n[1])
+		}},
+		{"past the end", ViolationPointer, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			n[1].childrenBlockIDs[0] = 99999
+			bs.updateNodeToDisk(n[1])
+		}},
+		{"reached twice", ViolationPointer, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			n[1].childrenBlockIDs[1] = n[1].childrenBlockIDs[0]
+			bs.updateNodeToDisk(n[1])
+		}},
+		{"depth", ViolationDepth, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			n[0].childrenBlockIDs[0] = n[2].blockID
+			bs.updateRootNode(n[0])
+		}},
+		{"unreachable", ViolationUnreachable, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			if _, err := bs.newBlock(); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"block ID", ViolationBlock, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			block := bs.convertDiskNodeToBlock(n[2])
+			block.id = 7
+			writeRawBlock(t, bs, int64(n[2].blockID), block)
+		}},
+		{"corrupted", ViolationBlock, func(t *testing.T, bs *blockService, n [3]*DiskNode) {
+			// The number of pairs recorded after the block ID is more than the block can hold
+			if _, err := bs.file.WriteAt(uint64ToBytes(1000), int64(n[2].blockID)*blockSize+8); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bs := buildTestFile(t, filepath.Join(t.TempDir(), "check.db"), 1000, minKeys)
+			root, err := bs.getNodeAtBlockID(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := [3]*DiskNode{root}
+			for i := 1; i < len(n); i++ {
+				if n[i], err = n[i-1].getChildAtIndex(0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !n[2].isLeaf() {
+				t.Fatal("expected a tree of three levels")
+			}
+			test.corrupt(t, bs, n)
+
+			violations, err := checkTree(bs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.kind == "" && len(violations) > 0 {
+				t.Errorf("expected no violations, got %v", violations)
+			}
+			found := false
+			for _, v := range violations {
+				found = found || v.Kind == test.kind
+			}
+			if test.kind != "" && !found {
+				t.Errorf("expected a violation of kind %s, got %v", test.kind, violations)
+			}
+		})
+	}
+}
+
+// checkTestDB checks that the tree of the database breaks no invariant, though blocks may be unreachable
+// unless the database was just compacted, and that it holds the keys of the model in order.
+func checkTestDB(t *testing.T, db *DB, model map[string]string, compacted bool) {
+	t.Helper()
+	violations, err := db.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range violations {
+		if v.Kind != ViolationUnreachable || compacted {
+			t.Fatalf("unexpected violation %v", v)
+		}
+	}
+	var got []string
+	if err := db.ForEach(func(key, value string) error {
+		if model[key] != value {
+			t.Fatalf("expected %s=%q, got %q", key, model[key], value)
+		}
+		got = append(got, key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(model) || !slices.IsSorted(got) {
+		t.Fatalf("expected the %d keys of the model in order, got %d keys", len(model), len(got))
+	}
+}
+
+func TestCheckRandomOperations(t *testing.T) {
+	for seed := int64(1); seed <= 3; seed++ {
+		path := filepath.Join(t.TempDir(), fmt.Sprint("random", seed, ".db"))
+		db, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close(path)
+		r := rand.New(rand.NewSource(seed))
+		model := make(map[string]string)
+		var keys []string
+
+		// The tree grows to three levels in the first half of the sequences, and shrinks in the second half
+		for sequence := 0; sequence < 60; sequence++ {
+			puts := 8
+			if sequence >= 30 {
+				puts = 2
+			}
+			for op := 0; op < 50; op++ {
+				if key := fmt.Sprintf("key%04d", r.Intn(5000)); r.Intn(10) < puts {
+					if _, ok := model[key]; ok {
+						continue
+					}
+					if err := db.Put(key, fmt.Sprint(sequence)); err != nil {
+						t.Fatal(err)
+					}
+					model[key] = fmt.Sprint(sequence)
+					keys = append(keys, key)
+				} else if len(keys) > 0 {
+					i := r.Intn(len(keys))
+					if err := db.Del(keys[i]); err != nil {
+						t.Fatalf("seed %d: deleting %s: %v", seed, keys[i], err)
+					}
+					delete(model, keys[i])
+					keys[i] = keys[len(keys)-1]
+					keys = keys[:len(keys)-1]
+				}
+			}
+			checkTestDB(t, db, model, false)
+			if sequence == 29 {
+				if stats, err := db.Stats(); err != nil || stats.Depth < 3 {
+					t.Fatalf("expected the tree to grow to three levels, got %+v, %v", stats, err)
+				}
+				if _, err := db.Compact(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				checkTestDB(t, db, model, true)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const CompactSuffix = ".compact" // Suffix of the file Compact writes next to the database file before swapping it in.
+
+// Compact rebuilds the database into a fresh file and swaps it in, returning the number of bytes reclaimed,
+// which is negative if the file grew. Blocks orphaned by merges are dropped, and the nodes are filled
+// bottom-up to the fill factor set with WithFillFactor.
+// Writers wait until Compact returns, but readers keep working on the current file while the new one is
+// written to path.compact; it then replaces the database file with a rename, under the write lock.
+// If the context is canceled first, the database file is left untouched.
+func (db *DB) Compact(ctx context.Context) (int64, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+
+	db.mu.RLock()
+	if db.storage == nil {
+		db.mu.RUnlock()
+		return 0, ErrClosed
+	}
+	bs := db.blocks
+	if bs == nil {
+		db.mu.RUnlock()
+		return 0, errors.New("unexpected root node type")
+	}
+	path := bs.file.Name()
+	f, err := writeTree(ctx, path+CompactSuffix, fillKeys(db.fillFactor), db.forEach)
+	db.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	reclaimed, size, err := db.swapFile(f, path)
+	if err != nil {
+		return 0, err
+	}
+	db.logger.Info("compacted database", "path", path, "size", size, "reclaimed", reclaimed)
+	return reclaimed, nil
+}
+
+// writeTree creates a database file at path with the pairs that pairs passes to add, which must come in
+// key order, and syncs it. On error the file is removed.
+func writeTree(ctx context.Context, path string, fill int, pairs func(add func(key, value string) error) error) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	b, err := newTreeBuilder(ctx, newBlockService(f), fill)
+	if err == nil {
+		err = pairs(b.add)
+	}
+	if err == nil {
+		err = b.finish()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return f, nil
+}
+
+// swapFile renames a rebuilt file over the database file and continues with it, under the write lock.
+// It returns the number of bytes reclaimed and the size of the new file. The blocks that backups in
+// progress still need are kept in memory first, since the old file goes away.
+func (db *DB) swapFile(f *os.File, path string) (int64, int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	bs := db.blocks
+	oldInfo, err := bs.file.Stat()
+	if err == nil {
+		err = bs.preserveFile()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return 0, 0, err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		db.logger.Warn("syncing the database directory", "dir", filepath.Dir(path), "err", err)
+	}
+	newInfo, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	bs.backups.mu.Lock()
+	old := bs.file
+	bs.file = f
+	bs.backups.mu.Unlock()
+	old.Close()
+	rootBlock, err := bs.getRootBlock()
+	if err != nil {
+		return 0, 0, err
+	}
+	db.storage.root = bs.convertBlockToDiskNode(rootBlock)
+	return oldInfo.Size() - newInfo.Size(), newInfo.Size(), nil
+}
+
+// syncDir flushes a directory, so a file renamed into it stays there after a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
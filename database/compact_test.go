@@ -0,0 +1,195 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// checkShape walks the tree under a node and checks that the keys are ordered, that every node other
+// than the root holds between minKeys and maxLeafSize keys, and that every leaf is at the same depth.
+// It returns the keys in order and the depth of the leaves.
+func checkShape(t *testing.T, n *DiskNode, root bool) ([]string, int) {
+	t.Helper()
+	if len(n.keys) > maxLeafSize || (!root && len(n.keys) < minKeys) {
+		t.Fatalf("block %d holds %d keys", n.blockID, len(n.keys))
+	}
+	if n.isLeaf() {
+		var keys []string
+		for _, p := range n.keys {
+			keys = append(keys, p.key)
+		}
+		return keys, 1
+	}
+	if len(n.childrenBlockIDs) != len(n.keys)+1 {
+		t.Fatalf("block %d has %d keys and %d children", n.blockID, len(n.keys), len(n.childrenBlockIDs))
+	}
+	var keys []string
+	depth := -1
+	for i := range n.childrenBlockIDs {
+		child, err := n.getChildAtIndex(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		childKeys, childDepth := checkShape(t, child, false)
+		if depth >= 0 && childDepth != depth {
+			t.Fatalf("leaves of block %d are at depths %d and %d", n.blockID, depth, childDepth)
+		}
+		depth = childDepth
+		keys = append(keys, childKeys...)
+		if i < len(n.keys) {
+			keys = append(keys, n.keys[i].key)
+		}
+	}
+	return keys, depth + 1
+}
+
+func TestBuildTree(t *testing.T) {
+	for _, size := range []int{0, 1, 15, 16, 30, 31, 32, 46, 47, 500, 961, 5000} {
+		for _, fill := range []int{minKeys, 27, maxLeafSize} {
+			f, err := os.Create(filepath.Join(t.TempDir(), "tree.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			bs := newBlockService(f)
+			b, err := newTreeBuilder(context.Background(), bs, fill)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < size; i++ {
+				if err := b.add(fmt.Sprintf("key%05d", i), fmt.Sprint(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := b.finish(); err != nil {
+				t.Fatal(err)
+			}
+			root, err := bs.getNodeAtBlockID(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys, _ := checkShape(t, root, true)
+			if len(keys) != size {
+				t.Fatalf("expected %d keys with %d per node, got %d", size, fill, len(keys))
+			}
+			for i, key := range keys {
+				if key != fmt.Sprintf("key%05d", i) {
+					t.Fatalf("expected key%05d at position %d, got %s", i, i, key)
+				}
+			}
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compact.db")
+	db, err := Open(path, WithFillFactor(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(fmt.Sprintf("key%04d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, _ := db.Stats()
+
+	// Readers keep going while the file is rebuilt
+	var readers sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("key%04d", i%1000)
+				if _, ok, err := db.Get(key); err != nil || !ok {
+					t.Errorf("expected %s during compaction, got %v, %v", key, ok, err)
+					return
+				}
+			}
+		}()
+	}
+	reclaimed, err := db.Compact(context.Background())
+	close(stop)
+	readers.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, _ := db.Stats()
+	if reclaimed <= 0 || reclaimed != before.FileSize-after.FileSize {
+		t.Errorf("expected %d bytes reclaimed, got %d", before.FileSize-after.FileSize, reclaimed)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("expected the compacted file to be renamed, got %v", err)
+	}
+
+	// The compacted tree takes writes and holds every key
+	for i := 1000; i < 1100; i++ {
+		if err := db.Put(fmt.Sprintf("key%04d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 1100; i++ {
+		if value, ok, _ := db.Get(fmt.Sprintf("key%04d", i)); !ok || value != fmt.Sprint(i) {
+			t.Fatalf("expected key%04d after compaction, got %q, %v", i, value, ok)
+		}
+	}
+
+	// A reopened database reads the compacted file
+	db.Close(path)
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, _ := db.Get("key0999"); !ok || value != "999" {
+		t.Errorf("expected the compacted file after reopening, got %q, %v", value, ok)
+	}
+}
+
+func TestCompactCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compact.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	for i := 0; i < 100; i++ {
+		db.Put(fmt.Sprintf("key%03d", i), fmt.Sprint(i))
+	}
+	before, _ := db.Stats()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.Compact(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the compaction to be canceled, got %v", err)
+	}
+	if after, _ := db.Stats(); after.FileSize != before.FileSize {
+		t.Errorf("expected a canceled compaction to leave the file alone")
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("expected no compacted file to be left behind, got %v", err)
+	}
+}
+
+func TestFillFactor(t *testing.T) {
+	if n := fillKeys(0.1); n != minKeys {
+		t.Errorf("expected a low fill factor to be clamped to %d keys, got %d", minKeys, n)
+	}
+	if n := fillKeys(2); n != maxLeafSize {
+		t.Errorf("expected a high fill factor to be clamped to %d keys, got %d", maxLeafSize, n)
+	}
+	if n := fillKeys(defaultFillFactor); n != 27 {
+		t.Errorf("expected 27 keys by default, got %d", n)
+	}
+}
@@ -3,16 +3,31 @@ package db
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // DB represents a connection to a database, managing access to its B-tree structure.
 // It provides methods for inserting, retrieving, and deleting key-value pairs in a thread-safe manner.
 type DB struct {
-	storage *btree       // The B-tree used for storing data.
-	mu      sync.RWMutex // The read-write mutex to synchronize database operations.
+	storage    *btree                      // The B-tree used for storing data.
+	mu         sync.RWMutex                // The read-write mutex to synchronize database operations.
+	writeMu    sync.Mutex                  // Serializes the writers ahead of mu, so Compact can hold them back while readers go on.
+	watchMu    sync.Mutex                  // The mutex protecting the registered watchers.
+	watchers   map[<-chan Event]*watcher   // The change listeners registered through Watch.
+	blocks     *blockService               // The block storage of the B-tree, for its counters.
+	gets       atomic.Uint64               // Number of lookups.
+	puts       atomic.Uint64               // Number of insertions.
+	dels       atomic.Uint64               // Number of deletions.
+	keys       atomic.Int64                // Number of key-value pairs stored.
+	latency    atomic.Pointer[LatencyFunc] // Receives the durations of internal events; nil if none is registered.
+	apply      atomic.Pointer[ApplyFunc]   // Receives every applied change; nil if none is registered.
+	logger     *slog.Logger                // Destination of the log records of the database.
+	fillFactor float64                     // Share of a full node that Compact and BulkLoad fill nodes with.
 }
 
 // dbConnections manages multiple database instances, ensuring each instance is unique per file path.
@@ -26,26 +41,36 @@ var dbConnections = struct {
 
 // Open opens a new database connection at the specified file path.
 // It ensures that the directory exists and creates it if necessary, and returns an existing connection if one already exists.
+// The options only apply when the connection is created, not when an existing one is returned.
 // Parameters:
 // - filePath: The file path where the database should be stored or accessed.
+// - opts: Options such as WithLogger.
 // Returns: A pointer to the DB instance, and an error if the connection cannot be established.
-func Open(filePath string) (*DB, error) {
+func Open(filePath string, opts ...Option) (*DB, error) {
 	dbConnections.mu.Lock()
 	defer dbConnections.mu.Unlock()
 
+	// Return the existing connection if one already exists for the given file path.
+	if db, exists := dbConnections.instances[filePath]; exists {
+		return db, nil
+	}
+	db := &DB{
+		mu:         sync.RWMutex{},
+		watchers:   make(map[<-chan Event]*watcher),
+		logger:     slog.Default(),
+		fillFactor: defaultFillFactor,
+	}
+	for _, opt := range opts {
+		opt(db)
+	}
+
 	// Ensure the directory for the database file exists, create it if not.
 	dir := filepath.Dir(filePath)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		err := os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			fmt.Printf("Error creating directory: %v\n", err)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return nil, fmt.Errorf("creating directory %s: %w", dir, err)
 		}
-		fmt.Printf("Directory created: %s\n", dir)
-	}
-
-	// Return the existing connection if one already exists for the given file path.
-	if db, exists := dbConnections.instances[filePath]; exists {
-		return db, nil
+		db.logger.Info("created database directory", "dir", dir)
 	}
 
 	// Create a new connection and B-tree storage if no connection exists for the file path.
@@ -53,30 +78,55 @@ func Open(filePath string) (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	db := &DB{
-		storage: storage,
-		mu:      sync.RWMutex{},
+	db.storage = storage
+	if root, ok := storage.root.(*DiskNode); ok {
+		db.blocks = root.blockService
+	}
+	if err := db.countKeys(); err != nil {
+		return nil, err
 	}
 	// Save the new DB instance to the map of database instances.
 	dbConnections.instances[filePath] = db
+	db.logger.Debug("opened database", "path", filePath)
 	return db, nil
 }
 
 // Put inserts a key-value pair into the database, ensuring the pair is valid before insertion.
-// The method locks the database for exclusive write access while inserting the pair.
+// The method locks the database for exclusive write access while inserting the pair,
+// and notifies the watchers of the key once the pair is stored.
 // Parameters:
 // - key: The key to be inserted.
 // - value: The value associated with the key to be inserted.
 // Returns: An error if the insertion fails.
 func (db *DB) Put(key string, value string) error {
+	// Lock the database for exclusive write access while inserting.
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.put(key, value)
+}
+
+// put validates and inserts a key-value pair and notifies the watchers; the caller holds the write lock.
+func (db *DB) put(key string, value string) error {
+	if db.storage == nil {
+		return ErrClosed
+	}
+	db.puts.Add(1)
 	pair := newPair(key, value)
 	if err := pair.validate(); err != nil {
 		return err
 	}
-	// Lock the database for exclusive write access while inserting.
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.storage.insert(pair)
+	start, splits := time.Now(), db.Counters().Splits
+	if err := db.storage.insert(pair); err != nil {
+		return err
+	}
+	db.keys.Add(1)
+	if db.Counters().Splits != splits {
+		db.reportLatency(LatencyBtreeSplit, start)
+	}
+	db.notify(Event{Key: key, Op: OpPut, Value: value})
+	return nil
 }
 
 // Get retrieves the value associated with a key from the database.
@@ -87,38 +137,111 @@ func (db *DB) Put(key string, value string) error {
 func (db *DB) Get(key string) (string, bool, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	return db.get(key)
+}
+
+// get retrieves the value of a key; the caller holds the read or write lock.
+func (db *DB) get(key string) (string, bool, error) {
+	if db.storage == nil {
+		return "", false, ErrClosed
+	}
+	db.gets.Add(1)
 	return db.storage.get(key)
 }
 
 // Del deletes the key-value pair associated with the specified key from the database.
-// The method locks the database for exclusive write access while deleting the pair.
+// The method locks the database for exclusive write access while deleting the pair,
+// and notifies the watchers of the key once the pair is removed.
 // Parameters:
 // - key: The key to be deleted.
 // Returns: An error if the deletion fails.
 func (db *DB) Del(key string) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.storage.del(key)
+	return db.del(key)
+}
+
+// del deletes a key-value pair and notifies the watchers; the caller holds the write lock.
+func (db *DB) del(key string) error {
+	if db.storage == nil {
+		return ErrClosed
+	}
+	db.dels.Add(1)
+	start, merges := time.Now(), db.Counters().Merges
+	if err := db.storage.del(key); err != nil {
+		return err
+	}
+	db.keys.Add(-1)
+	// Each level a merge leaves under the minimum fill merges in turn, so more than one merge is a cascade
+	switch db.Counters().Merges - merges {
+	case 0:
+	case 1:
+		db.reportLatency(LatencyBtreeMerge, start)
+	default:
+		db.reportLatency(LatencyBtreeMergeCascade, start)
+	}
+	db.notify(Event{Key: key, Op: OpDel})
+	return nil
+}
+
+// Clear removes every key-value pair by truncating the database file and starting over with an empty root.
+// Watchers are not notified of the removed keys.
+func (db *DB) Clear() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.clear()
+}
+
+// clear empties the database; the caller holds the write lock.
+func (db *DB) clear() error {
+	if db.storage == nil {
+		return ErrClosed
+	}
+	bs := db.blocks
+	if bs == nil {
+		return errors.New("unexpected root node type")
+	}
+	if err := bs.preserveFile(); err != nil {
+		return err
+	}
+	if err := bs.file.Truncate(0); err != nil {
+		return err
+	}
+	rootBlock, err := bs.newBlock()
+	if err != nil {
+		return err
+	}
+	db.storage.root = bs.convertBlockToDiskNode(rootBlock)
+	db.keys.Store(0)
+	return nil
 }
 
 // Close closes the database connection for the specified file path and releases associated resources.
 // The method ensures the database is not already closed before proceeding with the closure.
 // Parameters:
 // - filePath: The file path of the database to be closed.
-// Returns: An error if the database is already closed or if any issues arise during the closure.
+// Returns: ErrClosed if the database is already closed, or an error if any issues arise during the closure.
 func (db *DB) Close(filePath string) error {
 	dbConnections.mu.Lock()
 	defer dbConnections.mu.Unlock()
 
 	// Return an error if the database is already closed.
 	if db.storage == nil {
-		return errors.New("database already closed")
+		return ErrClosed
 	}
 
 	// Lock the database for exclusive write access before closing it.
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	delete(dbConnections.instances, filePath)
 	db.storage = nil // Mark the storage as closed
+	db.closeWatchers()
+	db.logger.Debug("closed database", "path", filePath)
 	return nil
 }
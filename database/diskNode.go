@@ -2,6 +2,8 @@ package db
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 )
 
@@ -63,7 +65,8 @@ func (n *DiskNode) isLeaf() bool {
 }
 
 // PrintTree traverses and prints the entire tree rooted at the current node.
-func (n *DiskNode) printTree(level int) {
+// It returns the error of the first child that cannot be read.
+func (n *DiskNode) printTree(level int) error {
 	currentLevel := level
 	if level == 0 {
 		currentLevel = 1
@@ -74,10 +77,13 @@ func (n *DiskNode) printTree(level int) {
 		fmt.Println("Printing ", i+1, " th child of level : ", currentLevel)
 		childNode, err := n.getChildAtIndex(i)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		if err := childNode.printTree(currentLevel + 1); err != nil {
+			return err
 		}
-		childNode.printTree(currentLevel + 1)
 	}
+	return nil
 }
 
 /**
@@ -196,6 +202,7 @@ func (n *DiskNode) splitLeafNode() (*pairs, *DiskNode, *DiskNode, error) {
 	        	3. Since the current node is a leaf node, we do not need to worry about its children and we can leave them to be null for both
 	        	4. return middle,leftNode,rightNode
 	*/
+	n.blockService.splits.Add(1)
 	elements := n.getElements()
 	midIndex := len(elements) / 2
 	middle := elements[midIndex]
@@ -230,6 +237,7 @@ func (n *DiskNode) splitNonLeafNode() (*pairs, *DiskNode, *DiskNode, error) {
 
 		NOTE : NODE CREATION WILL TAKE PLACE HERE
 	*/
+	n.blockService.splits.Add(1)
 	elements := n.getElements()
 	midIndex := len(elements) / 2
 	middle := elements[midIndex]
@@ -477,233 +485,164 @@ func (n *DiskNode) deletePair(value *pairs, bt *btree) error {
 	return n.delete(value.key, bt)
 }
 
-// delete deletes a key from the B-tree.
+// delete deletes a key from the B-tree rooted at the node.
 func (n *DiskNode) delete(key string, bt *btree) error {
-	// First locate the leaf node containing the key
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	if !n.isLeaf() {
-		childNode, err := n.getChildNodeForElement(key)
+	if err := n.remove(key); err != nil {
+		return err
+	}
+	// A root left without keys by a merge of its last two children is replaced by the merged child,
+	// which makes the tree one level shorter; the root stays in block 0
+	if len(n.keys) == 0 && len(n.childrenBlockIDs) == 1 {
+		child, err := n.getChildAtIndex(0)
 		if err != nil {
 			return err
 		}
-		return childNode.delete(key, bt)
+		n.keys, n.childrenBlockIDs = child.keys, child.childrenBlockIDs
+		return n.blockService.updateRootNode(n)
 	}
+	return nil
+}
 
-	// We are at leaf node, try to delete the key
-	elements := n.getElements()
-	indexToDelete := -1
-	for i, element := range elements {
-		if element.key == key {
-			indexToDelete = i
-			break
+// remove deletes a key from the subtree rooted at the node and writes the nodes it changes.
+// The node may be left with fewer keys than the minimum, which its parent then fixes with rebalanceChild.
+func (n *DiskNode) remove(key string) error {
+	/*
+		Algo:
+		1. Find the first key of the node not less than the key
+		2. In a leaf, remove the key, or fail if it is not there
+		3. In an internal node holding the key, replace it with its predecessor, the last key of the
+		   child before it, and remove the predecessor from that child instead
+		4. Otherwise remove the key from the child whose range holds it
+		5. Rebalance the child if it was left under the minimum
+	*/
+	index, found := n.findKeyIndex(key)
+	if n.isLeaf() {
+		if !found {
+			return fmt.Errorf("key %s not found", key)
 		}
-	}
-
-	if indexToDelete == -1 {
-		return fmt.Errorf("key %s not found", key)
-	}
-
-	// Remove the element
-	n.setElements(append(elements[:indexToDelete], elements[indexToDelete+1:]...))
-
-	// Check if node has underflown
-	minSize := (n.blockService.getMaxLeafSize() + 1) / 2
-	if len(n.getElements()) >= minSize || bt.isRootNode(n) {
-		// Node has enough elements or is root, just update it
+		n.setElements(slices.Delete(n.getElements(), index, index+1))
 		return n.blockService.updateNodeToDisk(n)
 	}
 
-	// Handle underflow by either borrowing or merging
-	return n.handleUnderflow(bt)
-}
-
-// findParentInfo returns the parent node, index of the current node in parent's children,
-// and any error that occurred
-type parentInfo struct {
-	// parent is the parent node.
-	parent *DiskNode
-	// index is the index of the current node in the parent's children.
-	index int
-}
-
-// findParentInfo returns the parent node information for the current node.
-func (n *DiskNode) findParentInfo(current node) (*parentInfo, error) {
-	// Type assert to check if current node is a DiskNode
-	diskNode, ok := current.(*DiskNode)
-	if !ok {
-		return nil, fmt.Errorf("expected DiskNode, got %T", current)
-	}
-
-	if diskNode.isLeaf() {
-		return nil, nil
-	}
-
-	// Get all child nodes
-	childNodes, err := diskNode.getChildNodes()
+	child, err := n.getChildAtIndex(index)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	// Check each child
-	for i, child := range childNodes {
-		if child.blockID == n.blockID {
-			return &parentInfo{parent: diskNode, index: i}, nil
-		}
-
-		// Recursively search in child
-		if info, err := n.findParentInfo(child); err != nil {
-			return nil, err
-		} else if info != nil {
-			return info, nil
+	if found {
+		predecessor, err := child.lastElement()
+		if err != nil {
+			return err
 		}
+		n.keys[index] = predecessor
+		key = predecessor.key
 	}
-
-	return nil, nil
-}
-
-// handleUnderflow handles node underflow by borrowing or merging with sibling nodes.
-func (n *DiskNode) handleUnderflow(bt *btree) error {
-	// Find parent information
-	parentInfo, err := n.findParentInfo(bt.root)
-	if err != nil {
+	if err := child.remove(key); err != nil {
 		return err
 	}
-	if parentInfo == nil {
-		return fmt.Errorf("parent not found for node")
+	if len(child.getElements()) < minKeys {
+		if err := n.rebalanceChild(index, child); err != nil {
+			return err
+		}
 	}
+	return n.blockService.updateNodeToDisk(n)
+}
 
-	parent := parentInfo.parent
-	parentIndex := parentInfo.index
+// findKeyIndex returns the index of the first key of the node not less than the key, which is also the
+// index of the child whose range holds the key, and whether that key is the key.
+func (n *DiskNode) findKeyIndex(key string) (int, bool) {
+	return slices.BinarySearchFunc(n.getElements(), key, func(element *pairs, key string) int {
+		return strings.Compare(element.key, key)
+	})
+}
 
-	// Try to borrow from left sibling
-	if parentIndex > 0 {
-		leftSibling, err := parent.getChildAtIndex(parentIndex - 1)
+// lastElement returns the last key-value pair of the subtree rooted at the node, which is found in its
+// rightmost leaf.
+func (n *DiskNode) lastElement() (*pairs, error) {
+	for !n.isLeaf() {
+		child, err := n.getLastChildNode()
 		if err != nil {
-			return err
-		}
-
-		if len(leftSibling.getElements()) > (n.blockService.getMaxLeafSize()+1)/2 {
-			return n.borrowFromLeft(leftSibling, parent, parentIndex)
+			return nil, err
 		}
+		n = child
 	}
+	return n.getElementAtIndex(len(n.getElements()) - 1), nil
+}
 
-	// Try to borrow from right sibling
-	if parentIndex < len(parent.childrenBlockIDs)-1 {
-		rightSibling, err := parent.getChildAtIndex(parentIndex + 1)
-		if err != nil {
+// rebalanceChild brings the child at the index, which holds fewer keys than the minimum, back to the minimum.
+// It moves a key through the node from a sibling that can spare one, or else merges the child with a
+// sibling and the key of the node separating them, leaving the block of the right one unreachable.
+// It writes the children it changes; the caller writes the node.
+func (n *DiskNode) rebalanceChild(index int, child *DiskNode) error {
+	var left, right *DiskNode
+	var err error
+	if index > 0 {
+		if left, err = n.getChildAtIndex(index - 1); err != nil {
 			return err
 		}
-
-		if len(rightSibling.getElements()) > (n.blockService.getMaxLeafSize()+1)/2 {
-			return n.borrowFromRight(rightSibling, parent, parentIndex)
+		if len(left.getElements()) > minKeys {
+			return n.rotateRight(index, left, child)
 		}
 	}
-
-	// If we can't borrow, we need to merge
-	// Prefer merging with left sibling if possible
-	if parentIndex > 0 {
-		leftSibling, err := parent.getChildAtIndex(parentIndex - 1)
-		if err != nil {
+	if index < len(n.childrenBlockIDs)-1 {
+		if right, err = n.getChildAtIndex(index + 1); err != nil {
 			return err
 		}
-		return n.mergeWithLeft(leftSibling, parent, parentIndex, bt)
-	}
-
-	// Otherwise merge with right sibling
-	rightSibling, err := parent.getChildAtIndex(parentIndex + 1)
-	if err != nil {
-		return err
-	}
-	return n.mergeWithRight(rightSibling, parent, parentIndex, bt)
-}
-
-// borrowFromLeft borrows an element from the left sibling node.
-func (n *DiskNode) borrowFromLeft(leftSibling *DiskNode, parent *DiskNode, parentIndex int) error {
-	// Move the rightmost element from left sibling to current node
-	elements := leftSibling.getElements()
-	borrowedElement := elements[len(elements)-1]
-	leftSibling.setElements(elements[:len(elements)-1])
-
-	// Add borrowed element to current node
-	n.setElements(append([]*pairs{borrowedElement}, n.getElements()...))
-
-	// Update parent's key
-	parent.keys[parentIndex-1] = n.getElements()[0]
-
-	// Save changes to disk
-	if err := leftSibling.blockService.updateNodeToDisk(leftSibling); err != nil {
-		return err
+		if len(right.getElements()) > minKeys {
+			return n.rotateLeft(index, child, right)
+		}
 	}
-	if err := n.blockService.updateNodeToDisk(n); err != nil {
-		return err
+	if left != nil {
+		return n.mergeChildren(index-1, left, child)
 	}
-	return parent.blockService.updateNodeToDisk(parent)
+	return n.mergeChildren(index, child, right)
 }
 
-// borrowFromRight borrows an element from the right sibling node.
-func (n *DiskNode) borrowFromRight(rightSibling *DiskNode, parent *DiskNode, parentIndex int) error {
-	// Move the leftmost element from right sibling to current node
-	elements := rightSibling.getElements()
-	borrowedElement := elements[0]
-	rightSibling.setElements(elements[1:])
-
-	// Add borrowed element to current node
-	n.setElements(append(n.getElements(), borrowedElement))
-
-	// Update parent's key
-	parent.keys[parentIndex] = rightSibling.getElements()[0]
-
-	// Save changes to disk
-	if err := rightSibling.blockService.updateNodeToDisk(rightSibling); err != nil {
-		return err
+// rotateRight moves the last key of the left sibling up into the node and the separating key of the node
+// down into the child at the index, together with the last child of the sibling.
+func (n *DiskNode) rotateRight(index int, left, child *DiskNode) error {
+	leftElements := left.getElements()
+	child.setElements(slices.Insert(child.getElements(), 0, n.keys[index-1]))
+	n.keys[index-1] = leftElements[len(leftElements)-1]
+	left.setElements(leftElements[:len(leftElements)-1])
+	if !left.isLeaf() {
+		last := len(left.childrenBlockIDs) - 1
+		child.childrenBlockIDs = slices.Insert(child.childrenBlockIDs, 0, left.childrenBlockIDs[last])
+		left.childrenBlockIDs = left.childrenBlockIDs[:last]
 	}
-	if err := n.blockService.updateNodeToDisk(n); err != nil {
+	if err := n.blockService.updateNodeToDisk(left); err != nil {
 		return err
 	}
-	return parent.blockService.updateNodeToDisk(parent)
+	return n.blockService.updateNodeToDisk(child)
 }
 
-// mergeWithLeft merges the current node with the left sibling node.
-func (n *DiskNode) mergeWithLeft(leftSibling *DiskNode, parent *DiskNode, parentIndex int, bt *btree) error {
-	// Merge current node's elements into left sibling
-	leftSibling.setElements(append(leftSibling.getElements(), n.getElements()...))
-
-	// Update parent's children
-	parent.childrenBlockIDs = append(parent.childrenBlockIDs[:parentIndex], parent.childrenBlockIDs[parentIndex+1:]...)
-	parent.keys = append(parent.keys[:parentIndex-1], parent.keys[parentIndex:]...)
-
-	// Handle parent underflow if needed
-	if len(parent.keys) < (parent.blockService.getMaxLeafSize()+1)/2 && !bt.isRootNode(parent) {
-		return parent.handleUnderflow(bt)
+// rotateLeft moves the first key of the right sibling up into the node and the separating key of the node
+// down into the child at the index, together with the first child of the sibling.
+func (n *DiskNode) rotateLeft(index int, child, right *DiskNode) error {
+	rightElements := right.getElements()
+	child.setElements(append(child.getElements(), n.keys[index]))
+	n.keys[index] = rightElements[0]
+	right.setElements(slices.Delete(rightElements, 0, 1))
+	if !right.isLeaf() {
+		child.childrenBlockIDs = append(child.childrenBlockIDs, right.childrenBlockIDs[0])
+		right.childrenBlockIDs = slices.Delete(right.childrenBlockIDs, 0, 1)
 	}
-
-	// Save changes to disk
-	if err := leftSibling.blockService.updateNodeToDisk(leftSibling); err != nil {
+	if err := n.blockService.updateNodeToDisk(right); err != nil {
 		return err
 	}
-	return parent.blockService.updateNodeToDisk(parent)
+	return n.blockService.updateNodeToDisk(child)
 }
 
-// mergeWithRight merges the current node with the right sibling node.
-func (n *DiskNode) mergeWithRight(rightSibling *DiskNode, parent *DiskNode, parentIndex int, bt *btree) error {
-	// Merge right sibling's elements into current node
-	n.setElements(append(n.getElements(), rightSibling.getElements()...))
-
-	// Update parent's children
-	parent.childrenBlockIDs = append(parent.childrenBlockIDs[:parentIndex+1], parent.childrenBlockIDs[parentIndex+2:]...)
-	parent.keys = append(parent.keys[:parentIndex], parent.keys[parentIndex+1:]...)
-
-	// Handle parent underflow if needed
-	if len(parent.keys) < (parent.blockService.getMaxLeafSize()+1)/2 && !bt.isRootNode(parent) {
-		return parent.handleUnderflow(bt)
-	}
-
-	// Save changes to disk
-	if err := n.blockService.updateNodeToDisk(n); err != nil {
-		return err
-	}
-	return parent.blockService.updateNodeToDisk(parent)
+// mergeChildren merges the child at the index with the one after it, bringing down the key of the node
+// that separates them. Neither can spare a key, so together they fit in a node.
+func (n *DiskNode) mergeChildren(index int, left, right *DiskNode) error {
+	n.blockService.merges.Add(1)
+	left.setElements(slices.Concat(left.getElements(), []*pairs{n.keys[index]}, right.getElements()))
+	left.childrenBlockIDs = slices.Concat(left.childrenBlockIDs, right.childrenBlockIDs)
+	n.keys = slices.Delete(n.keys, index, index+1)
+	n.childrenBlockIDs = slices.Delete(n.childrenBlockIDs, index+1, index+2)
+	return n.blockService.updateNodeToDisk(left)
 }
 
 // getValue returns the value associated with the given key.
@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -277,3 +278,52 @@ func TestAddPoppedupElement(t *testing.T) {
 	}
 
 }
+
+func TestDeleteKeepsInvariants(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delete.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	const count = 2000
+	for i := 0; i < count; i++ {
+		if err := db.Put(fmt.Sprintf("key%04d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deleting every other key and then the rest underflows leaves and inner nodes alike, which must
+	// borrow from or merge with a sibling without leaving a node under the minimum or losing a key
+	var order []int
+	for i := 0; i < count; i += 2 {
+		order = append(order, i)
+	}
+	for i := 1; i < count; i += 2 {
+		order = append(order, i)
+	}
+	for n, i := range order {
+		key := fmt.Sprintf("key%04d", i)
+		if err := db.Del(key); err != nil {
+			t.Fatalf("deleting %s: %v", key, err)
+		}
+		if _, ok, err := db.Get(key); ok || err != nil {
+			t.Fatalf("expected %s to be deleted, got %v, %v", key, ok, err)
+		}
+		if n%50 != 0 {
+			continue
+		}
+		violations, err := db.Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range violations {
+			if v.Kind != ViolationUnreachable {
+				t.Fatalf("after deleting %s: %v", key, v)
+			}
+		}
+		if stats, err := db.Stats(); err != nil || stats.Keys != int64(count-n-1) {
+			t.Fatalf("expected %d keys after deleting %s, got %+v, %v", count-n-1, key, stats, err)
+		}
+	}
+}
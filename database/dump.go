@@ -0,0 +1,215 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Format is an encoding of key-value pairs that Dump writes and Load reads.
+type Format string
+
+const (
+	FormatJSONLines Format = "jsonl" // One JSON object per line, such as {"key":"k","value":"v"}; strings that are not valid UTF-8 are not preserved.
+	FormatCSV       Format = "csv"   // One record per pair with the key and the value as fields, without a header.
+	FormatRDB       Format = "rdb"   // A Redis RDB file, of which Load imports the string keys of database 0; Dump cannot write it.
+)
+
+// loadBatch is the number of pairs Load inserts per write lock, so readers get in between batches.
+const loadBatch = 1000
+
+// ParseFormat returns the format with the given name: jsonl, csv or rdb.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case FormatJSONLines, FormatCSV, FormatRDB:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected jsonl, csv or rdb", name)
+}
+
+// jsonPair is a pair encoded in JSON Lines.
+type jsonPair struct {
+	Key   *string `json:"key"`
+	Value *string `json:"value"`
+}
+
+// Dump writes every key-value pair in key order to w in the format, and returns the number of pairs written.
+// It holds the read lock for the walk, so the dump is a consistent view of the database, and writers wait
+// until it is written.
+func (db *DB) Dump(w io.Writer, format Format) (int, error) {
+	var write func(key, value string) error
+	var flush func() error
+	switch format {
+	case FormatJSONLines:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		enc.SetEscapeHTML(false)
+		write = func(key, value string) error {
+			return enc.Encode(jsonPair{Key: &key, Value: &value})
+		}
+		flush = bw.Flush
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		write = func(key, value string) error {
+			return cw.Write([]string{key, value})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return 0, fmt.Errorf("cannot dump in format %q", format)
+	}
+
+	count := 0
+	err := db.ForEach(func(key, value string) error {
+		count++
+		return write(key, value)
+	})
+	if err == nil {
+		err = flush()
+	}
+	return count, err
+}
+
+// Load inserts the key-value pairs read from r in the format, overwriting the keys that exist, and returns
+// the number of pairs inserted. The pairs are inserted in batches, each under the write lock, and watchers
+// are notified as for Put. Loading stops at the first pair that cannot be read or inserted, keeping the
+// pairs inserted before it. Sorted input can replace the contents of the database faster with BulkLoadFrom.
+func (db *DB) Load(r io.Reader, format Format) (int, error) {
+	pr, err := NewPairReader(r, format)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for done := false; !done; {
+		err := db.Update(func(tx *Tx) error {
+			for i := 0; i < loadBatch; i++ {
+				key, value, err := pr.Next()
+				if err == io.EOF {
+					done = true
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				// Put adds a pair even if the key exists, so the pair it overwrites is deleted first
+				if _, exists, err := tx.Get(key); err != nil {
+					return fmt.Errorf("key %q: %w", key, err)
+				} else if exists {
+					if err := tx.Del(key); err != nil {
+						return fmt.Errorf("key %q: %w", key, err)
+					}
+				}
+				if err := tx.Put(key, value); err != nil {
+					return fmt.Errorf("key %q: %w", key, err)
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+	if rr, ok := pr.(*rdbReader); ok && rr.skipped > 0 {
+		db.logger.Info("skipped keys of the RDB file", "skipped", rr.skipped, "reason", "not a string, in another database than 0, or expired")
+	}
+	return count, nil
+}
+
+// BulkLoadFrom replaces every key-value pair with the pairs read from r in the format, which must come in
+// strictly increasing key order, such as a file written by Dump, and returns the number of pairs loaded.
+// The tree is built as by BulkLoad, and the database is left untouched if a pair cannot be read or loaded.
+func (db *DB) BulkLoadFrom(r io.Reader, format Format) (int, error) {
+	pr, err := NewPairReader(r, format)
+	if err != nil {
+		return 0, err
+	}
+	return db.bulkLoad(func(add func(key, value string) error) error {
+		for {
+			key, value, err := pr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := add(key, value); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// PairReader reads key-value pairs from an encoding, such as a file written by Dump.
+type PairReader interface {
+	// Next returns the next pair, or io.EOF once there are no more.
+	Next() (key, value string, err error)
+}
+
+// NewPairReader returns a reader of the pairs encoded in the format in r.
+func NewPairReader(r io.Reader, format Format) (PairReader, error) {
+	switch format {
+	case FormatJSONLines:
+		return &jsonLinesReader{scanner: bufio.NewScanner(r)}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = 2
+		cr.ReuseRecord = true
+		return &csvReader{reader: cr}, nil
+	case FormatRDB:
+		return newRDBReader(r)
+	}
+	return nil, fmt.Errorf("cannot load format %q", format)
+}
+
+// jsonLinesReader reads pairs in JSON Lines, skipping blank lines.
+type jsonLinesReader struct {
+	scanner *bufio.Scanner // Splits the input into lines.
+	line    int            // Number of the last line read.
+}
+
+// Next implements PairReader.
+func (r *jsonLinesReader) Next() (string, string, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var p jsonPair
+		if err := json.Unmarshal(line, &p); err != nil {
+			return "", "", fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if p.Key == nil || p.Value == nil {
+			return "", "", fmt.Errorf("line %d: expected an object with a key and a value", r.line)
+		}
+		return *p.Key, *p.Value, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return "", "", fmt.Errorf("line %d: %w", r.line+1, err)
+	}
+	return "", "", io.EOF
+}
+
+// csvReader reads pairs in CSV.
+type csvReader struct {
+	reader *csv.Reader // Parses the records, which must have two fields.
+}
+
+// Next implements PairReader.
+func (r *csvReader) Next() (string, string, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", "", io.EOF
+		}
+		return "", "", err
+	}
+	return record[0], record[1], nil
+}
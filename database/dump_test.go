@@ -0,0 +1,134 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "source.db")
+	src, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close(path)
+	pairs := map[string]string{"b": "2", "a": "1", "quote": `say "hi", <ok>`, "line": "x\ny"}
+	for key, value := range pairs {
+		if err := src.Put(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []Format{FormatJSONLines, FormatCSV} {
+		var buf bytes.Buffer
+		count, err := src.Dump(&buf, format)
+		if err != nil || count != len(pairs) {
+			t.Fatalf("%s: expected %d pairs dumped, got %d, %v", format, len(pairs), count, err)
+		}
+		if format == FormatJSONLines && !strings.HasPrefix(buf.String(), `{"key":"a","value":"1"}`+"\n") {
+			t.Errorf("expected the pairs in key order, got %q", buf.String())
+		}
+
+		dstPath := filepath.Join(t.TempDir(), "destination.db")
+		dst, err := Open(dstPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dst.Close(dstPath)
+		if count, err := dst.Load(&buf, format); err != nil || count != len(pairs) {
+			t.Fatalf("%s: expected %d pairs loaded, got %d, %v", format, len(pairs), count, err)
+		}
+		for key, value := range pairs {
+			if got, ok, _ := dst.Get(key); !ok || got != value {
+				t.Errorf("%s: expected %s=%q, got %q, %v", format, key, value, got, ok)
+			}
+		}
+	}
+	if _, err := src.Dump(&bytes.Buffer{}, FormatRDB); err == nil {
+		t.Error("expected RDB dumps to be refused")
+	}
+}
+
+func TestBulkLoadFrom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bulk.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	if count, err := db.BulkLoadFrom(strings.NewReader("a,1\nb,2\nc,3\n"), FormatCSV); err != nil || count != 3 {
+		t.Fatalf("expected 3 pairs loaded, got %d, %v", count, err)
+	}
+
+	// A pair that cannot be read leaves the database as it was
+	if _, err := db.BulkLoadFrom(strings.NewReader("d,4\ne\n"), FormatCSV); err == nil {
+		t.Fatal("expected the malformed record to be reported")
+	}
+	if value, ok, _ := db.Get("c"); !ok || value != "3" {
+		t.Errorf("expected the database to be untouched, got %q, %v", value, ok)
+	}
+	if _, ok, _ := db.Get("d"); ok {
+		t.Error("expected no pair of the failed load")
+	}
+}
+
+func TestLoadOverwrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overwrite.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	if err := db.Put("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A key of the database, and a key repeated in the input, keep the last value loaded
+	input := `{"key":"a","value":"2"}` + "\n" + `{"key":"b","value":"1"}` + "\n" + `{"key":"b","value":"2"}` + "\n"
+	if count, err := db.Load(strings.NewReader(input), FormatJSONLines); err != nil || count != 3 {
+		t.Fatalf("expected 3 pairs loaded, got %d, %v", count, err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if value, ok, _ := db.Get(key); !ok || value != "2" {
+			t.Errorf("expected %s=2, got %q, %v", key, value, ok)
+		}
+	}
+	if keys := db.Counters().Keys; keys != 2 {
+		t.Errorf("expected 2 keys, got %d", keys)
+	}
+	if violations, err := db.Check(); err != nil || len(violations) > 0 {
+		t.Errorf("expected no violations, got %v, %v", violations, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "load.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	tests := []struct {
+		format Format
+		input  string
+		loaded int
+		err    string
+	}{
+		{FormatJSONLines, "{\"key\":\"a\",\"value\":\"1\"}\n\n{\"key\":\"b\"}\n", 1, "line 3: expected an object with a key and a value"},
+		{FormatJSONLines, "not json\n", 0, "line 1"},
+		{FormatCSV, "a,1\nb,2,3\n", 1, "wrong number of fields"},
+		{FormatCSV, fmt.Sprintf("%s,1\n", strings.Repeat("k", maxKeyLength+1)), 0, "key length"},
+	}
+	for _, test := range tests {
+		count, err := db.Load(strings.NewReader(test.input), test.format)
+		if err == nil || !strings.Contains(err.Error(), test.err) || count != test.loaded {
+			t.Errorf("%s %q: expected %d pairs and an error with %q, got %d, %v", test.format, test.input, test.loaded, test.err, count, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("expected an unknown format to be refused")
+	}
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrClosed is returned by the operations of a database that was closed.
+var ErrClosed = errors.New("database closed")
+
+// ErrInvalidBlock is the cause of a BlockError for a block number that cannot exist, such as a negative one.
+var ErrInvalidBlock = errors.New("invalid block number")
+
+// ErrCorruptBlock is the cause of a BlockError for a block whose contents cannot be decoded.
+var ErrCorruptBlock = errors.New("corrupted block")
+
+// ErrUnsorted is the cause of the error BulkLoad returns for a key that does not come after the key before it.
+var ErrUnsorted = errors.New("keys are not in strictly increasing order")
+
+// BlockError reports a block of the database file that could not be read or written.
+type BlockError struct {
+	Path  string // Path of the database file.
+	Block int64  // Number of the block.
+	Err   error  // The underlying error.
+}
+
+// Error implements the error interface.
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("%s: block %d: %v", e.Path, e.Block, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
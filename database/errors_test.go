@@ -0,0 +1,56 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClosedDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "closed.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Put("key", "value"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Put, got %v", err)
+	}
+	if _, _, err := db.Get("key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Get, got %v", err)
+	}
+	if err := db.Del("key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Del, got %v", err)
+	}
+	if _, err := db.Check(); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Check, got %v", err)
+	}
+	if err := db.Close(path); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from a second Close, got %v", err)
+	}
+}
+
+func TestInvalidBlockNumber(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	bs := newBlockService(file)
+	_, err = bs.getBlockFromDiskByBlockNumber(-1)
+	if !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("expected ErrInvalidBlock, got %v", err)
+	}
+
+	// Reading past the end of the file reports the block that is missing.
+	_, err = bs.getBlockFromDiskByBlockNumber(3)
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) || blockErr.Block != 3 || blockErr.Path != file.Name() || !errors.Is(err, io.EOF) {
+		t.Errorf("expected a BlockError for block 3, got %v", err)
+	}
+}
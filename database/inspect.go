@@ -0,0 +1,226 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// File is a database file opened read-only to inspect the B-tree stored in it, such as by dbtool.
+// Unlike Open it neither creates the file nor registers it, and it reads the blocks straight from disk,
+// so it is meant for a file no database has open; the blocks of a file being written may be inconsistent.
+type File struct {
+	path string        // Path of the database file.
+	bs   *blockService // Reads the blocks of the file.
+}
+
+// Page is a block of a database file decoded as the node of the B-tree it stores.
+type Page struct {
+	Number   int64    // Position of the block in the file.
+	ID       uint64   // Block ID recorded in the block, which equals its number in a sound file.
+	Pairs    []Pair   // Key-value pairs of the node, in the order they are stored.
+	Children []uint64 // Block IDs of the children of the node; none for a leaf.
+}
+
+// Pair is a key-value pair stored in a page.
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// FileStats describes the blocks of a database file and the shape of the B-tree stored in it.
+type FileStats struct {
+	FileSize int64   // Size of the file in bytes.
+	Pages    int64   // Number of blocks in the file.
+	Nodes    int64   // Number of blocks reachable from the root.
+	Orphaned int64   // Number of blocks not reachable from the root, such as the ones splits and merges leave until Compact.
+	Keys     int64   // Number of key-value pairs stored.
+	Depth    int     // Number of levels of the B-tree; 1 if the root is a leaf, 0 for an empty file.
+	Fill     float64 // Share of the key slots of the reachable nodes in use, from 0 to 1.
+}
+
+// OpenFile opens the database file at path read-only for inspection.
+func OpenFile(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path, bs: newBlockService(f)}, nil
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.bs.file.Close()
+}
+
+// Pages returns the number of blocks in the file.
+func (f *File) Pages() (int64, error) {
+	return f.bs.pages()
+}
+
+// Page reads and decodes the block with the given number.
+func (f *File) Page(number int64) (*Page, error) {
+	pages, err := f.bs.pages()
+	if err != nil {
+		return nil, err
+	}
+	if number < 0 || number >= pages {
+		return nil, &BlockError{Path: f.path, Block: number, Err: fmt.Errorf("%w: the file has %d blocks", ErrInvalidBlock, pages)}
+	}
+	block, err := f.bs.readBlock(number)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(number, block), nil
+}
+
+// Walk calls fn for every node reachable from the root, parents before their children, with the depth of
+// the node, which is 1 for the root. It stops at the first error fn returns, and at a block that cannot be
+// decoded or is reached twice, which Check reports in more detail.
+func (f *File) Walk(fn func(page *Page, depth int) error) error {
+	visit, err := f.visitor()
+	if err != nil || visit == nil {
+		return err
+	}
+	var walk func(number int64, depth int) error
+	walk = func(number int64, depth int) error {
+		block, err := visit(number)
+		if err != nil {
+			return err
+		}
+		if err := fn(newPage(number, block), depth); err != nil {
+			return err
+		}
+		for _, child := range block.childrenBlockIds {
+			if err := walk(int64(child), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(0, 1)
+}
+
+// Keys calls fn for every key-value pair in the order of the tree, which is key order in a sound file,
+// stopping at the first error fn returns and at the blocks Walk stops at.
+func (f *File) Keys(fn func(key, value string) error) error {
+	visit, err := f.visitor()
+	if err != nil || visit == nil {
+		return err
+	}
+	var walk func(number int64) error
+	walk = func(number int64) error {
+		block, err := visit(number)
+		if err != nil {
+			return err
+		}
+		for i := 0; i <= len(block.dataSet); i++ {
+			if i < len(block.childrenBlockIds) {
+				if err := walk(int64(block.childrenBlockIds[i])); err != nil {
+					return err
+				}
+			}
+			if i < len(block.dataSet) {
+				if err := fn(block.dataSet[i].key, block.dataSet[i].value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(0)
+}
+
+// visitor returns a function reading the blocks of a walk of the tree, which refuses a block past the
+// end of the file or reached a second time, so a corrupted file cannot send the walk in circles.
+// It returns nil for an empty file.
+func (f *File) visitor() (func(number int64) (*diskBlock, error), error) {
+	pages, err := f.bs.pages()
+	if err != nil || pages == 0 {
+		return nil, err
+	}
+	seen := make(map[int64]bool)
+	return func(number int64) (*diskBlock, error) {
+		if number >= pages {
+			return nil, &BlockError{Path: f.path, Block: number, Err: fmt.Errorf("%w: the file has %d blocks", ErrInvalidBlock, pages)}
+		}
+		if seen[number] {
+			return nil, &BlockError{Path: f.path, Block: number, Err: errors.New("block reached twice")}
+		}
+		seen[number] = true
+		return f.bs.readBlock(number)
+	}, nil
+}
+
+// Stat walks the tree and reports its shape together with the blocks of the file.
+func (f *File) Stat() (FileStats, error) {
+	fi, err := f.bs.file.Stat()
+	if err != nil {
+		return FileStats{}, err
+	}
+	stats := FileStats{FileSize: fi.Size(), Pages: fi.Size() / blockSize}
+	err = f.Walk(func(page *Page, depth int) error {
+		stats.Nodes++
+		stats.Keys += int64(len(page.Pairs))
+		stats.Depth = max(stats.Depth, depth)
+		return nil
+	})
+	if err != nil {
+		return FileStats{}, err
+	}
+	stats.Orphaned = stats.Pages - stats.Nodes
+	if stats.Nodes > 0 {
+		stats.Fill = float64(stats.Keys) / float64(stats.Nodes*maxLeafSize)
+	}
+	return stats, nil
+}
+
+// Check checks the invariants of the B-tree stored in the file and returns the violations found,
+// which are none for a sound file. The error reports a file that cannot be read at all.
+func (f *File) Check() ([]Violation, error) {
+	return checkTree(f.bs)
+}
+
+// newPage returns the page of a decoded block.
+func newPage(number int64, block *diskBlock) *Page {
+	page := &Page{Number: number, ID: block.id, Children: block.childrenBlockIds}
+	for _, p := range block.dataSet {
+		page.Pairs = append(page.Pairs, Pair{Key: p.key, Value: p.value})
+	}
+	return page
+}
+
+// pages returns the number of whole blocks in the file.
+func (bs *blockService) pages() (int64, error) {
+	fi, err := bs.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size() / blockSize, nil
+}
+
+// readBlock reads the block with the given number straight from the file, bypassing the cache.
+// Unlike getBlockFromDiskByBlockNumber it checks that the sizes recorded in the block fit in it before
+// decoding it, so a corrupted block is reported as an error instead of a panic.
+func (bs *blockService) readBlock(number int64) (*diskBlock, error) {
+	buffer := make([]byte, blockSize)
+	if _, err := bs.file.ReadAt(buffer, number*blockSize); err != nil {
+		return nil, &BlockError{Path: bs.file.Name(), Block: number, Err: err}
+	}
+	leafSize := uint64FromBytes(buffer[8:])
+	childrenSize := uint64FromBytes(buffer[16:])
+	if leafSize > maxLeafSize || childrenSize > maxLeafSize+1 {
+		return nil, &BlockError{Path: bs.file.Name(), Block: number,
+			Err: fmt.Errorf("%w: %d pairs and %d children", ErrCorruptBlock, leafSize, childrenSize)}
+	}
+	for i := uint64(0); i < leafSize; i++ {
+		offset := 24 + i*pairSize
+		keyLen := uint64(uint16FromBytes(buffer[offset:]))
+		valueLen := uint64(uint16FromBytes(buffer[offset+2:]))
+		if keyLen > maxKeyLength || valueLen > maxValueLength {
+			return nil, &BlockError{Path: bs.file.Name(), Block: number,
+				Err: fmt.Errorf("%w: pair %d has a key of %d bytes and a value of %d bytes", ErrCorruptBlock, i, keyLen, valueLen)}
+		}
+	}
+	return bs.getBlockFromBuffer(buffer), nil
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inspect.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := db.Put(fmt.Sprintf("key%03d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := db.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(path); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stats, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 200 || stats.Depth != want.Depth || stats.Nodes != want.Nodes || stats.Pages != want.Pages ||
+		stats.Orphaned != stats.Pages-stats.Nodes || stats.Fill != 200/float64(stats.Nodes*maxLeafSize) {
+		t.Errorf("unexpected stats %+v of a file with the stats %+v", stats, want)
+	}
+
+	var keys []string
+	if err := f.Keys(func(key, value string) error {
+		keys = append(keys, key+"="+value)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		if key != fmt.Sprintf("key%03d=%d", i, i) {
+			t.Fatalf("expected key%03d in position %d, got %s", i, i, key)
+		}
+	}
+	if len(keys) != 200 {
+		t.Errorf("expected 200 keys, got %d", len(keys))
+	}
+
+	// The walk visits parents first, and every page holds its number
+	var walked []int
+	if err := f.Walk(func(page *Page, depth int) error {
+		if page.ID != uint64(page.Number) {
+			t.Errorf("block %d records the ID %d", page.Number, page.ID)
+		}
+		walked = append(walked, depth)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(walked)) != stats.Nodes || walked[0] != 1 || walked[1] != 2 {
+		t.Errorf("unexpected depths %v of the walk", walked)
+	}
+	root, err := f.Page(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Children) != len(root.Pairs)+1 {
+		t.Errorf("expected the root to have a child more than its %d pairs, got %d", len(root.Pairs), len(root.Children))
+	}
+	if _, err := f.Page(stats.Pages); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("expected ErrInvalidBlock for a block past the end of the file, got %v", err)
+	}
+	if violations, err := f.Check(); err != nil || int64(len(violations)) != stats.Orphaned {
+		t.Errorf("expected only the orphaned pages to be reported, got %v, %v", violations, err)
+	}
+}
+
+func TestFileCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupted.db")
+	buildTestFile(t, path, 100, maxLeafSize)
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first pair of the root records a key longer than a key can be
+	if _, err := w.WriteAt([]byte{200, 0}, 24); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Page(0); !errors.Is(err, ErrCorruptBlock) {
+		t.Errorf("expected ErrCorruptBlock for the root, got %v", err)
+	}
+	if _, err := f.Stat(); !errors.Is(err, ErrCorruptBlock) {
+		t.Errorf("expected ErrCorruptBlock from Stat, got %v", err)
+	}
+	violations, err := f.Check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(violations) == 0 || violations[0].Kind != ViolationBlock || violations[0].Block != 0 {
+		t.Errorf("expected the root to be reported, got %v", violations)
+	}
+	if _, err := OpenFile(filepath.Join(t.TempDir(), "missing.db")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing file not to be created, got %v", err)
+	}
+}
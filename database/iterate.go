@@ -0,0 +1,43 @@
+package db
+
+// ForEach calls fn for every key-value pair in key order, stopping at the first error fn returns.
+// It holds the read lock for the walk, so the pairs form a consistent view of the database.
+func (db *DB) ForEach(fn func(key, value string) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.forEach(fn)
+}
+
+// forEach walks the pairs in key order; the caller holds the lock.
+func (db *DB) forEach(fn func(key, value string) error) error {
+	if db.storage == nil {
+		return ErrClosed
+	}
+	root, ok := db.storage.root.(*DiskNode)
+	if !ok {
+		return nil
+	}
+	return root.walk(fn)
+}
+
+// walk calls fn for the pairs of the subtree rooted at the node in key order:
+// every child is visited before the key that separates it from the next child.
+func (n *DiskNode) walk(fn func(key, value string) error) error {
+	for i := 0; i <= len(n.keys); i++ {
+		if i < len(n.childrenBlockIDs) {
+			child, err := n.getChildAtIndex(i)
+			if err != nil {
+				return err
+			}
+			if err := child.walk(fn); err != nil {
+				return err
+			}
+		}
+		if i < len(n.keys) {
+			if err := fn(n.keys[i].key, n.keys[i].value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestForEachAndClear(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iterate.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+
+	// Enough keys, inserted out of order, to split the root into several levels.
+	const n = 500
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%03d", (i*7)%n)
+		if err := db.Put(key, "value-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	i := 0
+	err = db.ForEach(func(key, value string) error {
+		if want := fmt.Sprintf("key%03d", i); key != want || value != "value-"+want {
+			return fmt.Errorf("pair %d is %q=%q, expected %q", i, key, value, want)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != n {
+		t.Fatalf("expected %d pairs, got %d", n, i)
+	}
+
+	if err := db.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ForEach(func(key, value string) error { return fmt.Errorf("unexpected key %q", key) }); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := db.Stats(); err != nil || stats.Pages != 1 {
+		t.Errorf("expected a single empty page, got %+v, %v", stats, err)
+	}
+	if err := db.Put("after", "clear"); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := db.Get("after"); err != nil || !ok || value != "clear" {
+		t.Errorf("unexpected value %q, %v, %v", value, ok, err)
+	}
+}
+
+func TestApplyFunc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apply.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+
+	var events []Event
+	db.SetApplyFunc(func(event Event) { events = append(events, event) })
+	db.Put("a", "1")
+	db.Update(func(tx *Tx) error { return tx.Del("a") })
+	db.SetApplyFunc(nil)
+	db.Put("b", "2")
+
+	want := []Event{{Key: "a", Op: OpPut, Value: "1"}, {Key: "a", Op: OpDel}}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("expected %v, got %v", want, events)
+	}
+}
@@ -0,0 +1,44 @@
+package db
+
+import "time"
+
+// Names of the internal events reported to the latency function.
+const (
+	LatencyFsync             = "fsync"               // Flushing the database file to stable storage.
+	LatencyBtreeSplit        = "btree-split"         // An insertion that split B-tree nodes.
+	LatencyBtreeMerge        = "btree-merge"         // A deletion that merged a single pair of B-tree nodes.
+	LatencyBtreeMergeCascade = "btree-merge-cascade" // A deletion whose merges cascaded up more than one level of the tree.
+)
+
+// LatencyFunc receives the name and duration of an internal event, so a server can track the slow ones.
+// It is called while the database lock is held and must not call back into the database.
+type LatencyFunc func(event string, d time.Duration)
+
+// SetLatencyFunc registers the function that receives the durations of internal events; nil removes it.
+func (db *DB) SetLatencyFunc(fn LatencyFunc) {
+	if fn == nil {
+		db.latency.Store(nil)
+		return
+	}
+	db.latency.Store(&fn)
+}
+
+// reportLatency passes an event that started at start to the latency function, if one is registered.
+func (db *DB) reportLatency(event string, start time.Time) {
+	if fn := db.latency.Load(); fn != nil {
+		(*fn)(event, time.Since(start))
+	}
+}
+
+// Sync flushes the database file to stable storage and reports the time it took as a fsync event.
+func (db *DB) Sync() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.blocks == nil {
+		return nil
+	}
+	start := time.Now()
+	err := db.blocks.file.Sync()
+	db.reportLatency(LatencyFsync, start)
+	return err
+}
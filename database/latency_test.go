@@ -0,0 +1,95 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLatencyFunc(t *testing.T) {
+	path := "/tmp/latencydb"
+	os.Remove(path)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer os.Remove(path)
+	defer db.Close(path)
+
+	events := make(map[string]int)
+	db.SetLatencyFunc(func(event string, d time.Duration) {
+		events[event]++
+	})
+
+	// Filling the root leaf does not split it; the next insertion does.
+	for i := 0; i <= maxLeafSize; i++ {
+		if err := db.Put(fmt.Sprintf("key%03d", i), "value"); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	if events[LatencyBtreeSplit] != 1 {
+		t.Errorf("Expected one split event, got %d", events[LatencyBtreeSplit])
+	}
+	for i := 0; i < 5; i++ {
+		if err := db.Del(fmt.Sprintf("key%03d", i)); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+	}
+	if events[LatencyBtreeMerge] == 0 || events[LatencyBtreeMergeCascade] != 0 {
+		t.Errorf("Expected merge events of a single level, got %v", events)
+	}
+	if err := db.Sync(); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	if events[LatencyFsync] != 1 {
+		t.Errorf("Expected one fsync event, got %d", events[LatencyFsync])
+	}
+
+	db.SetLatencyFunc(nil)
+	db.Sync()
+	if events[LatencyFsync] != 1 {
+		t.Error("Expected no events once the latency function is removed")
+	}
+}
+
+func TestLatencyMergeCascade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cascade.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close(path)
+
+	// Grow the tree to three levels
+	n := 0
+	for ; ; n++ {
+		if err := db.Put(fmt.Sprintf("key%05d", n), "value"); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+		if stats, err := db.Stats(); err != nil {
+			t.Fatal(err)
+		} else if stats.Depth == 3 {
+			break
+		}
+	}
+
+	events := make(map[string]int)
+	db.SetLatencyFunc(func(event string, d time.Duration) {
+		events[event]++
+	})
+	// An internal node only underflows when two of its children merge, so the deletions that bring the
+	// tree back to fewer levels merge more than one level at once
+	for i := 0; i <= n; i++ {
+		if err := db.Del(fmt.Sprintf("key%05d", i)); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+	}
+	if events[LatencyBtreeMergeCascade] == 0 {
+		t.Errorf("Expected a cascading merge event, got %v", events)
+	}
+	if events[LatencyBtreeMerge] == 0 {
+		t.Errorf("Expected merge events of a single level too, got %v", events)
+	}
+}
@@ -0,0 +1,15 @@
+package db
+
+import "log/slog"
+
+// Option configures a database opened with Open.
+type Option func(db *DB)
+
+// WithLogger makes the database report to logger instead of slog.Default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(db *DB) {
+		if logger != nil {
+			db.logger = logger
+		}
+	}
+}
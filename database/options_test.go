@@ -0,0 +1,26 @@
+package db
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	path := filepath.Join(t.TempDir(), "nested", "logger.db")
+	db, err := Open(path, WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close(path)
+
+	for _, want := range []string{"msg=\"created database directory\"", "msg=\"opened database\" path=" + path, "msg=\"closed database\""} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in %q", want, buf.String())
+		}
+	}
+}
@@ -48,6 +48,12 @@ func (p *pairs) validate() error {
 	return nil
 }
 
+// ValidatePair returns the error Put would return for the key-value pair, or nil if Put would store it,
+// so pairs can be checked before any of them is stored.
+func ValidatePair(key string, value string) error {
+	return newPair(key, value).validate()
+}
+
 // newPair creates a new pairs instance with the given key and value.
 func newPair(key string, value string) *pairs {
 	pair := new(pairs)
@@ -0,0 +1,574 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Opcodes of an RDB file, found where a value type is expected.
+const (
+	rdbOpSlotInfo     = 244 // Sizes of a cluster slot.
+	rdbOpFunction2    = 245 // A library of functions.
+	rdbOpModuleAux    = 247 // Auxiliary data of a module.
+	rdbOpIdle         = 248 // LRU idle time of the next key.
+	rdbOpFreq         = 249 // LFU frequency of the next key.
+	rdbOpAux          = 250 // An auxiliary field, such as the Redis version.
+	rdbOpResizeDB     = 251 // Sizes of the hash tables of the database.
+	rdbOpExpireTimeMS = 252 // Expire time of the next key in milliseconds.
+	rdbOpExpireTime   = 253 // Expire time of the next key in seconds.
+	rdbOpSelectDB     = 254 // Database of the keys that follow.
+	rdbOpEOF          = 255 // End of the file, followed by the checksum.
+)
+
+// Value types of an RDB file; only strings are imported, and the others are read past.
+const (
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZSet             = 3
+	rdbTypeHash             = 4
+	rdbTypeZSet2            = 5
+	rdbTypeModule2          = 7
+	rdbTypeHashZipmap       = 9
+	rdbTypeListZiplist      = 10
+	rdbTypeSetIntset        = 11
+	rdbTypeZSetZiplist      = 12
+	rdbTypeHashZiplist      = 13
+	rdbTypeListQuicklist    = 14
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZSetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+)
+
+// Special encodings of a string, given in place of its length.
+const (
+	rdbEncodingInt8  = 0 // An 8-bit integer.
+	rdbEncodingInt16 = 1 // A 16-bit integer.
+	rdbEncodingInt32 = 2 // A 32-bit integer.
+	rdbEncodingLZF   = 3 // A string compressed with LZF.
+)
+
+// Opcodes of the fields of data serialized by a module.
+const (
+	rdbModuleOpcodeEOF    = 0
+	rdbModuleOpcodeSInt   = 1
+	rdbModuleOpcodeUInt   = 2
+	rdbModuleOpcodeFloat  = 3
+	rdbModuleOpcodeDouble = 4
+	rdbModuleOpcodeString = 5
+)
+
+const (
+	rdbMaxVersion      = 12  // Latest RDB version understood.
+	rdbChecksumVersion = 5   // First RDB version ending with a checksum.
+	rdbStreamIDSize    = 16  // Size of a raw stream ID in a pending entries list.
+	rdbStreamTimeSize  = 8   // Size of a time of a pending entry or a consumer.
+	lzfMaxRatio        = 264 // Most bytes a byte of LZF data expands to.
+)
+
+// crcTable is the table of the CRC-64 variant (Jones, reflected) of RDB checksums.
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// rdbCRC updates an RDB checksum with data.
+func rdbCRC(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = crcTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
+
+// rdbReader reads the string keys of database 0 from a Redis RDB file. Keys of other types or databases,
+// and keys already expired, are skipped; the expire time of the other keys is dropped.
+type rdbReader struct {
+	r        *bufio.Reader // The RDB file.
+	crc      uint64        // Checksum of the bytes read so far.
+	version  int           // RDB version of the file.
+	db       uint64        // Database of the keys being read.
+	expireAt int64         // Expire time of the next key in Unix milliseconds; 0 if it has none.
+	now      int64         // Time the keys expire against, in Unix milliseconds.
+	skipped  int           // Number of keys skipped.
+	done     bool          // Whether the end of the file was read.
+}
+
+// newRDBReader reads the header of an RDB file.
+func newRDBReader(r io.Reader) (*rdbReader, error) {
+	rr := &rdbReader{r: bufio.NewReader(r), now: time.Now().UnixMilli()}
+	header, err := rr.read(9)
+	if err != nil {
+		return nil, fmt.Errorf("reading the RDB header: %w", err)
+	}
+	if !bytes.HasPrefix(header, []byte("REDIS")) {
+		return nil, errors.New("not an RDB file")
+	}
+	rr.version, err = strconv.Atoi(string(header[5:]))
+	if err != nil || rr.version < 1 || rr.version > rdbMaxVersion {
+		return nil, fmt.Errorf("unsupported RDB version %q", header[5:])
+	}
+	return rr, nil
+}
+
+// Next implements PairReader.
+func (r *rdbReader) Next() (string, string, error) {
+	for !r.done {
+		t, err := r.readByte()
+		if err != nil {
+			return "", "", r.unexpected(err)
+		}
+		switch t {
+		case rdbOpEOF:
+			if err := r.checkChecksum(); err != nil {
+				return "", "", err
+			}
+			r.done = true
+		case rdbOpSelectDB:
+			r.db, err = r.readLength()
+		case rdbOpResizeDB:
+			err = r.skipLengths(2)
+		case rdbOpSlotInfo:
+			err = r.skipLengths(3)
+		case rdbOpAux:
+			err = r.skipStrings(2)
+		case rdbOpFunction2:
+			err = r.skipStrings(1)
+		case rdbOpModuleAux:
+			err = r.skipModule()
+		case rdbOpIdle:
+			err = r.skipLengths(1)
+		case rdbOpFreq:
+			_, err = r.readByte()
+		case rdbOpExpireTimeMS:
+			var b []byte
+			if b, err = r.read(8); err == nil {
+				r.expireAt = int64(binary.LittleEndian.Uint64(b))
+			}
+		case rdbOpExpireTime:
+			var b []byte
+			if b, err = r.read(4); err == nil {
+				r.expireAt = int64(binary.LittleEndian.Uint32(b)) * 1000
+			}
+		default:
+			key, value, ok, err := r.readKey(t)
+			if err != nil {
+				return "", "", r.unexpected(err)
+			}
+			if ok {
+				return key, value, nil
+			}
+			r.skipped++
+		}
+		if err != nil {
+			return "", "", r.unexpected(err)
+		}
+	}
+	return "", "", io.EOF
+}
+
+// readKey reads a key of the type and its value, reporting whether it is a string key to import.
+func (r *rdbReader) readKey(t byte) (string, string, bool, error) {
+	expireAt := r.expireAt
+	r.expireAt = 0
+	key, err := r.readString()
+	if err != nil {
+		return "", "", false, err
+	}
+	if t != rdbTypeString {
+		if err := r.skipValue(t); err != nil {
+			return "", "", false, fmt.Errorf("key %q: %w", key, err)
+		}
+		return "", "", false, nil
+	}
+	value, err := r.readString()
+	if err != nil {
+		return "", "", false, fmt.Errorf("key %q: %w", key, err)
+	}
+	expired := expireAt != 0 && expireAt <= r.now
+	return key, value, r.db == 0 && !expired, nil
+}
+
+// skipValue reads past a value of a type other than string.
+func (r *rdbReader) skipValue(t byte) error {
+	switch t {
+	case rdbTypeHashZipmap, rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeZSetZiplist, rdbTypeHashZiplist,
+		rdbTypeHashListpack, rdbTypeZSetListpack, rdbTypeSetListpack:
+		return r.skipStrings(1)
+	case rdbTypeList, rdbTypeSet, rdbTypeListQuicklist:
+		n, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		return r.skipStrings(n)
+	case rdbTypeHash:
+		n, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		return r.skipStrings(2 * n)
+	case rdbTypeZSet, rdbTypeZSet2:
+		n, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		for ; n > 0; n-- {
+			if err := r.skipStrings(1); err != nil {
+				return err
+			}
+			if err := r.skipScore(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	case rdbTypeListQuicklist2:
+		n, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		for ; n > 0; n-- {
+			if err := r.skipLengths(1); err != nil {
+				return err
+			}
+			if err := r.skipStrings(1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return r.skipStream(t)
+	case rdbTypeModule2:
+		return r.skipModule()
+	}
+	return fmt.Errorf("unsupported RDB value type %d", t)
+}
+
+// skipScore reads past the score of a sorted set member: a string of up to 255 bytes in the first
+// version of the type, where 253 to 255 stand for NaN and infinities, and a binary double in the second.
+func (r *rdbReader) skipScore(t byte) error {
+	if t == rdbTypeZSet2 {
+		_, err := r.read(8)
+		return err
+	}
+	n, err := r.readByte()
+	if err != nil || n >= 253 {
+		return err
+	}
+	_, err = r.read(int(n))
+	return err
+}
+
+// skipStream reads past a stream: its listpacks, metadata and consumer groups.
+func (r *rdbReader) skipStream(t byte) error {
+	n, err := r.readLength()
+	if err != nil {
+		return err
+	}
+	if err := r.skipStrings(2 * n); err != nil {
+		return err
+	}
+	// Number of entries and last ID, then first ID, max deleted ID and entries added
+	lengths := uint64(3)
+	if t >= rdbTypeStreamListpacks2 {
+		lengths += 5
+	}
+	if err := r.skipLengths(lengths); err != nil {
+		return err
+	}
+	groups, err := r.readLength()
+	if err != nil {
+		return err
+	}
+	for ; groups > 0; groups-- {
+		if err := r.skipStrings(1); err != nil {
+			return err
+		}
+		// Last delivered ID, then entries read
+		lengths := uint64(2)
+		if t >= rdbTypeStreamListpacks2 {
+			lengths++
+		}
+		if err := r.skipLengths(lengths); err != nil {
+			return err
+		}
+		pending, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		for ; pending > 0; pending-- {
+			if _, err := r.read(rdbStreamIDSize + rdbStreamTimeSize); err != nil {
+				return err
+			}
+			if err := r.skipLengths(1); err != nil {
+				return err
+			}
+		}
+		consumers, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		for ; consumers > 0; consumers-- {
+			if err := r.skipStrings(1); err != nil {
+				return err
+			}
+			times := rdbStreamTimeSize
+			if t >= rdbTypeStreamListpacks3 {
+				times *= 2
+			}
+			if _, err := r.read(times); err != nil {
+				return err
+			}
+			pending, err := r.readLength()
+			if err != nil {
+				return err
+			}
+			if _, err := r.readLarge(pending * rdbStreamIDSize); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipModule reads past data serialized by a module: its ID, then typed fields up to an end marker.
+func (r *rdbReader) skipModule() error {
+	if err := r.skipLengths(1); err != nil {
+		return err
+	}
+	for {
+		op, err := r.readLength()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case rdbModuleOpcodeEOF:
+			return nil
+		case rdbModuleOpcodeSInt, rdbModuleOpcodeUInt:
+			err = r.skipLengths(1)
+		case rdbModuleOpcodeFloat:
+			_, err = r.read(4)
+		case rdbModuleOpcodeDouble:
+			_, err = r.read(8)
+		case rdbModuleOpcodeString:
+			err = r.skipStrings(1)
+		default:
+			return fmt.Errorf("unknown module opcode %d", op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkChecksum reads the checksum after the end of the file and compares it with the bytes read,
+// unless the file predates checksums or was written with them disabled, as a zero checksum.
+func (r *rdbReader) checkChecksum() error {
+	if r.version < rdbChecksumVersion {
+		return nil
+	}
+	crc := r.crc
+	b, err := r.read(8)
+	if err != nil {
+		return r.unexpected(err)
+	}
+	if expected := binary.LittleEndian.Uint64(b); expected != 0 && expected != crc {
+		return fmt.Errorf("RDB checksum mismatch: expected %016x, computed %016x", expected, crc)
+	}
+	return nil
+}
+
+// readLength reads a length, which must not be a special string encoding.
+func (r *rdbReader) readLength() (uint64, error) {
+	n, encoded, err := r.readEncodedLength()
+	if err == nil && encoded {
+		err = errors.New("unexpected string encoding")
+	}
+	return n, err
+}
+
+// readEncodedLength reads a length, or the type of a special string encoding.
+func (r *rdbReader) readEncodedLength() (uint64, bool, error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := r.readByte()
+		return uint64(b&0x3f)<<8 | uint64(next), false, err
+	case 2:
+		switch b {
+		case 0x80:
+			buf, err := r.read(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := r.read(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, fmt.Errorf("unknown length encoding %#x", b)
+	}
+	return uint64(b & 0x3f), true, nil
+}
+
+// readString reads a string, which may be stored as an integer or compressed with LZF.
+func (r *rdbReader) readString() (string, error) {
+	n, encoded, err := r.readEncodedLength()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		b, err := r.readLarge(n)
+		return string(b), err
+	}
+	switch n {
+	case rdbEncodingInt8:
+		b, err := r.readByte()
+		return strconv.Itoa(int(int8(b))), err
+	case rdbEncodingInt16:
+		b, err := r.read(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b)))), nil
+	case rdbEncodingInt32:
+		b, err := r.read(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b)))), nil
+	case rdbEncodingLZF:
+		compressed, err := r.readLength()
+		if err != nil {
+			return "", err
+		}
+		length, err := r.readLength()
+		if err != nil {
+			return "", err
+		}
+		b, err := r.readLarge(compressed)
+		if err != nil {
+			return "", err
+		}
+		b, err = lzfDecompress(b, length)
+		return string(b), err
+	}
+	return "", fmt.Errorf("unknown string encoding %d", n)
+}
+
+// skipStrings reads past n strings.
+func (r *rdbReader) skipStrings(n uint64) error {
+	for ; n > 0; n-- {
+		if _, err := r.readString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipLengths reads past n lengths.
+func (r *rdbReader) skipLengths(n uint64) error {
+	for ; n > 0; n-- {
+		if _, err := r.readLength(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readByte reads a byte, adding it to the checksum.
+func (r *rdbReader) readByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.crc = rdbCRC(r.crc, []byte{b})
+	}
+	return b, err
+}
+
+// read reads n bytes, adding them to the checksum.
+func (r *rdbReader) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	r.crc = rdbCRC(r.crc, b)
+	return b, nil
+}
+
+// readLarge reads n bytes, growing the buffer as they come rather than trusting n up front.
+func (r *rdbReader) readLarge(n uint64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r.r, int64(min(n, 1<<62))); err != nil {
+		return nil, err
+	}
+	r.crc = rdbCRC(r.crc, buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// unexpected reports the end of the input before the end of the file as a truncated file.
+func (r *rdbReader) unexpected(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.New("truncated RDB file")
+	}
+	return err
+}
+
+// lzfDecompress expands LZF data to its length. Each control byte is either a run of up to 32 literal
+// bytes, or a back reference copying 3 or more bytes from up to 8 KiB back in the output.
+func lzfDecompress(in []byte, length uint64) ([]byte, error) {
+	if length > uint64(len(in))*lzfMaxRatio {
+		return nil, errors.New("invalid LZF length")
+	}
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, errors.New("truncated LZF literal")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errors.New("truncated LZF reference")
+			}
+			n += int(in[i])
+			i++
+		}
+		n += 2
+		if i >= len(in) {
+			return nil, errors.New("truncated LZF reference")
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errors.New("invalid LZF reference")
+		}
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if uint64(len(out)) != length {
+		return nil, fmt.Errorf("LZF data expands to %d bytes instead of %d", len(out), length)
+	}
+	return out, nil
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rdbFile builds an RDB file of version 11 from the encoded body, ending it with the checksum.
+func rdbFile(body ...[]byte) []byte {
+	data := append([]byte("REDIS0011"), bytes.Join(body, nil)...)
+	data = append(data, rdbOpEOF)
+	return binary.LittleEndian.AppendUint64(data, rdbCRC(0, data))
+}
+
+// rdbString encodes a string shorter than 64 bytes.
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+// rdbExpire encodes an expire time in milliseconds for the next key.
+func rdbExpire(t time.Time) []byte {
+	return binary.LittleEndian.AppendUint64([]byte{rdbOpExpireTimeMS}, uint64(t.UnixMilli()))
+}
+
+// join concatenates encoded parts.
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestRDBChecksum(t *testing.T) {
+	if crc := rdbCRC(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("unexpected checksum %016x", crc)
+	}
+}
+
+func TestRDBReader(t *testing.T) {
+	file := rdbFile(
+		join([]byte{rdbOpAux}, rdbString("redis-ver"), rdbString("7.2.4")),
+		[]byte{rdbOpSelectDB, 0, rdbOpResizeDB, 8, 2},
+		join([]byte{rdbTypeString}, rdbString("plain"), rdbString("value")),
+		join([]byte{rdbTypeString}, rdbString("int8"), []byte{0xc0, 0xfb}),
+		join([]byte{rdbTypeString}, rdbString("int16"), []byte{0xc1, 0xe8, 0x03}),
+		join([]byte{rdbTypeString}, rdbString("int32"), []byte{0xc2, 0xa0, 0x86, 0x01, 0x00}),
+		// "abc" as literals, then 6 bytes copied from 3 back
+		join([]byte{rdbTypeString}, rdbString("lzf"), []byte{0xc3, 6, 9, 0x02, 'a', 'b', 'c', 0x80, 0x02}),
+		join(rdbExpire(time.Now().Add(-time.Hour)), []byte{rdbTypeString}, rdbString("expired"), rdbString("x")),
+		join(rdbExpire(time.Now().Add(time.Hour)), []byte{rdbTypeString}, rdbString("ttl"), rdbString("y")),
+		join([]byte{rdbOpFreq, 3, rdbTypeList}, rdbString("list"), []byte{2}, rdbString("a"), rdbString("b")),
+		join([]byte{rdbTypeZSet}, rdbString("zset"), []byte{1}, rdbString("m"), []byte{3}, []byte("1.5")),
+		join([]byte{rdbTypeZSet2}, rdbString("zset2"), []byte{1}, rdbString("m"), make([]byte, 8)),
+		join([]byte{rdbTypeHashListpack}, rdbString("hash"), rdbString("listpack")),
+		join([]byte{rdbTypeListQuicklist2}, rdbString("quicklist"), []byte{1, 2}, rdbString("listpack")),
+		join([]byte{rdbTypeModule2}, rdbString("module"), []byte{5, rdbModuleOpcodeUInt, 7, rdbModuleOpcodeString}, rdbString("s"), []byte{rdbModuleOpcodeEOF}),
+		[]byte{rdbOpSelectDB, 1},
+		join([]byte{rdbTypeString}, rdbString("other"), rdbString("z")),
+	)
+
+	r, err := newRDBReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		key, value, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, key+"="+value)
+	}
+	want := "plain=value int8=-5 int16=1000 int32=100000 lzf=abcabcabc ttl=y"
+	if strings.Join(got, " ") != want {
+		t.Errorf("expected %s, got %s", want, strings.Join(got, " "))
+	}
+	if r.skipped != 8 {
+		t.Errorf("expected 8 keys skipped, got %d", r.skipped)
+	}
+}
+
+func TestRDBReaderErrors(t *testing.T) {
+	valid := rdbFile(join([]byte{rdbTypeString}, rdbString("k"), rdbString("v")))
+	corrupted := bytes.Clone(valid)
+	corrupted[len(corrupted)-1] ^= 0xff
+	tests := []struct {
+		name string
+		file []byte
+		err  string
+	}{
+		{"not RDB", []byte("SQLite format 3"), "not an RDB file"},
+		{"version", []byte("REDIS0099"), "unsupported RDB version"},
+		{"checksum", corrupted, "checksum mismatch"},
+		{"truncated", valid[:len(valid)-12], "truncated RDB file"},
+		{"type", rdbFile(join([]byte{6}, rdbString("k"))), "unsupported RDB value type 6"},
+	}
+	for _, test := range tests {
+		r, err := newRDBReader(bytes.NewReader(test.file))
+		for err == nil {
+			_, _, err = r.Next()
+		}
+		if err == io.EOF || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestLoadRDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rdb.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	file := rdbFile(
+		join([]byte{rdbTypeString}, rdbString("a"), rdbString("1")),
+		join([]byte{rdbTypeSet}, rdbString("set"), []byte{1}, rdbString("m")),
+		join([]byte{rdbTypeString}, rdbString("b"), rdbString("2")),
+	)
+	count, err := db.Load(bytes.NewReader(file), FormatRDB)
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 keys loaded, got %d, %v", count, err)
+	}
+	if value, ok, _ := db.Get("b"); !ok || value != "2" {
+		t.Errorf("expected b=2, got %q, %v", value, ok)
+	}
+}
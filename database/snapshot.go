@@ -0,0 +1,69 @@
+package db
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteSnapshot writes a copy of the database file to w. The copy is a valid database file
+// holding every key-value pair, since Update keeps other writers out while it is taken.
+func (tx *Tx) WriteSnapshot(w io.Writer) error {
+	return tx.db.writeSnapshot(w)
+}
+
+// LoadSnapshot replaces the database file with a snapshot written by WriteSnapshot and reloads the B-tree.
+// Watchers are not notified of the keys that change.
+func (tx *Tx) LoadSnapshot(r io.Reader) error {
+	return tx.db.loadSnapshot(r)
+}
+
+// writeSnapshot copies the database file; the caller holds the lock.
+func (db *DB) writeSnapshot(w io.Writer) error {
+	if db.storage == nil {
+		return ErrClosed
+	}
+	bs := db.blocks
+	if bs == nil {
+		return fmt.Errorf("unexpected root node type")
+	}
+	fi, err := bs.file.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, io.NewSectionReader(bs.file, 0, fi.Size()))
+	return err
+}
+
+// loadSnapshot overwrites the database file with a snapshot; the caller holds the write lock.
+// A snapshot that is not made of whole blocks is rejected with ErrInvalidBlock before anything is overwritten.
+func (db *DB) loadSnapshot(r io.Reader) error {
+	if db.storage == nil {
+		return ErrClosed
+	}
+	bs := db.blocks
+	if bs == nil {
+		return fmt.Errorf("unexpected root node type")
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data)%blockSize != 0 {
+		return &BlockError{Path: bs.file.Name(), Block: int64(len(data) / blockSize), Err: ErrInvalidBlock}
+	}
+	if err := bs.preserveFile(); err != nil {
+		return err
+	}
+	if err := bs.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := bs.file.WriteAt(data, 0); err != nil {
+		return err
+	}
+	rootBlock, err := bs.getRootBlock()
+	if err != nil {
+		return err
+	}
+	db.storage.root = bs.convertBlockToDiskNode(rootBlock)
+	return db.countKeys()
+}
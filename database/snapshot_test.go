@@ -0,0 +1,62 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	srcPath := filepath.Join(t.TempDir(), "source.db")
+	src, err := Open(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close(srcPath)
+	for i := 0; i < 100; i++ {
+		if err := src.Put(fmt.Sprintf("key%03d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var snapshot bytes.Buffer
+	if err := src.Update(func(tx *Tx) error { return tx.WriteSnapshot(&snapshot) }); err != nil {
+		t.Fatal(err)
+	}
+
+	dstPath := filepath.Join(t.TempDir(), "destination.db")
+	dst, err := Open(dstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close(dstPath)
+	if err := dst.Put("stale", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Update(func(tx *Tx) error { return tx.LoadSnapshot(bytes.NewReader(snapshot.Bytes())) }); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := dst.Get("stale"); ok {
+		t.Error("expected the snapshot to replace the previous keys")
+	}
+	n := 0
+	dst.ForEach(func(key, value string) error {
+		n++
+		return nil
+	})
+	if n != 100 {
+		t.Errorf("expected 100 keys after loading the snapshot, got %d", n)
+	}
+	if err := dst.Put("new", "key"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = dst.Update(func(tx *Tx) error { return tx.LoadSnapshot(bytes.NewReader([]byte("short"))) })
+	if !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("expected a partial block to be rejected, got %v", err)
+	}
+	if value, ok, _ := dst.Get("new"); !ok || value != "key" {
+		t.Error("expected a rejected snapshot to leave the database alone")
+	}
+}
@@ -0,0 +1,101 @@
+package db
+
+import "errors"
+
+// Counters counts the operations performed on the database and its block storage since it was opened,
+// and the key-value pairs stored, which is kept up to date by every change instead of walking the tree.
+type Counters struct {
+	Keys        int64  // Number of key-value pairs stored.
+	Gets        uint64 // Number of lookups.
+	Puts        uint64 // Number of insertions.
+	Dels        uint64 // Number of deletions.
+	Splits      uint64 // Number of B-tree nodes split because they overflowed.
+	Merges      uint64 // Number of B-tree nodes merged with a sibling because they underflowed.
+	BlockReads  uint64 // Number of blocks read from disk.
+	BlockWrites uint64 // Number of blocks written to disk.
+}
+
+// Stats describes the shape of the B-tree together with the operation counters.
+type Stats struct {
+	Counters
+	Depth    int   // Number of levels of the B-tree; 1 if the root is a leaf.
+	Nodes    int64 // Number of nodes reachable from the root.
+	Pages    int64 // Number of blocks in the file, including blocks no longer in use.
+	FileSize int64 // Size of the database file in bytes.
+}
+
+// Counters returns the operation counters and the number of pairs stored. Unlike Stats it does not walk
+// the tree or take the lock, so it is cheap enough to be scraped often.
+func (db *DB) Counters() Counters {
+	c := Counters{Keys: db.keys.Load(), Gets: db.gets.Load(), Puts: db.puts.Load(), Dels: db.dels.Load()}
+	if bs := db.blocks; bs != nil {
+		c.Splits = bs.splits.Load()
+		c.Merges = bs.merges.Load()
+		c.BlockReads = bs.blockReads.Load()
+		c.BlockWrites = bs.blockWrites.Load()
+	}
+	return c
+}
+
+// Stats walks the B-tree and reports its shape together with the operation counters.
+// It holds the read lock for the walk, which visits every node, so it is meant for occasional monitoring.
+func (db *DB) Stats() (Stats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.stats()
+}
+
+// stats collects the statistics; the caller holds the lock.
+func (db *DB) stats() (Stats, error) {
+	if db.storage == nil {
+		return Stats{}, ErrClosed
+	}
+	root, ok := db.storage.root.(*DiskNode)
+	if !ok {
+		return Stats{}, errors.New("unexpected root node type")
+	}
+
+	stats := Stats{Counters: db.Counters()}
+	if err := root.collectStats(1, &stats); err != nil {
+		return Stats{}, err
+	}
+	fi, err := root.blockService.file.Stat()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.FileSize = fi.Size()
+	stats.Pages = fi.Size() / blockSize
+	return stats, nil
+}
+
+// collectStats adds the nodes of the subtree rooted at the node, found at the given level, to stats.
+func (n *DiskNode) collectStats(level int, stats *Stats) error {
+	stats.Nodes++
+	if level > stats.Depth {
+		stats.Depth = level
+	}
+	for i := range n.childrenBlockIDs {
+		child, err := n.getChildAtIndex(i)
+		if err != nil {
+			return err
+		}
+		if err := child.collectStats(level+1, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countKeys sets the number of pairs stored by walking the tree, when the contents of the database file
+// were replaced as a whole; the caller holds the write lock, or is opening the database.
+func (db *DB) countKeys() error {
+	var keys int64
+	if err := db.forEach(func(key, value string) error {
+		keys++
+		return nil
+	}); err != nil {
+		return err
+	}
+	db.keys.Store(keys)
+	return nil
+}
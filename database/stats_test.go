@@ -0,0 +1,140 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	path := "/tmp/statsdb"
+	os.Remove(path)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer os.Remove(path)
+	defer db.Close(path)
+
+	for i := 0; i < 200; i++ {
+		if err := db.Put(fmt.Sprintf("key%03d", i), "value"); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if _, found, err := db.Get(fmt.Sprintf("key%03d", i)); err != nil || !found {
+			t.Fatalf("Failed to read key%03d: %v", i, err)
+		}
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("Failed to collect stats: %v", err)
+	}
+	if stats.Keys != 200 {
+		t.Errorf("Expected 200 keys, got %d", stats.Keys)
+	}
+	if stats.Depth < 2 {
+		t.Errorf("Expected 200 keys to need more than one level, got depth %d", stats.Depth)
+	}
+	if stats.Pages == 0 || stats.FileSize != stats.Pages*blockSize {
+		t.Errorf("Unexpected pages %d for file size %d", stats.Pages, stats.FileSize)
+	}
+	if stats.BlockWrites == 0 || stats.BlockReads == 0 {
+		t.Errorf("Expected block writes and reads, got %+v", stats)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	path := "/tmp/countersdb"
+	os.Remove(path)
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer os.Remove(path)
+	defer db.Close(path)
+
+	// Enough keys to split the root leaf, and then enough deletions from the first leaf to merge it.
+	for i := 0; i < 100; i++ {
+		if err := db.Put(fmt.Sprintf("key%03d", i), "value"); err != nil {
+			t.Fatalf("Failed to insert: %v", err)
+		}
+	}
+	db.Get("key000")
+	for i := 0; i < 15; i++ {
+		if err := db.Del(fmt.Sprintf("key%03d", i)); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+	}
+
+	c := db.Counters()
+	if c.Puts != 100 || c.Dels != 15 || c.Gets != 1 {
+		t.Errorf("Unexpected operation counters %+v", c)
+	}
+	if c.Splits == 0 {
+		t.Error("Expected node splits to be counted")
+	}
+	if c.Merges == 0 {
+		t.Error("Expected node merges to be counted")
+	}
+}
+
+func TestKeysCounter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(what string, want int64) {
+		t.Helper()
+		if got := db.Counters().Keys; got != want {
+			t.Errorf("%s: expected %d keys, got %d", what, want, got)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Put(fmt.Sprintf("key%03d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 40; i++ {
+		if err := db.Del(fmt.Sprintf("key%03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Del("missing")
+	expect("after puts and deletions", 60)
+
+	// A snapshot taken now is loaded back after the contents change
+	var snapshot bytes.Buffer
+	if err := db.Update(func(tx *Tx) error { return tx.WriteSnapshot(&snapshot) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	expect("after Clear", 0)
+	if _, err := db.BulkLoad(func(yield func(string, string) bool) {
+		for i := 0; i < 25 && yield(fmt.Sprintf("key%03d", i), "value"); i++ {
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expect("after BulkLoad", 25)
+	if err := db.Update(func(tx *Tx) error { return tx.LoadSnapshot(&snapshot) }); err != nil {
+		t.Fatal(err)
+	}
+	expect("after LoadSnapshot", 60)
+
+	// The count of a database opened again comes from its file
+	if err := db.Close(path); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close(path)
+	expect("after opening the file again", 60)
+}
@@ -0,0 +1,56 @@
+package db
+
+// Tx gives access to the database while its write lock is held by Update.
+// Every operation is applied to the B-tree immediately; there is no rollback,
+// but no other reader or writer can observe the database until Update returns.
+type Tx struct {
+	db *DB // The database the transaction operates on.
+}
+
+// Update runs fn while holding the database's exclusive write lock,
+// so the operations performed through tx are atomic with respect to other callers.
+// Parameters:
+// - fn: The function to run; its error is returned by Update.
+// Returns: The error returned by fn.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return fn(&Tx{db: db})
+}
+
+// Put inserts a key-value pair, like DB.Put, without taking the lock again.
+func (tx *Tx) Put(key string, value string) error {
+	return tx.db.put(key, value)
+}
+
+// Get retrieves the value associated with a key, like DB.Get, without taking the lock again.
+func (tx *Tx) Get(key string) (string, bool, error) {
+	return tx.db.get(key)
+}
+
+// Del deletes the key-value pair associated with a key, like DB.Del, without taking the lock again.
+func (tx *Tx) Del(key string) error {
+	return tx.db.del(key)
+}
+
+// ForEach calls fn for every key-value pair in key order, like DB.ForEach, without taking the lock again.
+func (tx *Tx) ForEach(fn func(key, value string) error) error {
+	return tx.db.forEach(fn)
+}
+
+// Clear removes every key-value pair, like DB.Clear, without taking the lock again.
+func (tx *Tx) Clear() error {
+	return tx.db.clear()
+}
+
+// Stats reports the shape of the B-tree and the block storage counters, like DB.Stats, without taking the lock again.
+func (tx *Tx) Stats() (Stats, error) {
+	return tx.db.stats()
+}
+
+// Counters returns the operation counters and the number of pairs stored, like DB.Counters.
+func (tx *Tx) Counters() Counters {
+	return tx.db.Counters()
+}
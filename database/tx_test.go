@@ -0,0 +1,51 @@
+package db
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	path := "/tmp/updatedb"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer os.Remove(path)
+	defer db.Close(path)
+
+	events := db.Watch("")
+	defer db.Unwatch(events)
+
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.Put("a", "1"); err != nil {
+			return err
+		}
+		if err := tx.Put("b", "2"); err != nil {
+			return err
+		}
+		value, found, err := tx.Get("a")
+		if err != nil || !found || value != "1" {
+			t.Errorf("Expected to read back a=1 inside the transaction, got %q %v %v", value, found, err)
+		}
+		return tx.Del("b")
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	if _, found, _ := db.Get("b"); found {
+		t.Error("Key b should have been deleted")
+	}
+	if value, _, _ := db.Get("a"); value != "1" {
+		t.Errorf("Expected a=1, got %q", value)
+	}
+	if len(events) != 3 {
+		t.Errorf("Expected 3 change events, got %d", len(events))
+	}
+
+	// Errors from the function are returned as is.
+	if err := db.Update(func(tx *Tx) error { return tx.Put("", "x") }); err == nil {
+		t.Error("Update should return the error of the function")
+	}
+}
@@ -0,0 +1,103 @@
+package db
+
+import "strings"
+
+const watchBufferSize = 256 // Number of events buffered per watcher before further events are dropped.
+
+// Op identifies the kind of change described by an Event.
+type Op int
+
+const (
+	OpPut Op = iota // A key-value pair was inserted.
+	OpDel           // A key-value pair was deleted.
+)
+
+// String returns the lower-case name of the operation.
+func (op Op) String() string {
+	switch op {
+	case OpPut:
+		return "put"
+	case OpDel:
+		return "del"
+	}
+	return "unknown"
+}
+
+// Event describes a single change applied to the database.
+type Event struct {
+	Key   string // The key that changed.
+	Op    Op     // The kind of change.
+	Value string // The new value for OpPut, empty for OpDel.
+}
+
+// watcher is a registered change listener interested in keys starting with prefix.
+type watcher struct {
+	prefix string
+	ch     chan Event
+}
+
+// Watch returns a channel that receives an Event for every change to a key starting with prefix.
+// An empty prefix watches every key. Events are delivered without blocking writers:
+// if the watcher falls more than watchBufferSize events behind, further events are dropped.
+// The channel is closed by Unwatch or when the database is closed.
+func (db *DB) Watch(prefix string) <-chan Event {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	w := &watcher{prefix: prefix, ch: make(chan Event, watchBufferSize)}
+	db.watchers[w.ch] = w
+	return w.ch
+}
+
+// Unwatch stops the delivery of events to a channel returned by Watch and closes it.
+func (db *DB) Unwatch(ch <-chan Event) {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	if w, ok := db.watchers[ch]; ok {
+		delete(db.watchers, ch)
+		close(w.ch)
+	}
+}
+
+// ApplyFunc receives every change applied to the database, in the order the changes are applied.
+// Unlike a watcher it never misses a change: it is called while the write lock is held,
+// so it must be quick and must not call back into the database.
+type ApplyFunc func(event Event)
+
+// SetApplyFunc registers the function that receives every applied change; nil removes it.
+func (db *DB) SetApplyFunc(fn ApplyFunc) {
+	if fn == nil {
+		db.apply.Store(nil)
+		return
+	}
+	db.apply.Store(&fn)
+}
+
+// notify passes an event to the apply function and delivers it to every watcher whose prefix matches the key.
+func (db *DB) notify(event Event) {
+	if fn := db.apply.Load(); fn != nil {
+		(*fn)(event)
+	}
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for _, w := range db.watchers {
+		if !strings.HasPrefix(event.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- event:
+		default:
+			// The watcher is not keeping up; drop the event rather than stall the writer.
+			db.logger.Debug("dropped event for slow watcher", "key", event.Key, "prefix", w.prefix)
+		}
+	}
+}
+
+// closeWatchers closes every watcher channel; it is called when the database is closed.
+func (db *DB) closeWatchers() {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for ch, w := range db.watchers {
+		delete(db.watchers, ch)
+		close(w.ch)
+	}
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"os"
+	"testing"
+)
+
+func TestWatchReceivesPutAndDel(t *testing.T) {
+	path := "/tmp/watchdb"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer os.Remove(path)
+	defer db.Close(path)
+
+	all := db.Watch("")
+	users := db.Watch("user:")
+	defer db.Unwatch(all)
+	defer db.Unwatch(users)
+
+	if err := db.Put("user:1", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("order:1", "book"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Del("user:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Event{
+		{Key: "user:1", Op: OpPut, Value: "alice"},
+		{Key: "order:1", Op: OpPut, Value: "book"},
+		{Key: "user:1", Op: OpDel},
+	}
+	for _, want := range expected {
+		if got := <-all; got != want {
+			t.Errorf("Expected event %+v, got %+v", want, got)
+		}
+	}
+
+	for _, want := range []Event{expected[0], expected[2]} {
+		if got := <-users; got != want {
+			t.Errorf("Expected prefixed event %+v, got %+v", want, got)
+		}
+	}
+	select {
+	case event := <-users:
+		t.Errorf("Unexpected event for prefix watcher: %+v", event)
+	default:
+	}
+}
+
+func TestUnwatchClosesChannel(t *testing.T) {
+	path := "/tmp/unwatchdb"
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer os.Remove(path)
+	defer db.Close(path)
+
+	events := db.Watch("")
+	db.Unwatch(events)
+	if _, ok := <-events; ok {
+		t.Error("Channel should be closed after Unwatch")
+	}
+	// A failed delete must not produce an event for other watchers either.
+	other := db.Watch("")
+	defer db.Unwatch(other)
+	if err := db.Del("missing"); err == nil {
+		t.Error("Deleting a missing key should fail")
+	}
+	select {
+	case event := <-other:
+		t.Errorf("Unexpected event for failed delete: %+v", event)
+	default:
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	db "database/database"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// dataPath is the database file the dump and load subcommands use by default, the one the server serves.
+const dataPath = "../data/db"
+
+// dumpCommand runs the dump subcommand, which writes every pair of a database in key order.
+// The server must not be running on the database file.
+func dumpCommand(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: dump [flags]\nWrites every pair of the database in key order.")
+		fs.PrintDefaults()
+	}
+	path := fs.String("db-path", dataPath, "path of the database file")
+	format := fs.String("format", "jsonl", "format of the output: jsonl or csv")
+	output := fs.String("o", "", "file to write to instead of the standard output")
+	fs.Parse(args)
+	f, err := db.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	database, err := db.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer database.Close(*path)
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		out, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+	}
+	count, err := database.Dump(w, f)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "dumped %d pairs\n", count)
+	return nil
+}
+
+// loadCommand runs the load subcommand, which inserts the pairs of a file into a database, or replaces
+// its contents with them. The server must not be running on the database file, and the database must not
+// have an append-only file.
+func loadCommand(args []string) error {
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: load [flags] [file]\nInserts the pairs of the file, or of the standard input, into the database.")
+		fs.PrintDefaults()
+	}
+	path := fs.String("db-path", dataPath, "path of the database file")
+	format := fs.String("format", "jsonl", "format of the input: jsonl, csv or rdb")
+	replace := fs.Bool("replace", false, "replace the contents of the database with the input, which must be sorted by key, building the tree bottom-up")
+	fs.Parse(args)
+	f, err := db.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() > 0 {
+		in, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		r = in
+	}
+	r = bufio.NewReader(r)
+	// The server replays the append-only file over the contents of the database on startup, which would
+	// discard the loaded pairs
+	if _, err := os.Stat(*path + ".aof"); err == nil {
+		return fmt.Errorf("%s.aof exists and would replace the loaded pairs when the server starts; remove it first", *path)
+	}
+	database, err := db.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer database.Close(*path)
+
+	var count int
+	if *replace {
+		if count, err = database.BulkLoadFrom(r, f); err != nil {
+			return err
+		}
+	} else if count, err = database.Load(r, f); err != nil {
+		return fmt.Errorf("after %d pairs: %w", count, err)
+	}
+	fmt.Fprintf(os.Stderr, "loaded %d pairs\n", count)
+	return nil
+}
@@ -5,6 +5,7 @@ go 1.23.3
 require (
 	github.com/stretchr/testify v1.9.0
 	github.com/tidwall/resp v0.1.1
+	github.com/yuin/gopher-lua v1.1.1
 )
 
 require (
@@ -2,14 +2,69 @@ package main
 
 import (
 	"database/server"
+	"flag"
 	"fmt"
+	"os"
+	"strings"
 )
 
 func main() {
-	// Print the server port and supported commands
-	fmt.Printf("Server created and running on port: %d \n", server.Port)
-	fmt.Println("Supports SET, DEL and GET commands")
+	// The subcommands work on the database file directly, while the server is not running
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string) error{"dump": dumpCommand, "load": loadCommand}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	cfg := server.Config{}
+	flag.IntVar(&cfg.Port, "port", server.Port, "TCP port to listen on, 0 to disable")
+	flag.IntVar(&cfg.TLSPort, "tls-port", 0, "TLS port to listen on, 0 to disable")
+	flag.StringVar(&cfg.TLSCertFile, "tls-cert-file", "", "PEM file with the server certificate")
+	flag.StringVar(&cfg.TLSKeyFile, "tls-key-file", "", "PEM file with the server private key")
+	flag.StringVar(&cfg.TLSCAFile, "tls-ca-cert-file", "", "PEM file with the CA certificates for client certificates")
+	flag.BoolVar(&cfg.TLSAuthClients, "tls-auth-clients", false, "require clients to present a certificate signed by the CA")
+	flag.StringVar(&cfg.UnixSocket, "unixsocket", "", "path of a Unix domain socket to listen on")
+	flag.IntVar(&cfg.MaxClients, "maxclients", 10000, "maximum number of connected clients")
+	flag.IntVar(&cfg.Timeout, "timeout", 0, "close clients idle for this many seconds, 0 to disable")
+	flag.IntVar(&cfg.TCPKeepAlive, "tcp-keepalive", 300, "seconds between TCP keepalive probes, -1 to disable")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "address to serve Prometheus metrics on, such as :9121")
+	flag.StringVar(&cfg.DBPath, "db-path", "", "path of the database file, ../data/db by default")
+	flag.StringVar(&cfg.BackupDir, "backup-dir", "", "directory SAVE and BGSAVE write backups to, the directory of the database file by default")
+	flag.Float64Var(&cfg.CompactFillFactor, "compact-fill-factor", 0.9, "share of a full B-tree node COMPACT fills the rebuilt nodes with, from 0.5 to 1")
+	flag.BoolVar(&cfg.AppendOnly, "appendonly", false, "append every change to the append-only file next to the database file, and replay it on startup")
+	flag.StringVar(&cfg.AppendFsync, "appendfsync", "everysec", "when the append-only file is flushed to disk: always, everysec or no")
+	flag.StringVar(&cfg.ReplicaOf, "replicaof", "", "address of a leader to follow, such as 127.0.0.1:6379")
+	flag.StringVar(&cfg.RaftAddr, "raft-addr", "", "address the other Raft members reach this server at, 127.0.0.1:<port> by default")
+	raftPeers := flag.String("raft-peers", "", "comma-separated addresses of every member of the Raft group, this server included")
+	flag.BoolVar(&cfg.ClusterEnabled, "cluster-enabled", false, "partition the keys into hash slots served by the nodes of a cluster")
+	flag.StringVar(&cfg.ClusterAddr, "cluster-addr", "", "address cluster clients reach this server at, 127.0.0.1:<port> by default")
+	clusterNodes := flag.String("cluster-nodes", "", "comma-separated addresses of every node of the cluster, this server included")
+	unixSocketPerm := flag.Uint("unixsocketperm", 0, "permissions of the Unix domain socket file, such as 0700")
+	logFormat := flag.String("log-format", "text", "format of the log records: text or json")
+	logLevel := flag.String("log-level", "info", "minimum level of the log records: debug, info, warn or error")
+	flag.Parse()
+	cfg.UnixSocketPerm = uint32(*unixSocketPerm)
+	if *raftPeers != "" {
+		cfg.RaftPeers = strings.Split(*raftPeers, ",")
+	}
+	if *clusterNodes != "" {
+		cfg.ClusterNodes = strings.Split(*clusterNodes, ",")
+	}
+
+	logger, err := server.NewLogger(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	cfg.Logger = logger
 
 	// Start the client and begin handling connections
-	server.Createclient()
+	if err := server.Createclient(cfg); err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/resp"
+)
+
+const defaultUser = "default" // Name of the user that AUTH with only a password and requirepass refer to
+
+// commandCategories lists the ACL categories of every command, as used by +@category and -@category rules.
+var commandCategories = map[string][]string{
+	CommandSET:          {"write", "string"},
+	CommandGET:          {"read", "string", "fast"},
+	CommandDEL:          {"write", "keyspace"},
+	CommandPING:         {"connection", "fast"},
+	CommandSUBSCRIBE:    {"pubsub"},
+	CommandUNSUBSCRIBE:  {"pubsub"},
+	CommandPSUBSCRIBE:   {"pubsub"},
+	CommandPUNSUBSCRIBE: {"pubsub"},
+	CommandPUBLISH:      {"pubsub", "fast"},
+	CommandCONFIG:       {"admin", "dangerous"},
+	CommandMULTI:        {"transaction", "fast"},
+	CommandEXEC:         {"transaction"},
+	CommandDISCARD:      {"transaction", "fast"},
+	CommandWATCH:        {"transaction", "fast"},
+	CommandUNWATCH:      {"transaction", "fast"},
+	CommandEVAL:         {"scripting"},
+	CommandEVALSHA:      {"scripting"},
+	CommandSCRIPT:       {"scripting"},
+	CommandAUTH:         {"connection", "fast"},
+	CommandACL:          {"admin", "dangerous"},
+	CommandCLIENT:       {"admin", "connection", "dangerous"},
+	CommandINFO:         {"dangerous"},
+	CommandSLOWLOG:      {"admin", "dangerous"},
+	CommandLATENCY:      {"admin", "dangerous"},
+	CommandMONITOR:      {"admin", "dangerous"},
+	CommandREPLICAOF:    {"admin", "dangerous"},
+	CommandROLE:         {"admin", "fast", "dangerous"},
+	CommandPSYNC:        {"admin", "dangerous"},
+	CommandREPLCONF:     {"admin", "dangerous"},
+	CommandRAFT:         {"admin", "dangerous"},
+	CommandCLUSTER:      {"admin", "dangerous"},
+	CommandASKING:       {"connection", "fast"},
+	CommandMIGRATE:      {"write", "keyspace", "dangerous"},
+	CommandSAVE:         {"admin", "dangerous"},
+	CommandBGSAVE:       {"admin", "dangerous"},
+	CommandLASTSAVE:     {"admin", "fast", "dangerous"},
+	CommandCOMPACT:      {"admin", "dangerous"},
+	CommandBGREWRITEAOF: {"admin", "dangerous"},
+}
+
+// aclUser is a user with its credentials and the commands and keys it may access.
+type aclUser struct {
+	name      string              // The user name.
+	enabled   bool                // Whether the user can authenticate.
+	nopass    bool                // Whether any password is accepted.
+	passwords map[string]struct{} // SHA256 hex digests of the accepted passwords.
+	rules     []string            // Command rules such as +@all or -config, evaluated in order.
+	keys      []string            // Glob-style patterns of the keys the user may access.
+}
+
+// acl holds the users known to the server.
+var acl = struct {
+	mu    sync.RWMutex        // Mutex to protect users.
+	users map[string]*aclUser // Users by name.
+}{
+	users: map[string]*aclUser{
+		defaultUser: {
+			name:      defaultUser,
+			enabled:   true,
+			nopass:    true,
+			passwords: make(map[string]struct{}),
+			rules:     []string{"+@all"},
+			keys:      []string{"*"},
+		},
+	},
+}
+
+// hashPassword returns the SHA256 hex digest under which passwords are stored.
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// initialUser returns the user a new connection is authenticated as, which is the default user
+// unless it requires a password or is disabled; then the connection must AUTH first.
+func initialUser() string {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	if u := acl.users[defaultUser]; u != nil && u.enabled && u.nopass {
+		return defaultUser
+	}
+	return ""
+}
+
+// authenticate checks a user name and password pair.
+func authenticate(name, password string) error {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	u, ok := acl.users[name]
+	if ok && u.enabled {
+		if u.nopass {
+			return nil
+		}
+		if _, ok := u.passwords[hashPassword(password)]; ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("WRONGPASS invalid username-password pair or user is disabled.")
+}
+
+// checkPermission verifies that the user may run the named command on the keys it accesses.
+// It is called before a command is queued or dispatched, so denied commands never reach the database.
+func checkPermission(user, name string, cmd command) error {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	u, ok := acl.users[user]
+	if !ok || !u.enabled {
+		return fmt.Errorf("NOAUTH Authentication required.")
+	}
+	if !u.canRun(name) {
+		return fmt.Errorf("NOPERM this user has no permissions to run the '%s' command", strings.ToLower(name))
+	}
+	for _, key := range commandKeys(cmd) {
+		if !u.canAccess(key) {
+			return fmt.Errorf("NOPERM No permissions to access a key")
+		}
+	}
+	return nil
+}
+
+// canRun evaluates the command rules of the user in order for the named command.
+func (u *aclUser) canRun(name string) bool {
+	allowed := false
+	for _, rule := range u.rules {
+		grant := rule[0] == '+'
+		target := rule[1:]
+		switch {
+		case target == "@all":
+			allowed = grant
+		case strings.HasPrefix(target, "@"):
+			for _, category := range commandCategories[name] {
+				if category == target[1:] {
+					allowed = grant
+				}
+			}
+		case strings.EqualFold(target, name):
+			allowed = grant
+		}
+	}
+	return allowed
+}
+
+// canAccess reports whether one of the user's key patterns matches the key.
+func (u *aclUser) canAccess(key string) bool {
+	for _, pattern := range u.keys {
+		if matchPattern(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandKeys returns the keys accessed by a command, for key pattern checks.
+func commandKeys(cmd command) []string {
+	switch c := cmd.(type) {
+	case SETcommand:
+		return []string{c.key}
+	case GETcommand:
+		return []string{c.key}
+	case DELcommand:
+		return []string{c.key}
+	case WATCHcommand:
+		return c.keys
+	case EVALcommand:
+		return c.keys
+	case EVALSHAcommand:
+		return c.keys
+	case MIGRATEcommand:
+		return c.keys
+	}
+	return nil
+}
+
+// setUser creates the user if needed and applies the rules to it in order.
+// The rules are validated first, so an invalid rule leaves the user untouched.
+func setUser(name string, rules []string) error {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+
+	u := &aclUser{name: name, passwords: make(map[string]struct{})}
+	if existing, ok := acl.users[name]; ok {
+		*u = *existing
+		u.passwords = make(map[string]struct{}, len(existing.passwords))
+		for hash := range existing.passwords {
+			u.passwords[hash] = struct{}{}
+		}
+		u.rules = append([]string(nil), existing.rules...)
+		u.keys = append([]string(nil), existing.keys...)
+	}
+	for _, rule := range rules {
+		if err := u.applyRule(rule); err != nil {
+			return err
+		}
+	}
+	acl.users[name] = u
+	return nil
+}
+
+// applyRule applies a single Redis-style ACL rule to the user.
+func (u *aclUser) applyRule(rule string) error {
+	switch lower := strings.ToLower(rule); {
+	case lower == "on":
+		u.enabled = true
+	case lower == "off":
+		u.enabled = false
+	case lower == "nopass":
+		u.nopass = true
+		u.passwords = make(map[string]struct{})
+	case lower == "resetpass":
+		u.nopass = false
+		u.passwords = make(map[string]struct{})
+	case lower == "allkeys":
+		u.keys = []string{"*"}
+	case lower == "resetkeys":
+		u.keys = nil
+	case lower == "allcommands":
+		u.rules = []string{"+@all"}
+	case lower == "nocommands":
+		u.rules = []string{"-@all"}
+	case lower == "reset":
+		*u = aclUser{name: u.name, passwords: make(map[string]struct{}), rules: []string{"-@all"}}
+	case strings.HasPrefix(rule, ">"):
+		u.passwords[hashPassword(rule[1:])] = struct{}{}
+		u.nopass = false
+	case strings.HasPrefix(rule, "<"):
+		delete(u.passwords, hashPassword(rule[1:]))
+	case strings.HasPrefix(rule, "#"):
+		if len(rule) != 65 {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': The password hash must be exactly 64 characters", rule)
+		}
+		u.passwords[strings.ToLower(rule[1:])] = struct{}{}
+		u.nopass = false
+	case strings.HasPrefix(rule, "~"):
+		u.keys = append(u.keys, rule[1:])
+	case len(rule) > 1 && (rule[0] == '+' || rule[0] == '-'):
+		target := strings.ToLower(rule[1:])
+		if strings.HasPrefix(target, "@") {
+			if !knownCategory(target[1:]) {
+				return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': Unknown command or category name in ACL", rule)
+			}
+			if target == "@all" {
+				u.rules = nil // Everything before +@all or -@all is overridden.
+			}
+		} else if _, ok := commandCategories[strings.ToUpper(target)]; !ok {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': Unknown command or category name in ACL", rule)
+		}
+		u.rules = append(u.rules, string(rule[0])+target)
+	default:
+		return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': Syntax error", rule)
+	}
+	return nil
+}
+
+// knownCategory reports whether a category name is used by at least one command, or is "all".
+func knownCategory(name string) bool {
+	if name == "all" {
+		return true
+	}
+	for _, categories := range commandCategories {
+		for _, category := range categories {
+			if category == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describe returns the user the way ACL LIST shows it.
+func (u *aclUser) describe() string {
+	parts := []string{"user", u.name}
+	if u.enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	hashes := sortedNames(u.passwords)
+	for _, hash := range hashes {
+		parts = append(parts, "#"+hash)
+	}
+	for _, pattern := range u.keys {
+		parts = append(parts, "~"+pattern)
+	}
+	if len(u.rules) == 0 {
+		parts = append(parts, "-@all")
+	}
+	parts = append(parts, u.rules...)
+	return strings.Join(parts, " ")
+}
+
+// aclCommand executes an ACL SETUSER, DELUSER, LIST or WHOAMI command and returns the reply.
+func (s *session) aclCommand(c ACLcommand) resp.Value {
+	switch c.subcommand {
+	case "WHOAMI":
+		return resp.StringValue(s.user)
+	case "LIST":
+		acl.mu.RLock()
+		defer acl.mu.RUnlock()
+		names := make([]string, 0, len(acl.users))
+		for name := range acl.users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		values := make([]resp.Value, len(names))
+		for i, name := range names {
+			values[i] = resp.StringValue(acl.users[name].describe())
+		}
+		return resp.ArrayValue(values)
+	case "SETUSER":
+		if err := setUser(c.args[0], c.args[1:]); err != nil {
+			return resp.ErrorValue(err)
+		}
+		return resp.SimpleStringValue("OK")
+	}
+	// DELUSER
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	deleted := 0
+	for _, name := range c.args {
+		if name == defaultUser {
+			return resp.ErrorValue(fmt.Errorf("ERR The 'default' user cannot be removed"))
+		}
+		if _, ok := acl.users[name]; ok {
+			delete(acl.users, name)
+			deleted++
+		}
+	}
+	return resp.IntegerValue(deleted)
+}
+
+// authCommand executes an AUTH command and switches the connection to the user on success.
+func (s *session) authCommand(c AUTHcommand) resp.Value {
+	if err := authenticate(c.user, c.password); err != nil {
+		return resp.ErrorValue(err)
+	}
+	s.user = c.user
+	return resp.SimpleStringValue("OK")
+}
+
+// setRequirePass applies a new requirepass value by changing the password of the default user.
+// An empty value lets connections use the default user without a password again.
+func setRequirePass(value string) error {
+	rules := []string{"resetpass", "nopass"}
+	if value != "" {
+		rules = []string{"resetpass", ">" + value}
+	}
+	return setUser(defaultUser, rules)
+}
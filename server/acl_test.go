@@ -0,0 +1,126 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+// removeUser deletes a user created by a test.
+func removeUser(name string) {
+	acl.mu.Lock()
+	defer acl.mu.Unlock()
+	delete(acl.users, name)
+}
+
+func TestACLPermissions(t *testing.T) {
+	if err := setUser("reader", []string{"on", ">secret", "~cache:*", "+@read", "+ping"}); err != nil {
+		t.Fatal(err)
+	}
+	defer removeUser("reader")
+
+	if err := authenticate("reader", "secret"); err != nil {
+		t.Errorf("expected valid credentials, got %v", err)
+	}
+	if err := authenticate("reader", "wrong"); err == nil {
+		t.Error("expected wrong password to be rejected")
+	}
+
+	tests := []struct {
+		name    string
+		cmd     command
+		allowed bool
+	}{
+		{CommandGET, GETcommand{key: "cache:1"}, true},
+		{CommandGET, GETcommand{key: "users:1"}, false},
+		{CommandSET, SETcommand{key: "cache:1", val: "x"}, false},
+		{CommandPING, PINGcommand{}, true},
+		{CommandCONFIG, CONFIGcommand{subcommand: "GET", args: []string{"*"}}, false},
+	}
+	for _, tt := range tests {
+		err := checkPermission("reader", tt.name, tt.cmd)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s %+v: allowed=%v, got err %v", tt.name, tt.cmd, tt.allowed, err)
+		}
+	}
+
+	// Later rules override earlier ones.
+	if err := setUser("reader", []string{"-get"}); err != nil {
+		t.Fatal(err)
+	}
+	if checkPermission("reader", CommandGET, GETcommand{key: "cache:1"}) == nil {
+		t.Error("expected -get to revoke GET")
+	}
+	if err := setUser("reader", []string{"off"}); err != nil {
+		t.Fatal(err)
+	}
+	if authenticate("reader", "secret") == nil {
+		t.Error("disabled users must not authenticate")
+	}
+}
+
+func TestACLSetUserRejectsInvalidRules(t *testing.T) {
+	defer removeUser("broken")
+	if err := setUser("broken", []string{"on", "+@nosuchcategory"}); err == nil {
+		t.Error("expected unknown category to be rejected")
+	}
+	if err := setUser("broken", []string{"+nosuchcommand"}); err == nil {
+		t.Error("expected unknown command to be rejected")
+	}
+	acl.mu.RLock()
+	_, created := acl.users["broken"]
+	acl.mu.RUnlock()
+	if created {
+		t.Error("a failed ACL SETUSER must not create the user")
+	}
+}
+
+func TestACLListAndWhoami(t *testing.T) {
+	if err := setUser("writer", []string{"on", ">pw", "allkeys", "+@write"}); err != nil {
+		t.Fatal(err)
+	}
+	defer removeUser("writer")
+
+	s := newSession(nil, nil)
+	if reply := s.aclCommand(ACLcommand{subcommand: "WHOAMI"}); reply.String() != defaultUser {
+		t.Errorf("unexpected WHOAMI reply %v", reply)
+	}
+	if reply := s.authCommand(AUTHcommand{user: "writer", password: "pw"}); reply.String() != "OK" {
+		t.Fatalf("unexpected AUTH reply %v", reply)
+	}
+	if reply := s.aclCommand(ACLcommand{subcommand: "WHOAMI"}); reply.String() != "writer" {
+		t.Errorf("unexpected WHOAMI reply after AUTH %v", reply)
+	}
+
+	var found bool
+	for _, line := range s.aclCommand(ACLcommand{subcommand: "LIST"}).Array() {
+		if strings.HasPrefix(line.String(), "user writer on #"+hashPassword("pw")+" ~* +@write") {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("ACL LIST should describe the writer user")
+	}
+}
+
+func TestRequirePass(t *testing.T) {
+	if err := configSet("requirepass", "letmein"); err != nil {
+		t.Fatal(err)
+	}
+	defer configSet("requirepass", "")
+
+	s := newSession(nil, nil)
+	if err := s.authorize(CommandGET, GETcommand{key: "k"}); err == nil || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Errorf("expected NOAUTH before AUTH, got %v", err)
+	}
+	if reply := s.authCommand(AUTHcommand{user: defaultUser, password: "nope"}); reply.Type() != resp.Error {
+		t.Error("expected wrong requirepass to be rejected")
+	}
+	if reply := s.authCommand(AUTHcommand{user: defaultUser, password: "letmein"}); reply.String() != "OK" {
+		t.Fatalf("unexpected AUTH reply %v", reply)
+	}
+	if err := s.authorize(CommandGET, GETcommand{key: "k"}); err != nil {
+		t.Errorf("expected GET to be allowed after AUTH, got %v", err)
+	}
+}
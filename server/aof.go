@@ -0,0 +1,531 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	db "database/database"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	aofFsyncAlways   = "always"   // The file is flushed to disk before every write is acknowledged
+	aofFsyncEverysec = "everysec" // The file is flushed to disk once per second, losing at most a second of writes
+	aofFsyncNo       = "no"       // The file is left for the operating system to flush
+	aofReplayBatch   = 1000       // Commands replayed per write lock on startup
+	commandFLUSHALL  = "FLUSHALL" // Entry of the file for a follower replacing its contents with the leader's
+	aofSuffix        = ".aof"     // Suffix of the append-only file, next to the database file
+	aofRewriteSuffix = ".rewrite" // Suffix of the temporary file a rewrite writes next to the append-only file
+)
+
+// aof is the append-only file. Every change applied to the database is appended to it as a RESP command,
+// SET or DEL, and it is replayed into the database on startup, so the changes it records survive a crash
+// however far the B-tree file got. BGREWRITEAOF rewrites it from the contents of the database, so it does
+// not keep growing with every overwrite.
+var aof = struct {
+	mu             sync.Mutex // Mutex to protect the fields below.
+	enabled        bool       // Whether changes are appended to the file.
+	path           string     // Path of the file, next to the database file.
+	database       *db.DB     // The database the file records, rewritten from by BGREWRITEAOF.
+	file           *os.File   // The file changes are appended to; nil while disabled, or until the first rewrite writes it.
+	fsync          string     // When the file is flushed to disk: always, everysec or no.
+	dirty          bool       // Whether changes were appended since the file was last flushed.
+	size           int64      // Size of the file.
+	baseSize       int64      // Size of the file after the last rewrite or replay.
+	rewriting      bool       // Whether a rewrite is running.
+	rewriteBuf     []byte     // Changes appended since the rewrite started, added to the end of the rewritten file.
+	lastRewriteErr error      // Error of the last rewrite; nil if it succeeded.
+	lastWriteErr   error      // Error of the last append or flush; nil if it succeeded.
+}{
+	fsync: aofFsyncEverysec,
+}
+
+// startAOF sets up the append-only file of the database at path, and unless cfg leaves it disabled, replays
+// the file into the database, replacing its contents. Without a file yet, one is written from the contents.
+// The file is flushed every second until the context is canceled, if appendfsync asks for it.
+func startAOF(ctx context.Context, database *db.DB, path string, cfg Config) error {
+	aof.mu.Lock()
+	aof.path = path + aofSuffix
+	aof.database = database
+	aof.mu.Unlock()
+	if cfg.AppendFsync != "" {
+		if err := configSet("appendfsync", cfg.AppendFsync); err != nil {
+			return err
+		}
+	}
+	go syncAOFEverySecond(ctx)
+	if !cfg.AppendOnly {
+		return nil
+	}
+
+	if _, err := os.Stat(path + aofSuffix); errors.Is(err, os.ErrNotExist) {
+		// Start the file from the contents the database already has
+		if err := rewriteAOF(database, path+aofSuffix); err != nil {
+			return err
+		}
+	} else if err := replayAOF(database, path+aofSuffix); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path+aofSuffix, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.enabled = true
+	aof.file = f
+	aof.size, aof.baseSize = fi.Size(), fi.Size()
+	return nil
+}
+
+// stopAOF flushes and closes the append-only file, on shutdown.
+func stopAOF() {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.file != nil {
+		aof.file.Sync()
+		aof.file.Close()
+		aof.file = nil
+	}
+	aof.enabled = false
+	aof.database = nil
+}
+
+// replayAOF replaces the contents of the database with the commands of the append-only file. A command cut
+// short at the end of the file, by a crash in the middle of an append, is dropped and truncated away.
+// The whole file is checked before the database is cleared, so a file with a corrupted or unknown command
+// is refused and leaves the database as it was.
+func replayAOF(database *db.DB, path string) error {
+	start := time.Now()
+	commands, offset, truncated, err := scanAOF(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rd := resp.NewReader(bufio.NewReader(io.LimitReader(f, offset)))
+	for done, first := false, true; !done; first = false {
+		err := database.Update(func(tx *db.Tx) error {
+			if first {
+				if err := tx.Clear(); err != nil {
+					return err
+				}
+			}
+			for i := 0; i < aofReplayBatch; i++ {
+				v, _, err := rd.ReadValue()
+				if errors.Is(err, io.EOF) {
+					done = true
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				if err := replayCommand(tx, v); err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if truncated {
+		logger().Warn("truncating an incomplete command at the end of the append-only file", "path", path, "offset", offset)
+		if err := os.Truncate(path, offset); err != nil {
+			return err
+		}
+	}
+	logger().Info("append-only file replayed", "path", path, "commands", commands, "duration", time.Since(start))
+	return nil
+}
+
+// scanAOF reads the append-only file and checks every command in it, without applying any. It returns the
+// number of commands, the offset the last complete command ends at, and whether an incomplete command
+// follows it.
+func scanAOF(path string) (commands int, offset int64, truncated bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer f.Close()
+	rd := resp.NewReader(bufio.NewReader(f))
+	for {
+		v, n, err := rd.ReadValue()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return commands, offset, n > 0 || errors.Is(err, io.ErrUnexpectedEOF), nil
+		}
+		if err == nil {
+			_, _, err = parseAOFCommand(v)
+		}
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("%s: offset %d: %w", path, offset, err)
+		}
+		offset += int64(n)
+		commands++
+	}
+}
+
+// parseAOFCommand returns the name and arguments of a command of the append-only file, checking that it is
+// one the file records with the arguments it takes.
+func parseAOFCommand(v resp.Value) (string, []string, error) {
+	values := v.Array()
+	if len(values) == 0 {
+		return "", nil, fmt.Errorf("unexpected entry %q", v.String())
+	}
+	args := make([]string, len(values)-1)
+	for i, value := range values[1:] {
+		args[i] = value.String()
+	}
+	switch name := strings.ToUpper(values[0].String()); {
+	case name == CommandSET && len(args) == 2:
+		if err := db.ValidatePair(args[0], args[1]); err != nil {
+			return "", nil, fmt.Errorf("command %q: %w", v.String(), err)
+		}
+		return name, args, nil
+	case name == CommandDEL && len(args) >= 1, name == commandFLUSHALL && len(args) == 0:
+		return name, args, nil
+	}
+	return "", nil, fmt.Errorf("unexpected command %q", v.String())
+}
+
+// replayCommand applies a command of the append-only file.
+func replayCommand(tx *db.Tx, v resp.Value) error {
+	name, args, err := parseAOFCommand(v)
+	if err != nil {
+		return err
+	}
+	switch name {
+	case CommandSET:
+		return tx.Put(args[0], args[1])
+	case CommandDEL:
+		for _, key := range args {
+			if _, ok, err := tx.Get(key); err != nil || !ok {
+				continue
+			}
+			if err := tx.Del(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return tx.Clear()
+}
+
+// appendAOF appends a change applied to the database to the append-only file. It is called by the apply
+// function of the database, so it runs under the write lock in the order the changes are applied.
+func appendAOF(event db.Event) {
+	switch event.Op {
+	case db.OpPut:
+		writeAOF(encodeCommand(CommandSET, event.Key, event.Value))
+	case db.OpDel:
+		writeAOF(encodeCommand(CommandDEL, event.Key))
+	}
+}
+
+// flushAllAOF records in the append-only file that the contents of the database were removed, which
+// happens without a change per key when a follower loads the snapshot of its leader.
+func flushAllAOF() {
+	writeAOF(encodeCommand(commandFLUSHALL))
+}
+
+// snapshotAOF records in the append-only file that the contents of the database were replaced by a
+// snapshot loaded in tx without a change per key, as a FLUSHALL followed by a SET for every pair. The pairs
+// are only read while the file is on or being rewritten.
+func snapshotAOF(tx *db.Tx) error {
+	flushAllAOF()
+	aof.mu.Lock()
+	recording := aof.rewriting || aof.enabled && aof.file != nil
+	aof.mu.Unlock()
+	if !recording {
+		return nil
+	}
+	return tx.ForEach(func(key, value string) error {
+		writeAOF(encodeCommand(CommandSET, key, value))
+		return nil
+	})
+}
+
+// writeAOF appends a command to the append-only file, and to the changes a rewrite in progress misses.
+func writeAOF(b []byte) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.rewriting {
+		aof.rewriteBuf = append(aof.rewriteBuf, b...)
+	}
+	if !aof.enabled || aof.file == nil {
+		return
+	}
+	start := time.Now()
+	n, err := aof.file.Write(b)
+	aof.size += int64(n)
+	recordLatency("aof-write", time.Since(start))
+	if err == nil && aof.fsync == aofFsyncAlways {
+		start = time.Now()
+		err = aof.file.Sync()
+		recordLatency("aof-fsync-always", time.Since(start))
+	} else {
+		aof.dirty = true
+	}
+	if err != nil && aof.lastWriteErr == nil {
+		logger().Error("writing the append-only file failed", "path", aof.path, "err", err)
+	}
+	aof.lastWriteErr = err
+}
+
+// syncAOFEverySecond flushes the append-only file to disk every second while appendfsync is everysec,
+// until the context is canceled. The flush runs outside the mutex, so appends do not wait for it.
+func syncAOFEverySecond(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		aof.mu.Lock()
+		f := aof.file
+		sync := f != nil && aof.dirty && aof.fsync == aofFsyncEverysec
+		if sync {
+			aof.dirty = false
+		}
+		aof.mu.Unlock()
+		if !sync {
+			continue
+		}
+		start := time.Now()
+		if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			logger().Error("flushing the append-only file failed", "path", f.Name(), "err", err)
+		}
+		recordLatency("aof-fsync-everysec", time.Since(start))
+	}
+}
+
+// setAppendOnly applies a new appendonly value. Turning the file on writes it from the contents of the
+// database in the background first, like BGREWRITEAOF, and appends the changes from then on.
+func setAppendOnly(value string) error {
+	switch strings.ToLower(value) {
+	case "yes":
+		aof.mu.Lock()
+		if aof.enabled {
+			aof.mu.Unlock()
+			return nil
+		}
+		if aof.database == nil {
+			aof.mu.Unlock()
+			return fmt.Errorf("ERR The append-only file needs the server to be running")
+		}
+		aof.enabled = true
+		if !aof.rewriting {
+			// Otherwise the rewrite in progress keeps the file open for appends once it finishes
+			startRewriteLocked()
+		}
+		aof.mu.Unlock()
+		return nil
+	case "no":
+		aof.mu.Lock()
+		defer aof.mu.Unlock()
+		aof.enabled = false
+		if aof.file != nil {
+			aof.file.Sync()
+			aof.file.Close()
+			aof.file = nil
+		}
+		return nil
+	}
+	return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET 'appendonly'", value)
+}
+
+// appendOnlyValue reports whether the append-only file is on, for CONFIG GET appendonly.
+func appendOnlyValue() string {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.enabled {
+		return "yes"
+	}
+	return "no"
+}
+
+// setAppendFsync applies a new appendfsync value: always, everysec or no.
+func setAppendFsync(value string) error {
+	switch v := strings.ToLower(value); v {
+	case aofFsyncAlways, aofFsyncEverysec, aofFsyncNo:
+		aof.mu.Lock()
+		defer aof.mu.Unlock()
+		aof.fsync = v
+		return nil
+	}
+	return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET 'appendfsync'", value)
+}
+
+// appendFilenameValue reports the name of the append-only file, for CONFIG GET appendfilename.
+func appendFilenameValue() string {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.path
+}
+
+// bgrewriteaofCommand executes a BGREWRITEAOF command: the append-only file is rewritten in the background,
+// and the outcome is reported by the persistence section of INFO.
+func bgrewriteaofCommand() resp.Value {
+	if err := startAOFRewrite(); err != nil {
+		return resp.ErrorValue(err)
+	}
+	return resp.SimpleStringValue("Background append only file rewriting started")
+}
+
+// startAOFRewrite starts rewriting the append-only file in the background, unless a rewrite is running.
+func startAOFRewrite() error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.rewriting {
+		return fmt.Errorf("ERR Background append only file rewriting already in progress")
+	}
+	if aof.database == nil {
+		return fmt.Errorf("ERR The append-only file needs the server to be running")
+	}
+	startRewriteLocked()
+	return nil
+}
+
+// startRewriteLocked starts a rewrite in the background; the caller holds the mutex.
+func startRewriteLocked() {
+	aof.rewriting = true
+	aof.rewriteBuf = nil
+	database, path := aof.database, aof.path
+	go func() {
+		start := time.Now()
+		if err := rewriteAOF(database, path); err != nil {
+			logger().Warn("background append only file rewriting failed", "path", path, "err", err)
+			return
+		}
+		logger().Info("background append only file rewriting terminated with success", "path", path, "duration", time.Since(start))
+	}()
+}
+
+// rewriteAOF writes a SET command for every pair of the database to a temporary file, followed by the
+// changes applied since the rewrite started, and renames it over the append-only file, which the following
+// changes are appended to. A change made while the pairs are read may be found both among the pairs and
+// among the changes, which makes no difference once they are replayed in order.
+// Writers wait while the pairs are read, as the walk holds the read lock of the database.
+func rewriteAOF(database *db.DB, path string) error {
+	f, err := writeAOFSnapshot(database, path+aofRewriteSuffix)
+	if err == nil {
+		err = swapAOF(f, path)
+	}
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.rewriting = false
+	aof.rewriteBuf = nil
+	aof.lastRewriteErr = err
+	if err != nil && aof.file == nil {
+		// The file was being turned on; it stays off
+		aof.enabled = false
+	}
+	return err
+}
+
+// writeAOFSnapshot writes a SET command for every pair of the database to a new file and syncs it.
+func writeAOFSnapshot(database *db.DB, path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	err = database.ForEach(func(key, value string) error {
+		_, err := w.Write(encodeCommand(CommandSET, key, value))
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return f, nil
+}
+
+// swapAOF adds the changes applied since the rewrite started to the rewritten file, and renames it over
+// the append-only file. Changes are appended to it from then on, if the append-only file is on.
+func swapAOF(f *os.File, path string) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	_, err := f.Write(aof.rewriteBuf)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	var fi os.FileInfo
+	if err == nil {
+		fi, err = f.Stat()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if aof.file != nil {
+		aof.file.Close()
+	}
+	aof.file = f
+	if !aof.enabled {
+		f.Close()
+		aof.file = nil
+	}
+	aof.size, aof.baseSize = fi.Size(), fi.Size()
+	aof.dirty = false
+	return nil
+}
+
+// aofInfo reports the state of the append-only file for the persistence section of INFO.
+func aofInfo() []string {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	status := func(err error) string {
+		if err != nil {
+			return "err"
+		}
+		return "ok"
+	}
+	flag := func(b bool) int {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	info := []string{
+		fmt.Sprintf("aof_enabled:%d", flag(aof.enabled)),
+		fmt.Sprintf("aof_rewrite_in_progress:%d", flag(aof.rewriting)),
+		"aof_last_bgrewrite_status:" + status(aof.lastRewriteErr),
+		"aof_last_write_status:" + status(aof.lastWriteErr),
+	}
+	if aof.enabled {
+		info = append(info,
+			fmt.Sprintf("aof_current_size:%d", aof.size),
+			fmt.Sprintf("aof_base_size:%d", aof.baseSize))
+	}
+	return info
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	db "database/database"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+// startTestAOF opens the database at path with its append-only file set up as in cfg, recording its changes.
+func startTestAOF(t *testing.T, path string, cfg Config) *db.DB {
+	t.Helper()
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := startAOF(ctx, database, path, cfg); err != nil {
+		cancel()
+		database.Close(path)
+		t.Fatal(err)
+	}
+	database.SetApplyFunc(appendAOF)
+	t.Cleanup(func() {
+		cancel()
+		stopAOF()
+		database.Close(path)
+		configSet("appendfsync", aofFsyncEverysec)
+	})
+	return database
+}
+
+// checkAOF checks that the append-only file holds exactly the commands.
+func checkAOF(t *testing.T, path string, commands ...[]string) {
+	t.Helper()
+	var want []byte
+	for _, args := range commands {
+		want = append(want, encodeCommand(args...)...)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("expected the append-only file %q, got %q", want, got)
+	}
+}
+
+func TestAOFReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aof.db")
+	t.Run("append", func(t *testing.T) {
+		database := startTestAOF(t, path, Config{AppendOnly: true, AppendFsync: "always"})
+		database.Put("a", "1")
+		database.Put("b", "2")
+		database.Del("a")
+		checkAOF(t, path+".aof", []string{"SET", "a", "1"}, []string{"SET", "b", "2"}, []string{"DEL", "a"})
+	})
+
+	// A change the file misses is lost, and a command cut short by a crash is dropped
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.Put("stray", "x")
+	database.Close(path)
+	f, err := os.OpenFile(path+".aof", os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeCommand("SET", "c", "3"))
+	f.Write([]byte("*3\r\n$3\r\nSET\r\n$1\r\nd"))
+	f.Close()
+
+	t.Run("replay", func(t *testing.T) {
+		database := startTestAOF(t, path, Config{AppendOnly: true})
+		for key, want := range map[string]string{"a": "", "b": "2", "c": "3", "d": "", "stray": ""} {
+			if value, _, _ := database.Get(key); value != want {
+				t.Errorf("expected %s=%q after the replay, got %q", key, want, value)
+			}
+		}
+		database.Put("e", "5")
+		checkAOF(t, path+".aof", []string{"SET", "a", "1"}, []string{"SET", "b", "2"}, []string{"DEL", "a"},
+			[]string{"SET", "c", "3"}, []string{"SET", "e", "5"})
+
+		// A follower loading the snapshot of its leader records the removal of its contents
+		if err := database.Update(func(tx *db.Tx) error {
+			return replayCommand(tx, decodeTestCommand(t, commandFLUSHALL))
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, _ := database.Get("b"); ok {
+			t.Error("expected FLUSHALL to remove every key")
+		}
+	})
+}
+
+func TestAOFReplayRefusesCorruptFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry []byte
+	}{
+		{"unknown command", encodeCommand("INCR", "b")},
+		{"invalid pair", encodeCommand("SET", strings.Repeat("k", 100), "1")},
+		{"not a command", []byte("+OK\r\n")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "corrupt.db")
+			database, err := db.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer database.Close(path)
+			database.Put("a", "1")
+			var data []byte
+			data = append(data, encodeCommand("SET", "b", "2")...)
+			data = append(data, test.entry...)
+			data = append(data, encodeCommand("SET", "c", "3")...)
+			if err := os.WriteFile(path+".aof", data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// The file is refused before anything is replayed, leaving the database as it was
+			if err := replayAOF(database, path+".aof"); err == nil {
+				t.Fatal("expected the corrupted file to be refused")
+			}
+			for key, want := range map[string]bool{"a": true, "b": false, "c": false} {
+				if _, ok, _ := database.Get(key); ok != want {
+					t.Errorf("expected %s to be present: %v, got %v", key, want, ok)
+				}
+			}
+		})
+	}
+}
+
+// decodeTestCommand returns a command as read from the append-only file.
+func decodeTestCommand(t *testing.T, args ...string) resp.Value {
+	t.Helper()
+	v, _, err := resp.NewReader(bytes.NewReader(encodeCommand(args...))).ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestBgrewriteaof(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rewrite.db")
+	database := startTestAOF(t, path, Config{AppendOnly: true})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		l.Close()
+	})
+	go serve(ctx, l, database)
+	c := dialTestClient(t, l.Addr().String())
+
+	for i := 0; i < 10; i++ {
+		c.do(t, "SET", fmt.Sprint("key", i), fmt.Sprint(i))
+	}
+	for i := 0; i < 9; i++ {
+		c.do(t, "DEL", fmt.Sprint("key", i))
+	}
+	if v := c.do(t, "BGREWRITEAOF"); v.String() != "Background append only file rewriting started" {
+		t.Fatalf("unexpected BGREWRITEAOF reply %q", v.String())
+	}
+	waitFor(t, "the rewrite", func() bool {
+		return containsAll(c.do(t, "INFO", "persistence").String(), "aof_enabled:1", "aof_rewrite_in_progress:0", "aof_last_bgrewrite_status:ok")
+	})
+	checkAOF(t, path+".aof", []string{"SET", "key9", "9"})
+
+	// Changes go on being appended to the rewritten file
+	c.do(t, "SET", "after", "1")
+	checkAOF(t, path+".aof", []string{"SET", "key9", "9"}, []string{"SET", "after", "1"})
+	if v := c.do(t, "CONFIG", "GET", "append*").Array(); len(v) != 6 || v[3].String() != "everysec" || v[5].String() != "yes" {
+		t.Errorf("unexpected parameters %v", v)
+	}
+	c.do(t, "MULTI")
+	if v := c.do(t, "BGREWRITEAOF"); v.String() != "ERR Command not allowed inside a transaction" {
+		t.Errorf("expected BGREWRITEAOF to be refused in a transaction, got %q", v.String())
+	}
+	c.do(t, "DISCARD")
+}
+
+func TestAppendOnlyConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.db")
+	database := startTestAOF(t, path, Config{})
+	database.Put("a", "1")
+	if _, err := os.Stat(path + ".aof"); !os.IsNotExist(err) {
+		t.Fatalf("expected no append-only file while it is off, got %v", err)
+	}
+	if err := configSet("appendfsync", "sometimes"); err == nil {
+		t.Error("expected an invalid appendfsync to be refused")
+	}
+
+	// Turning the file on writes it from the contents, then appends the changes
+	if err := configSet("appendonly", "yes"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the append-only file", func() bool {
+		aof.mu.Lock()
+		defer aof.mu.Unlock()
+		return aof.file != nil
+	})
+	database.Put("b", "2")
+	checkAOF(t, path+".aof", []string{"SET", "a", "1"}, []string{"SET", "b", "2"})
+
+	if err := configSet("appendonly", "no"); err != nil {
+		t.Fatal(err)
+	}
+	database.Put("c", "3")
+	checkAOF(t, path+".aof", []string{"SET", "a", "1"}, []string{"SET", "b", "2"})
+}
+
+func TestAOFRaftSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	leaderPath := filepath.Join(dir, "leader.db")
+	leader, err := db.Open(leaderPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close(leaderPath)
+	leader.Put("a", "1")
+	leader.Put("b", "2")
+	var snapshot bytes.Buffer
+	if err := leader.Update(func(tx *db.Tx) error { return tx.WriteSnapshot(&snapshot) }); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "follower.db")
+	t.Run("install", func(t *testing.T) {
+		database := startTestAOF(t, path, Config{AppendOnly: true})
+		database.Put("stale", "x")
+		n, err := newRaftNode("follower", []string{"follower", "leader"}, nil, database, nil, path+".raft")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.handleSnapshot(&snapshotRequest{Term: 1, Leader: "leader", LastIndex: 3, LastTerm: 1, Data: snapshot.Bytes()})
+		n.storage.close()
+		database.Put("c", "3")
+		checkAOF(t, path+".aof", []string{"SET", "stale", "x"}, []string{"FLUSHALL"},
+			[]string{"SET", "a", "1"}, []string{"SET", "b", "2"}, []string{"SET", "c", "3"})
+	})
+
+	// The replay rebuilds the snapshot and the changes after it
+	t.Run("replay", func(t *testing.T) {
+		database := startTestAOF(t, path, Config{AppendOnly: true})
+		for key, want := range map[string]string{"a": "1", "b": "2", "c": "3", "stale": ""} {
+			if value, _, _ := database.Get(key); value != want {
+				t.Errorf("expected %s=%q after the replay, got %q", key, want, value)
+			}
+		}
+	})
+}
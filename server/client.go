@@ -1,155 +1,499 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	db "database/database"
 	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/tidwall/resp"
 )
 
 const (
-	Port        = 6379 // Default port for the server
-	receiveBufs = 1024 // Buffer size for receiving data
+	Port     = 6379         // Default port for the server
+	dataPath = "../data/db" // Path of the database file served to clients
 )
 
 var wg sync.WaitGroup
 
 // Createclient starts the client creation process and gracefully handles server shutdown on interrupt or termination signals.
-func Createclient() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
+// It returns the error that stopped the server, if any, once every connection is handled.
+func Createclient(cfg Config) error {
 	// Capture interrupt signal for graceful shutdown
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-		<-c
-		cancel()
-	}()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return createClient(ctx, cfg)
+}
 
+// createClient runs the server until ctx is cancelled, and waits for every connection to be handled.
+func createClient(ctx context.Context, cfg Config) error {
 	// Start the client and handle server errors
-	if err := Client(ctx, Port); err != nil {
-		fmt.Println("Server error:", err)
+	err := Client(ctx, cfg)
+	if err != nil {
+		logger().Error("server error", "err", err)
 	}
 
 	// Wait for all goroutines to finish
 	wg.Wait()
-	fmt.Println("All connections handled. Exiting.")
+	logger().Info("all connections handled, exiting")
+	return err
 }
 
-// Client starts the server, listening for incoming TCP connections on the specified port.
-// It handles context cancellation for graceful shutdown.
-func Client(ctx context.Context, Port int) error {
-	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", Port))
+// Client starts the server, listening for incoming connections on every listener enabled in cfg,
+// such as the plain TCP port, the TLS port and a Unix domain socket side by side.
+// It handles context cancellation for graceful shutdown. A listener that cannot be opened is reported
+// as a *ListenError, and one that fails to accept connections stops the server with an *AcceptError.
+func Client(ctx context.Context, cfg Config) error {
+	setLogger(cfg.Logger)
+	log := logger()
+	if err := cfg.applyLimits(); err != nil {
+		return err
+	}
+	listeners, err := cfg.listen()
 	if err != nil {
-		slog.Error("Failed to bind to Port")
-		os.Exit(1)
+		return err
+	}
+	for _, l := range listeners {
+		log.Info("listening", "network", l.Addr().Network(), "addr", l.Addr().String())
 	}
-	defer l.Close()
 
-	// Channel to signal listener closure
-	done := make(chan struct{})
+	// Open the database up front so its changes can be published as keyspace notifications
+	// and streamed to followers
+	path := cfg.DBPath
+	if path == "" {
+		path = dataPath
+	}
+	opts := []db.Option{db.WithLogger(log)}
+	if cfg.CompactFillFactor != 0 {
+		opts = append(opts, db.WithFillFactor(cfg.CompactFillFactor))
+	}
+	database, err := db.Open(path, opts...)
+	if err != nil {
+		closeListeners(listeners)
+		return err
+	}
+	setDatabasePath(path)
+	backupDir := cfg.BackupDir
+	if backupDir == "" {
+		backupDir = filepath.Dir(path)
+	}
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		closeListeners(listeners)
+		return err
+	}
+	if err := configSet("dir", backupDir); err != nil {
+		closeListeners(listeners)
+		return err
+	}
+	database.SetLatencyFunc(recordLatency)
+	// The append-only file is replayed before changes are recorded, so the replay is not appended again
+	if err := startAOF(ctx, database, path, cfg); err != nil {
+		closeListeners(listeners)
+		return err
+	}
+	defer stopAOF()
+	database.SetApplyFunc(applyChange)
+	go pingFollowers(ctx)
+	replication.mu.Lock()
+	replication.listeningPort = cfg.Port
+	if addr, ok := listeners[0].Addr().(*net.TCPAddr); ok {
+		replication.listeningPort = addr.Port
+	}
+	replication.mu.Unlock()
+	if len(cfg.RaftPeers) > 0 {
+		if err := startConsensus(ctx, database, path, cfg); err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		defer stopConsensus()
+	}
+	if cfg.ClusterEnabled {
+		if err := startCluster(path, cfg); err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		defer stopCluster()
+	}
+	if cfg.ReplicaOf != "" {
+		host, port, err := net.SplitHostPort(cfg.ReplicaOf)
+		if err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("invalid leader address %q: %w", cfg.ReplicaOf, err)
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("invalid leader address %q: %w", cfg.ReplicaOf, err)
+		}
+		startReplication(database, host, n)
+	}
+	go notifyKeyspaceEvents(ctx, database)
+	go sampleOps(ctx)
+	if cfg.MetricsAddr != "" {
+		if err := serveMetrics(ctx, cfg.MetricsAddr, database); err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		log.Info("serving metrics", "addr", cfg.MetricsAddr)
+	}
 
-	// Gracefully handle context cancellation and close the listener
+	// Gracefully handle context cancellation and close the listeners
+	serveCtx, stop := context.WithCancel(ctx)
+	defer stop()
 	go func() {
-		<-ctx.Done()
-		fmt.Println("Context canceled, closing listener...")
-		l.Close() // Close the listener to unblock Accept()
-		close(done)
+		<-serveCtx.Done()
+		log.Info("closing listeners")
+		closeListeners(listeners) // Close the listeners to unblock Accept()
 	}()
 
-	// Accept and handle connections
+	// Accept and handle connections on every listener, until one of them fails
+	var accepting sync.WaitGroup
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		accepting.Add(1)
+		go func(l net.Listener) {
+			defer accepting.Done()
+			if err := serve(serveCtx, l, database); err != nil {
+				errs <- err
+				stop()
+			}
+		}(l)
+	}
+	accepting.Wait()
+	log.Info("shutting down server")
+	stopReplication()
+	syncErr := database.Sync()
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return syncErr
+	}
+}
+
+// applyChange is the apply function of the database served: it invalidates the transactions watching
+// the key changed, whichever path changed it, and records the change in the replication stream and the
+// append-only file.
+func applyChange(event db.Event) {
+	touchKey(event.Key)
+	propagate(event)
+	appendAOF(event)
+}
+
+// serve accepts connections on l until the context is canceled, handling each one in a new goroutine.
+// Once maxclients clients are connected, new connections are refused with an error reply.
+// It returns an *AcceptError if the listener fails for another reason than shutdown.
+func serve(ctx context.Context, l net.Listener, database *db.DB) error {
 	for {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Shutting down server...")
-			return nil
-		default:
-			conn, err := l.Accept()
-			if err != nil {
-				slog.Error("Error accepting connection")
-				os.Exit(1)
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil // The listener was closed for shutdown
 			}
-			wg.Add(1)
-			go Handleconnection(conn) // Handle each connection in a new goroutine
+			return &AcceptError{Addr: l.Addr().String(), Err: err}
+		}
+		if !acquireClient() {
+			serverStats.rejected.Add(1)
+			go rejectClient(conn)
+			continue
 		}
+		serverStats.connections.Add(1)
+		setKeepAlive(conn)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer releaseClient()
+			Handleconnection(conn, database) // Handle each connection in a new goroutine
+		}()
+	}
+}
+
+// closeListeners closes every listener.
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
 	}
 }
 
-// Handleconnection processes a single connection from a client.
-// It reads client commands, executes them, and sends back appropriate responses.
-func Handleconnection(conn net.Conn) {
-	defer wg.Done()    // Ensure goroutine is finished when done
+// Handleconnection processes a single connection from a client against the given database, and
+// returns once the connection is closed. It reads client commands, executes them, and sends back
+// appropriate responses. The server serves every connection it accepts through it.
+// Once the client subscribes to a channel or pattern the connection enters subscription mode,
+// where only the subscription commands and PING are accepted. After MULTI, commands are
+// queued until EXEC runs them atomically or DISCARD drops them.
+// Clients that stay idle for longer than the timeout parameter are closed, except subscribers,
+// monitors and followers.
+func Handleconnection(conn net.Conn, db *db.DB) {
 	defer conn.Close() // Close connection when done
 
-	buf := make([]byte, receiveBufs)
-	file_path := "../data/db"
-	db, _ := db.Open(file_path)
+	s := newSession(conn, db)
+	s.log = s.log.With("addr", conn.RemoteAddr().String())
+	if timeout := idleTimeout(); timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout)) // Also bounds the TLS handshake
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// Complete the handshake up front, so a verified client certificate can select the ACL user
+		if err := tlsConn.Handshake(); err != nil {
+			s.log.Warn("TLS handshake failed", "err", err)
+			return
+		}
+		if user := certificateUser(tlsConn.ConnectionState()); user != "" {
+			s.user = user
+		}
+	}
+	defer removeFollower(s)        // Stop counting the follower when it goes away
+	defer stopMonitoring(s)        // Stop feeding commands when the client goes away
+	defer unwatchAll(s)            // Drop watched keys when the client goes away
+	defer pubsub.unsubscribeAll(s) // Drop subscriptions when the client goes away
+	defer s.kill()                 // Stop the writer when done
+	go s.writeLoop()
+
+	registerClient(s)
+	defer unregisterClient(s)
+	s.log.Debug("client connected")
+	defer s.log.Debug("client disconnected")
+
+	br := bufio.NewReader(conn) // Kept to report the bytes read ahead for CLIENT LIST
+	rd := resp.NewReader(br)
 
 	// Continuously read and process client commands
 	for {
-		dlen, err := conn.Read(buf)
+		if timeout := idleTimeout(); timeout > 0 && s.subscriptions() == 0 && !s.monitoring.Load() && !s.replica.Load() {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		v, _, _, err := rd.ReadMultiBulk()
 		if err != nil {
-			if !errors.Is(err, io.EOF) {
-				slog.Error("reading", "err", err)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				s.log.Debug("closing idle client")
+				return
+			}
+			if isProtocolError(err) {
+				// Malformed input only costs the client its connection, after an error reply
+				perr := &ProtocolError{Err: err}
+				s.log.Warn("malformed request", "err", perr)
+				s.reply(resp.ErrorValue(fmt.Errorf("ERR %v", perr)))
+				s.drain(time.Second)
+				return
+			}
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.log.Warn("reading command", "err", err)
 			}
 			return
 		}
-		comms := ParseClientCommand(string(buf[:dlen-2])) // Parse client command
-		commands, err := parseCommand(comms)              // Parse the command further
-
-		// Handle different types of commands
-		switch c := commands.(type) {
-		case SETcommand:
-			// Handle SET command: Store key-value pair in database
-			_, exists, _ := db.Get(c.key)
-			if !exists {
-				err := db.Put(c.key, c.val)
-				if err != nil {
-					conn.Write([]byte("Error setting the value "))
-				} else {
-					conn.Write([]byte("+OK\r\n"))
-				}
-			} else {
-				conn.Write([]byte("Key already exists\r\n"))
-			}
+		name := commandName(v.Array())
+		s.beginCommand(name, br.Buffered())
+		start := time.Now()
+		s.handleCommand(v.Array())
+		elapsed := time.Since(start)
+		observeCommand(name, elapsed)
+		s.logSlowCommand(v.Array(), start, elapsed)
+		recordLatency(latencyEventCommand, elapsed)
+		s.log.Debug("command", "cmd", strings.ToLower(name), "duration", elapsed)
+		s.endCommand()
+		serverStats.commands.Add(1)
+	}
+}
 
-		case GETcommand:
-			// Handle GET command: Retrieve value for the given key
-			value, _, err := db.Get(c.key)
-			if err != nil {
-				conn.Write([]byte("Error setting the value "))
-			} else {
-				if value == "" {
-					conn.Write([]byte("Key not found\r\n"))
-				} else {
-					conn.Write([]byte(value + "\r\n"))
-				}
-			}
-		case DELcommand:
-			// Handle DEL command: Delete the given key from the database
-			err := db.Del(c.key)
-			if err != nil {
-				conn.Write([]byte("Error setting the value\r\n"))
-			} else {
-				conn.Write([]byte("+OK\r\n"))
+// handleCommand parses, authorizes and dispatches a single command read from the client.
+func (s *session) handleCommand(values []resp.Value) {
+	commands, err := parseArray(values) // Parse the command
+	asking := s.asking                  // ASKING only applies to the command right after it
+	s.asking = false
+
+	if err := s.authorize(commandName(values), commands); err != nil {
+		// Denied commands are never queued or dispatched to the database
+		s.reply(resp.ErrorValue(err))
+		return
+	}
+	if s.subscriptions() > 0 && !allowedWhileSubscribed(commands) {
+		s.reply(resp.ErrorValue(fmt.Errorf("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")))
+		return
+	}
+	if err == nil {
+		if err := s.clusterRedirect(commands, asking); err != nil {
+			// Keys served by another node are redirected before they are queued or dispatched
+			if s.multi && !isTransactionCommand(commands) {
+				s.multiErr = true
 			}
-		default:
-			// Default response for unknown commands, :: This is done inorder to pass the redis-benchmarks
-			conn.Write([]byte("+OK\r\n"))
+			s.reply(resp.ErrorValue(err))
+			return
+		}
+		feedMonitors(s.monitorSource(), values)
+	}
+	if s.multi && !isTransactionCommand(commands) {
+		// Inside MULTI everything but the transaction commands is queued for EXEC
+		s.reply(s.queue(commands, err))
+		return
+	}
+	if err != nil {
+		// A malformed or unknown command only gets an error reply
+		s.reply(resp.ErrorValue(fmt.Errorf("ERR %v", err)))
+		return
+	}
+	s.waitWhilePaused(commands) // Hold the command back while CLIENT PAUSE is in effect
+
+	// Handle different types of commands
+	switch c := commands.(type) {
+	case SUBSCRIBEcommand:
+		// Handle SUBSCRIBE command: Confirm each channel with the current subscription count
+		for _, channel := range c.channels {
+			pubsub.subscribe(s, channel)
+			s.reply(subscriptionReply("subscribe", channel, s.subscriptions()))
+		}
+	case UNSUBSCRIBEcommand:
+		// Handle UNSUBSCRIBE command: Without channels, unsubscribe from all of them
+		channels := c.channels
+		if len(channels) == 0 {
+			channels = sortedNames(s.channels)
+		}
+		if len(channels) == 0 {
+			s.reply(subscriptionReply("unsubscribe", "", s.subscriptions()))
+		}
+		for _, channel := range channels {
+			pubsub.unsubscribe(s, channel)
+			s.reply(subscriptionReply("unsubscribe", channel, s.subscriptions()))
 		}
+	case PSUBSCRIBEcommand:
+		// Handle PSUBSCRIBE command: Confirm each pattern with the current subscription count
+		for _, pattern := range c.patterns {
+			pubsub.psubscribe(s, pattern)
+			s.reply(subscriptionReply("psubscribe", pattern, s.subscriptions()))
+		}
+	case PUNSUBSCRIBEcommand:
+		// Handle PUNSUBSCRIBE command: Without patterns, unsubscribe from all of them
+		patterns := c.patterns
+		if len(patterns) == 0 {
+			patterns = sortedNames(s.patterns)
+		}
+		if len(patterns) == 0 {
+			s.reply(subscriptionReply("punsubscribe", "", s.subscriptions()))
+		}
+		for _, pattern := range patterns {
+			pubsub.punsubscribe(s, pattern)
+			s.reply(subscriptionReply("punsubscribe", pattern, s.subscriptions()))
+		}
+	case MULTIcommand:
+		// Handle MULTI command: Start queueing commands
+		s.reply(s.multiCommand())
+	case EXECcommand:
+		// Handle EXEC command: Run the queued commands under a single write lock
+		s.reply(s.exec())
+	case DISCARDcommand:
+		// Handle DISCARD command: Drop the queued commands
+		s.reply(s.discard())
+	case WATCHcommand:
+		// Handle WATCH command: Abort the next EXEC if one of the keys is modified
+		s.reply(s.watch(c.keys))
+	case UNWATCHcommand:
+		// Handle UNWATCH command: Forget every watched key
+		unwatchAll(s)
+		s.reply(resp.SimpleStringValue("OK"))
+	case REPLICAOFcommand:
+		// Handle REPLICAOF command: Outside of the database lock, since it waits for the link to stop
+		s.reply(replicaofCommand(s.db, c))
+	case PSYNCcommand:
+		// Handle PSYNC command: Turn the connection into a follower receiving the replication stream
+		s.psync(c)
+	case REPLCONFcommand:
+		// Handle REPLCONF command: Configure the follower link or record its acknowledged offset
+		s.replconf(c)
+	case CLUSTERcommand:
+		// Handle CLUSTER command: Outside of the database lock, since it scans the keys of a slot
+		s.reply(s.clusterCommand(c))
+	case ASKINGcommand:
+		// Handle ASKING command: Let the next command access a slot this node is importing
+		s.asking = true
+		s.reply(resp.SimpleStringValue("OK"))
+	case MIGRATEcommand:
+		// Handle MIGRATE command: Outside of the database lock, since it waits for the target node
+		s.reply(s.migrate(c))
+	case SAVEcommand:
+		// Handle SAVE command: Outside of the database lock, since the backup only holds writers back to start
+		s.reply(saveCommand(s.db))
+	case BGSAVEcommand:
+		// Handle BGSAVE command: Write the backup in the background
+		s.reply(bgsaveCommand(s.db))
+	case COMPACTcommand:
+		// Handle COMPACT command: Outside of the database lock, since readers go on during the rebuild
+		s.reply(s.compactCommand())
+	case BGREWRITEAOFcommand:
+		// Handle BGREWRITEAOF command: Rewrite the append-only file in the background
+		s.reply(bgrewriteaofCommand())
+	default:
+		// Data and server commands: SET, GET, DEL, PING, PUBLISH, CONFIG, CLIENT and the like
+		s.reply(s.run(commands))
+	}
+}
+
+// authorize checks that the connection is authenticated and its user may run the command.
+// AUTH is always allowed, and so is ACL WHOAMI once authenticated.
+func (s *session) authorize(name string, cmd command) error {
+	if _, ok := cmd.(AUTHcommand); ok {
+		return nil
+	}
+	if s.user == "" {
+		return fmt.Errorf("NOAUTH Authentication required.")
+	}
+	if c, ok := cmd.(ACLcommand); ok && c.subcommand == "WHOAMI" {
+		return nil
+	}
+	if name == "" {
+		return nil
+	}
+	return checkPermission(s.user, name, cmd)
+}
+
+// commandName returns the upper-cased name of the command in a RESP array, or "" if the array is empty.
+func commandName(values []resp.Value) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.ToUpper(values[0].String())
+}
+
+// allowedWhileSubscribed reports whether a command may run while the client is in subscription mode.
+func allowedWhileSubscribed(cmd command) bool {
+	switch cmd.(type) {
+	case SUBSCRIBEcommand, UNSUBSCRIBEcommand, PSUBSCRIBEcommand, PUNSUBSCRIBEcommand, PINGcommand:
+		return true
+	}
+	return false
+}
+
+// subscriptionReply builds the confirmation sent for a (P)SUBSCRIBE or (P)UNSUBSCRIBE of a single name.
+func subscriptionReply(kind, name string, count int) resp.Value {
+	nameValue := resp.StringValue(name)
+	if name == "" {
+		nameValue = resp.NullValue()
+	}
+	return resp.ArrayValue([]resp.Value{resp.StringValue(kind), nameValue, resp.IntegerValue(count)})
+}
+
+// sortedNames returns the names in the set in lexical order.
+func sortedNames(set map[string]struct{}) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
 // ParseClientCommand parses the client command into a RESP-formatted string.
@@ -1,7 +1,14 @@
 package server
 
 import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
 )
 
 // func TestParseclientcommand(t *testing.T) {
@@ -9,5 +16,68 @@ import (
 // }
 
 func TestCreateclient(t *testing.T) {
-	Createclient()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- createClient(ctx, Config{Listeners: []net.Listener{l}, DBPath: filepath.Join(t.TempDir(), "db")})
+	}()
+
+	c := dialTestClient(t, l.Addr().String())
+	if v := c.do(t, "PING"); v.String() != "PONG" {
+		t.Errorf("unexpected PING reply %q", v.String())
+	}
+	c.conn.Close()
+
+	// Cancelling the context, as a signal does, stops the server once the connections are handled
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the server to stop once cancelled")
+	}
+}
+
+func TestHandleconnection(t *testing.T) {
+	database := openTestDB(t)
+	if err := database.Put("greeting", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	conn, peer := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		Handleconnection(peer, database)
+		close(done)
+	}()
+
+	// The connection is served against the database it is given
+	c := &testClient{conn: conn, rd: resp.NewReader(conn)}
+	if v := c.do(t, "GET", "greeting"); v.String() != "hello" {
+		t.Errorf("unexpected GET reply %q", v.String())
+	}
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Handleconnection to return once the connection is closed")
+	}
+}
+
+func TestMalformedCommand(t *testing.T) {
+	c := dialTestClient(t, startTestServer(t))
+	for _, args := range [][]string{{"SET", "onlykey"}, {"GET"}, {"NOSUCHCOMMAND", "x"}} {
+		if v := c.do(t, args...); v.Type() != resp.Error || !strings.HasPrefix(v.String(), "ERR ") {
+			t.Errorf("expected an error reply to %v, got %q", args, v.String())
+		}
+	}
+	if v := c.do(t, "GET", "onlykey"); !v.IsNull() {
+		t.Errorf("expected a malformed SET to store nothing, got %q", v.String())
+	}
 }
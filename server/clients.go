@@ -0,0 +1,338 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// clients is the registry of the connections currently served, used by the CLIENT command.
+var clients = struct {
+	mu       sync.RWMutex       // Mutex to protect sessions.
+	nextID   atomic.Int64       // ID handed to the next connection.
+	sessions map[int64]*session // Connected sessions by ID.
+}{
+	sessions: make(map[int64]*session),
+}
+
+// registerClient adds a session to the registry.
+func registerClient(s *session) {
+	s.endCommand() // Record the initial state
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	clients.sessions[s.id] = s
+}
+
+// unregisterClient removes a session from the registry.
+func unregisterClient(s *session) {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+	delete(clients.sessions, s.id)
+}
+
+// connectedSessions returns the registered sessions ordered by ID.
+func connectedSessions() []*session {
+	clients.mu.RLock()
+	defer clients.mu.RUnlock()
+	sessions := make([]*session, 0, len(clients.sessions))
+	for _, s := range clients.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	return sessions
+}
+
+// clientStats is what the connection goroutine records about a client for CLIENT LIST,
+// which runs on other connections.
+type clientStats struct {
+	name        string    // Name set with CLIENT SETNAME.
+	user        string    // The ACL user the client is authenticated as.
+	lastCommand string    // Lower-case name of the last command.
+	lastActive  time.Time // When the last command was received.
+	multi       int       // Number of commands queued since MULTI, or -1 outside MULTI.
+	queryBuffer int       // Bytes of following commands already read from the connection.
+}
+
+// beginCommand records that a command was received, with the bytes read ahead of it.
+func (s *session) beginCommand(name string, queryBuffer int) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.stats.lastCommand = strings.ToLower(name)
+	s.stats.lastActive = time.Now()
+	s.stats.queryBuffer = queryBuffer
+}
+
+// endCommand records the connection state a command left behind.
+func (s *session) endCommand() {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.stats.user = s.user
+	s.stats.multi = -1
+	if s.multi {
+		s.stats.multi = len(s.queued)
+	}
+}
+
+// clientName returns the name set with CLIENT SETNAME.
+func (s *session) clientName() string {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.stats.name
+}
+
+// clientUser returns the user the client was authenticated as after its last command.
+func (s *session) clientUser() string {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.stats.user
+}
+
+// describe returns the line CLIENT LIST and CLIENT INFO show for the client.
+func (s *session) describe() string {
+	s.statsMu.Lock()
+	stats := s.stats
+	s.statsMu.Unlock()
+	pubsub.mu.RLock()
+	channels, patterns := len(s.channels), len(s.patterns)
+	pubsub.mu.RUnlock()
+
+	flags := ""
+	if channels+patterns > 0 {
+		flags += "P"
+	}
+	if stats.multi >= 0 {
+		flags += "x"
+	}
+	if s.dirty.Load() {
+		flags += "d"
+	}
+	if s.monitoring.Load() {
+		flags += "O"
+	}
+	if s.replica.Load() {
+		flags += "S"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	now := time.Now()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d multi=%d qbuf=%d oll=%d omem=%d user=%s cmd=%s",
+		s.id, s.conn.RemoteAddr(), s.conn.LocalAddr(), stats.name,
+		int(now.Sub(s.created).Seconds()), int(now.Sub(stats.lastActive).Seconds()), flags,
+		channels, patterns, stats.multi, stats.queryBuffer, len(s.out), s.outBytes.Load(), stats.user, stats.lastCommand)
+}
+
+// clientCommand executes a CLIENT subcommand and returns the reply.
+func (s *session) clientCommand(c CLIENTcommand) resp.Value {
+	switch c.subcommand {
+	case "ID":
+		return resp.IntegerValue(int(s.id))
+	case "INFO":
+		return resp.StringValue(s.describe() + "\n")
+	case "GETNAME":
+		if name := s.clientName(); name != "" {
+			return resp.StringValue(name)
+		}
+		return resp.NullValue()
+	case "SETNAME":
+		if strings.ContainsAny(c.args[0], " \n") {
+			return resp.ErrorValue(fmt.Errorf("ERR Client names cannot contain spaces, newlines or special characters."))
+		}
+		s.statsMu.Lock()
+		s.stats.name = c.args[0]
+		s.statsMu.Unlock()
+		return resp.SimpleStringValue("OK")
+	case "LIST":
+		return clientList(c.args)
+	case "KILL":
+		return s.clientKill(c.args)
+	case "PAUSE":
+		return pauseCommand(c.args)
+	}
+	// UNPAUSE
+	unpauseClients()
+	return resp.SimpleStringValue("OK")
+}
+
+// clientList returns the CLIENT LIST reply, optionally limited to the clients after an ID argument.
+func clientList(args []string) resp.Value {
+	var ids map[int64]bool
+	if len(args) > 0 {
+		ids = make(map[int64]bool)
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id <= 0 {
+				return resp.ErrorValue(fmt.Errorf("ERR Invalid client ID"))
+			}
+			ids[id] = true
+		}
+	}
+	var b strings.Builder
+	for _, other := range connectedSessions() {
+		if ids == nil || ids[other.id] {
+			b.WriteString(other.describe())
+			b.WriteByte('\n')
+		}
+	}
+	return resp.StringValue(b.String())
+}
+
+// clientKill closes the clients matching the filters of a CLIENT KILL command.
+// The old form with a single address replies OK or an error, the filter form the number of clients killed.
+func (s *session) clientKill(args []string) resp.Value {
+	if len(args) == 1 {
+		for _, other := range connectedSessions() {
+			if other.conn.RemoteAddr().String() == args[0] {
+				other.kill()
+				return resp.SimpleStringValue("OK")
+			}
+		}
+		return resp.ErrorValue(fmt.Errorf("ERR No such client"))
+	}
+
+	skipMe := true
+	var filters []func(other *session) bool
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return resp.ErrorValue(fmt.Errorf("ERR client-id should be greater than 0"))
+			}
+			filters = append(filters, func(other *session) bool { return other.id == id })
+		case "ADDR":
+			filters = append(filters, func(other *session) bool { return other.conn.RemoteAddr().String() == value })
+		case "LADDR":
+			filters = append(filters, func(other *session) bool { return other.conn.LocalAddr().String() == value })
+		case "USER":
+			filters = append(filters, func(other *session) bool { return other.clientUser() == value })
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return resp.ErrorValue(fmt.Errorf("ERR syntax error"))
+			}
+		default:
+			return resp.ErrorValue(fmt.Errorf("ERR syntax error"))
+		}
+	}
+
+	killed := 0
+	for _, other := range connectedSessions() {
+		if skipMe && other == s {
+			continue
+		}
+		matches := true
+		for _, filter := range filters {
+			matches = matches && filter(other)
+		}
+		if matches {
+			other.kill()
+			killed++
+		}
+	}
+	return resp.IntegerValue(killed)
+}
+
+// pause holds the state of CLIENT PAUSE.
+var pause = struct {
+	mu      sync.Mutex    // Mutex to protect the pause state.
+	until   time.Time     // When the pause ends.
+	writes  bool          // Whether only commands that write are paused.
+	resumed chan struct{} // Closed when CLIENT UNPAUSE ends the pause early.
+}{
+	resumed: make(chan struct{}),
+}
+
+// pauseCommand parses the arguments of CLIENT PAUSE and pauses the clients.
+func pauseCommand(args []string) resp.Value {
+	ms, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || ms < 0 {
+		return resp.ErrorValue(fmt.Errorf("ERR timeout is not an integer or out of range"))
+	}
+	writesOnly := false
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case "WRITE":
+			writesOnly = true
+		case "ALL":
+		default:
+			return resp.ErrorValue(fmt.Errorf("ERR syntax error"))
+		}
+	}
+	pauseClients(time.Duration(ms)*time.Millisecond, writesOnly)
+	return resp.SimpleStringValue("OK")
+}
+
+// pauseClients holds back the commands of every client for the duration, or only the ones that write.
+// A pause that is already in effect is only ever extended and made stricter.
+func pauseClients(d time.Duration, writesOnly bool) {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	until := time.Now().Add(d)
+	if time.Now().Before(pause.until) {
+		writesOnly = writesOnly && pause.writes
+		if pause.until.After(until) {
+			until = pause.until
+		}
+	}
+	pause.until = until
+	pause.writes = writesOnly
+}
+
+// unpauseClients ends a pause early and lets the held back commands run.
+func unpauseClients() {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	pause.until = time.Time{}
+	close(pause.resumed)
+	pause.resumed = make(chan struct{})
+}
+
+// waitWhilePaused blocks until the command may run under the current pause, or the session is closed.
+// CLIENT commands are never paused, so a pause can always be lifted.
+func (s *session) waitWhilePaused(cmd command) {
+	if _, ok := cmd.(CLIENTcommand); ok {
+		return
+	}
+	for {
+		pause.mu.Lock()
+		remaining := time.Until(pause.until)
+		applies := !pause.writes || pausedByWrite(cmd)
+		resumed := pause.resumed
+		pause.mu.Unlock()
+		if remaining <= 0 || !applies {
+			return
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-resumed:
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// pausedByWrite reports whether CLIENT PAUSE WRITE holds back a command:
+// the ones that may modify keys, PUBLISH and EXEC.
+func pausedByWrite(cmd command) bool {
+	switch cmd.(type) {
+	case PUBLISHcommand, EXECcommand:
+		return true
+	}
+	return isWriteCommand(cmd)
+}
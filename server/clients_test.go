@@ -0,0 +1,159 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// testClient is a RESP client connection to a test server.
+type testClient struct {
+	conn net.Conn
+	rd   *resp.Reader
+}
+
+// dialTestClient connects to a test server started with startTestServer.
+func dialTestClient(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn: conn, rd: resp.NewReader(conn)}
+}
+
+// do sends a command and returns its reply.
+func (c *testClient) do(t *testing.T, args ...string) resp.Value {
+	t.Helper()
+	c.send(t, args...)
+	v, _, err := c.rd.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// send sends a command without waiting for its reply.
+func (c *testClient) send(t *testing.T, args ...string) {
+	t.Helper()
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.StringValue(arg)
+	}
+	b, _ := resp.ArrayValue(values).MarshalRESP()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientNameAndID(t *testing.T) {
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+
+	if v := c.do(t, "CLIENT", "GETNAME"); !v.IsNull() {
+		t.Errorf("expected no name, got %q", v.String())
+	}
+	if v := c.do(t, "CLIENT", "SETNAME", "worker-1"); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	if v := c.do(t, "CLIENT", "GETNAME"); v.String() != "worker-1" {
+		t.Errorf("unexpected name %q", v.String())
+	}
+	if v := c.do(t, "CLIENT", "SETNAME", "has space"); v.Type() != resp.Error {
+		t.Error("expected a name with a space to be rejected")
+	}
+
+	id := c.do(t, "CLIENT", "ID").Integer()
+	info := c.do(t, "CLIENT", "INFO").String()
+	for _, field := range []string{"id=" + strconv.Itoa(id) + " ", "name=worker-1 ", "flags=N ", "user=default ", "cmd=client"} {
+		if !strings.Contains(info, field) {
+			t.Errorf("expected %q in %q", field, info)
+		}
+	}
+}
+
+func TestClientListAndKill(t *testing.T) {
+	addr := startTestServer(t)
+	admin := dialTestClient(t, addr)
+	victim := dialTestClient(t, addr)
+	victim.do(t, "CLIENT", "SETNAME", "victim")
+	victim.do(t, "MULTI")
+	victimID := victim.do(t, "CLIENT", "ID") // Queued, so the reply is QUEUED
+	if victimID.String() != "QUEUED" {
+		t.Fatalf("unexpected reply %q", victimID.String())
+	}
+
+	list := admin.do(t, "CLIENT", "LIST").String()
+	var line string
+	for _, l := range strings.Split(list, "\n") {
+		if strings.Contains(l, "name=victim ") {
+			line = l
+		}
+	}
+	if line == "" {
+		t.Fatalf("victim missing from %q", list)
+	}
+	if !strings.Contains(line, "flags=x ") || !strings.Contains(line, "multi=1 ") {
+		t.Errorf("expected the queued transaction in %q", line)
+	}
+
+	id := strings.Fields(line)[0][len("id="):]
+	if v := admin.do(t, "CLIENT", "KILL", "ID", id); v.Integer() != 1 {
+		t.Errorf("expected one client to be killed, got %v", v)
+	}
+	victim.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := victim.conn.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the killed client to be disconnected")
+	}
+	if v := admin.do(t, "CLIENT", "KILL", "127.0.0.1:1"); v.Type() != resp.Error {
+		t.Error("expected killing an unknown address to fail")
+	}
+	// SKIPME defaults to yes, so the admin survives killing by its own user.
+	admin.do(t, "CLIENT", "KILL", "USER", "default")
+	if v := admin.do(t, "PING"); v.String() != "PONG" {
+		t.Errorf("unexpected reply %q", v.String())
+	}
+}
+
+func TestClientPauseWrite(t *testing.T) {
+	addr := startTestServer(t)
+	admin := dialTestClient(t, addr)
+	writer := dialTestClient(t, addr)
+
+	if v := admin.do(t, "CLIENT", "PAUSE", "5000", "WRITE"); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	defer unpauseClients()
+
+	// Reads go through during a write pause.
+	if v := writer.do(t, "GET", "paused"); !v.IsNull() {
+		t.Errorf("unexpected reply %q", v.String())
+	}
+
+	done := make(chan resp.Value, 1)
+	writer.send(t, "SET", "paused", "value")
+	go func() {
+		v, _, _ := writer.rd.ReadValue()
+		done <- v
+	}()
+	select {
+	case <-done:
+		t.Fatal("expected SET to wait for the pause to end")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	admin.do(t, "CLIENT", "UNPAUSE")
+	select {
+	case v := <-done:
+		if v.String() != "OK" {
+			t.Errorf("unexpected reply %q", v.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SET to run after CLIENT UNPAUSE")
+	}
+}
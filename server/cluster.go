@@ -0,0 +1,695 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	db "database/database"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	clusterSlots       = 16384    // Number of hash slots the keys of a cluster are partitioned into
+	clusterNodesSuffix = ".nodes" // Suffix of the file the slot table is saved to, next to the database file
+)
+
+// clusterNode is a server of the cluster.
+type clusterNode struct {
+	ID   string `json:"id"`   // SHA1 hex digest of the address, as reported by CLUSTER MYID.
+	Addr string `json:"addr"` // Address clients reach the node at, as "host:port".
+}
+
+// slotRange is a range of consecutive slots served by the same node, as persisted in the nodes file.
+type slotRange struct {
+	Start int    `json:"start"` // First slot of the range.
+	End   int    `json:"end"`   // Last slot of the range, included.
+	Node  string `json:"node"`  // ID of the node serving the range.
+}
+
+// clusterState is the content of the nodes file, which keeps the slot table across restarts.
+type clusterState struct {
+	Myself    string         `json:"myself"`              // ID of this server.
+	Nodes     []*clusterNode `json:"nodes"`               // Every known node, this server included.
+	Slots     []slotRange    `json:"slots"`               // The slots assigned to a node.
+	Migrating map[int]string `json:"migrating,omitempty"` // Slots moving away, with the ID of the target node.
+	Importing map[int]string `json:"importing,omitempty"` // Slots moving here, with the ID of the source node.
+}
+
+// cluster holds the slot table of the server when it is started in cluster mode.
+// Every key belongs to the slot keySlot reports, and commands on slots served by another node
+// are redirected to it with -MOVED, or with -ASK while the slot is migrating.
+var cluster = struct {
+	mu        sync.RWMutex               // Mutex to protect the fields below.
+	enabled   bool                       // Whether the server runs in cluster mode.
+	path      string                     // Path of the nodes file; empty keeps the table in memory.
+	myself    *clusterNode               // This server.
+	nodes     map[string]*clusterNode    // Known nodes by ID.
+	slots     [clusterSlots]*clusterNode // Node serving each slot; nil for unassigned slots.
+	migrating map[int]*clusterNode       // Slots moving away, with the target node.
+	importing map[int]*clusterNode       // Slots moving here, with the source node.
+}{}
+
+// crc16 computes the CRC16 checksum used by Redis Cluster (XMODEM: polynomial 0x1021, initial value 0).
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// keySlot returns the hash slot of a key. If the key contains a non-empty {hashtag},
+// only the tag is hashed, so related keys can be kept in the same slot.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// nodeID returns the ID of the node at an address.
+func nodeID(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
+
+// startCluster enables cluster mode. The slot table is read back from the nodes file next to the database
+// if there is one; otherwise the slots are split evenly over cfg.ClusterNodes in address order.
+func startCluster(path string, cfg Config) error {
+	addr := cfg.ClusterAddr
+	if addr == "" {
+		addr = "127.0.0.1:" + strconv.Itoa(cfg.Port)
+	}
+	nodes := cfg.ClusterNodes
+	if len(nodes) == 0 {
+		nodes = []string{addr}
+	}
+	if !slices.Contains(nodes, addr) {
+		return fmt.Errorf("the cluster address %s is not one of the nodes %v", addr, nodes)
+	}
+	b, err := os.ReadFile(path + clusterNodesSuffix)
+	switch {
+	case err == nil:
+		var state clusterState
+		if err := json.Unmarshal(b, &state); err != nil {
+			return fmt.Errorf("reading %s.nodes: %w", path, err)
+		}
+		if state.Myself != nodeID(addr) {
+			return fmt.Errorf("reading %s.nodes: the file belongs to another node than %s", path, addr)
+		}
+		if err := loadCluster(state, path+clusterNodesSuffix); err != nil {
+			return fmt.Errorf("reading %s.nodes: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if err := configureCluster(addr, nodes, path+clusterNodesSuffix); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+	logger().Info("cluster mode enabled", "addr", addr, "id", nodeID(addr))
+	return nil
+}
+
+// configureCluster enables cluster mode as the node at myself, with the slots split evenly over the nodes
+// in address order. The table is persisted to path, unless it is empty.
+func configureCluster(myself string, addrs []string, path string) error {
+	addrs = slices.Clone(addrs)
+	sort.Strings(addrs)
+	state := clusterState{Myself: nodeID(myself)}
+	for i, addr := range addrs {
+		state.Nodes = append(state.Nodes, &clusterNode{ID: nodeID(addr), Addr: addr})
+		state.Slots = append(state.Slots, slotRange{
+			Start: i * clusterSlots / len(addrs),
+			End:   (i+1)*clusterSlots/len(addrs) - 1,
+			Node:  nodeID(addr),
+		})
+	}
+	if err := loadCluster(state, path); err != nil {
+		return err
+	}
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	return saveCluster()
+}
+
+// loadCluster replaces the slot table with the one described by state.
+func loadCluster(state clusterState, path string) error {
+	nodes := make(map[string]*clusterNode, len(state.Nodes))
+	for _, n := range state.Nodes {
+		nodes[n.ID] = n
+	}
+	myself := nodes[state.Myself]
+	if myself == nil {
+		return fmt.Errorf("unknown node %s for this server", state.Myself)
+	}
+	var slots [clusterSlots]*clusterNode
+	for _, r := range state.Slots {
+		n := nodes[r.Node]
+		if n == nil || r.Start < 0 || r.End >= clusterSlots || r.Start > r.End {
+			return fmt.Errorf("invalid slot range %d-%d of node %s", r.Start, r.End, r.Node)
+		}
+		for slot := r.Start; slot <= r.End; slot++ {
+			slots[slot] = n
+		}
+	}
+	migrating, err := slotNodes(state.Migrating, nodes)
+	if err != nil {
+		return err
+	}
+	importing, err := slotNodes(state.Importing, nodes)
+	if err != nil {
+		return err
+	}
+
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	cluster.enabled = true
+	cluster.path = path
+	cluster.myself = myself
+	cluster.nodes = nodes
+	cluster.slots = slots
+	cluster.migrating = migrating
+	cluster.importing = importing
+	return nil
+}
+
+// slotNodes resolves the node IDs of migrating or importing slots.
+func slotNodes(ids map[int]string, nodes map[string]*clusterNode) (map[int]*clusterNode, error) {
+	result := make(map[int]*clusterNode, len(ids))
+	for slot, id := range ids {
+		n := nodes[id]
+		if n == nil || slot < 0 || slot >= clusterSlots {
+			return nil, fmt.Errorf("invalid migration of slot %d with node %s", slot, id)
+		}
+		result[slot] = n
+	}
+	return result, nil
+}
+
+// saveCluster persists the slot table to the nodes file; the caller holds cluster.mu.
+func saveCluster() error {
+	if cluster.path == "" {
+		return nil
+	}
+	state := clusterState{
+		Myself:    cluster.myself.ID,
+		Migrating: make(map[int]string, len(cluster.migrating)),
+		Importing: make(map[int]string, len(cluster.importing)),
+	}
+	for _, id := range sortedNodeIDs() {
+		state.Nodes = append(state.Nodes, cluster.nodes[id])
+	}
+	state.Slots = slotRanges()
+	for slot, n := range cluster.migrating {
+		state.Migrating[slot] = n.ID
+	}
+	for slot, n := range cluster.importing {
+		state.Importing[slot] = n.ID
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(cluster.path, b)
+}
+
+// stopCluster leaves cluster mode once the server shuts down.
+func stopCluster() {
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	cluster.enabled = false
+	cluster.path = ""
+	cluster.myself = nil
+	cluster.nodes = nil
+	cluster.slots = [clusterSlots]*clusterNode{}
+	cluster.migrating = nil
+	cluster.importing = nil
+}
+
+// sortedNodeIDs returns the IDs of the known nodes in lexical order; the caller holds cluster.mu.
+func sortedNodeIDs() []string {
+	ids := make([]string, 0, len(cluster.nodes))
+	for id := range cluster.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// slotRanges returns the assigned slots as ranges of consecutive slots served by the same node;
+// the caller holds cluster.mu.
+func slotRanges() []slotRange {
+	var ranges []slotRange
+	for slot, n := range cluster.slots {
+		if n == nil {
+			continue
+		}
+		if last := len(ranges) - 1; last >= 0 && ranges[last].Node == n.ID && ranges[last].End == slot-1 {
+			ranges[last].End = slot
+			continue
+		}
+		ranges = append(ranges, slotRange{Start: slot, End: slot, Node: n.ID})
+	}
+	return ranges
+}
+
+// clusterRedirect checks that the keys of a command are served by this server in cluster mode.
+// Keys of another node are redirected with -MOVED. While a slot migrates away, keys that are already gone
+// are redirected to the target with -ASK; the target serves them after ASKING while it imports the slot.
+func (s *session) clusterRedirect(cmd command, asking bool) error {
+	if _, ok := cmd.(MIGRATEcommand); ok {
+		return nil // MIGRATE moves keys of its own slots and replies NOKEY for missing ones
+	}
+	keys := commandKeys(cmd)
+	if len(keys) == 0 {
+		return nil
+	}
+	cluster.mu.RLock()
+	if !cluster.enabled {
+		cluster.mu.RUnlock()
+		return nil
+	}
+	slot := keySlot(keys[0])
+	for _, key := range keys[1:] {
+		if keySlot(key) != slot {
+			cluster.mu.RUnlock()
+			return fmt.Errorf("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+	owner, myself := cluster.slots[slot], cluster.myself
+	migrating, importing := cluster.migrating[slot], cluster.importing[slot]
+	cluster.mu.RUnlock()
+
+	switch {
+	case owner == nil:
+		return fmt.Errorf("CLUSTERDOWN Hash slot not served")
+	case owner == myself && migrating != nil:
+		// The database is read outside of cluster.mu, since commands hold the database lock first
+		missing := 0
+		for _, key := range keys {
+			if _, ok, _ := s.db.Get(key); !ok {
+				missing++
+			}
+		}
+		switch {
+		case missing == len(keys):
+			return fmt.Errorf("ASK %d %s", slot, migrating.Addr)
+		case missing > 0:
+			return fmt.Errorf("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+		return nil
+	case owner == myself, asking && importing != nil:
+		return nil
+	}
+	return fmt.Errorf("MOVED %d %s", slot, owner.Addr)
+}
+
+// clusterCommand executes a CLUSTER command and returns the reply. KEYSLOT is answered even
+// when cluster mode is disabled.
+func (s *session) clusterCommand(c CLUSTERcommand) resp.Value {
+	if c.subcommand == "KEYSLOT" {
+		return resp.IntegerValue(keySlot(c.args[0]))
+	}
+	cluster.mu.RLock()
+	enabled := cluster.enabled
+	cluster.mu.RUnlock()
+	if !enabled {
+		return resp.ErrorValue(fmt.Errorf("ERR This instance has cluster support disabled"))
+	}
+
+	switch c.subcommand {
+	case "COUNTKEYSINSLOT", "GETKEYSINSLOT":
+		// The database is scanned outside of cluster.mu, since commands hold the database lock first
+		slot, err := parseSlot(c.args[0])
+		if err != nil {
+			return resp.ErrorValue(err)
+		}
+		limit := -1
+		if c.subcommand == "GETKEYSINSLOT" {
+			if limit, err = strconv.Atoi(c.args[1]); err != nil || limit < 0 {
+				return resp.ErrorValue(fmt.Errorf("ERR Invalid number of keys"))
+			}
+		}
+		return keysInSlot(s, slot, limit)
+	case "SETSLOT":
+		return setSlot(c.args)
+	}
+
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	switch c.subcommand {
+	case "MYID":
+		return resp.StringValue(cluster.myself.ID)
+	case "INFO":
+		return resp.StringValue(strings.Join(clusterInfoLines(), "\r\n") + "\r\n")
+	case "NODES":
+		return resp.StringValue(clusterNodes())
+	case "SLOTS":
+		ranges := slotRanges()
+		values := make([]resp.Value, len(ranges))
+		for i, r := range ranges {
+			values[i] = resp.ArrayValue([]resp.Value{
+				resp.IntegerValue(r.Start),
+				resp.IntegerValue(r.End),
+				nodeEndpoint(cluster.nodes[r.Node]),
+			})
+		}
+		return resp.ArrayValue(values)
+	}
+	// SHARDS
+	var values []resp.Value
+	ranges := slotRanges()
+	for _, id := range sortedNodeIDs() {
+		n := cluster.nodes[id]
+		var slots []resp.Value
+		for _, r := range ranges {
+			if r.Node == id {
+				slots = append(slots, resp.IntegerValue(r.Start), resp.IntegerValue(r.End))
+			}
+		}
+		host, port := splitNodeAddr(n.Addr)
+		values = append(values, resp.ArrayValue([]resp.Value{
+			resp.StringValue("slots"), resp.ArrayValue(slots),
+			resp.StringValue("nodes"), resp.ArrayValue([]resp.Value{resp.ArrayValue([]resp.Value{
+				resp.StringValue("id"), resp.StringValue(n.ID),
+				resp.StringValue("port"), resp.IntegerValue(port),
+				resp.StringValue("ip"), resp.StringValue(host),
+				resp.StringValue("endpoint"), resp.StringValue(host),
+				resp.StringValue("role"), resp.StringValue("master"),
+				resp.StringValue("replication-offset"), resp.IntegerValue(0),
+				resp.StringValue("health"), resp.StringValue("online"),
+			})}),
+		}))
+	}
+	return resp.ArrayValue(values)
+}
+
+// parseSlot parses a slot number.
+func parseSlot(arg string) (int, error) {
+	slot, err := strconv.Atoi(arg)
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return 0, fmt.Errorf("ERR Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+// splitNodeAddr returns the host and port of a node address.
+func splitNodeAddr(addr string) (string, int) {
+	host, port, _ := net.SplitHostPort(addr)
+	n, _ := strconv.Atoi(port)
+	return host, n
+}
+
+// nodeEndpoint describes a node the way CLUSTER SLOTS does: host, port and ID.
+func nodeEndpoint(n *clusterNode) resp.Value {
+	host, port := splitNodeAddr(n.Addr)
+	return resp.ArrayValue([]resp.Value{resp.StringValue(host), resp.IntegerValue(port), resp.StringValue(n.ID)})
+}
+
+// clusterNodes describes the nodes the way CLUSTER NODES does, one line per node; the caller holds cluster.mu.
+func clusterNodes() string {
+	ranges := slotRanges()
+	var b strings.Builder
+	for _, id := range sortedNodeIDs() {
+		n := cluster.nodes[id]
+		_, port := splitNodeAddr(n.Addr)
+		flags := "master"
+		if n == cluster.myself {
+			flags = "myself,master"
+		}
+		fmt.Fprintf(&b, "%s %s@%d %s - 0 0 0 connected", n.ID, n.Addr, port, flags)
+		for _, r := range ranges {
+			switch {
+			case r.Node != id:
+			case r.Start == r.End:
+				fmt.Fprintf(&b, " %d", r.Start)
+			default:
+				fmt.Fprintf(&b, " %d-%d", r.Start, r.End)
+			}
+		}
+		if n == cluster.myself {
+			for _, slot := range sortedSlots(cluster.migrating) {
+				fmt.Fprintf(&b, " [%d->-%s]", slot, cluster.migrating[slot].ID)
+			}
+			for _, slot := range sortedSlots(cluster.importing) {
+				fmt.Fprintf(&b, " [%d-<-%s]", slot, cluster.importing[slot].ID)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// sortedSlots returns the slots of a migrating or importing set in order.
+func sortedSlots(set map[int]*clusterNode) []int {
+	slots := make([]int, 0, len(set))
+	for slot := range set {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+// keysInSlot replies with the number of keys in a slot, or with up to limit of them if limit is not negative.
+func keysInSlot(s *session, slot, limit int) resp.Value {
+	errEnough := errors.New("enough keys")
+	count := 0
+	var keys []resp.Value
+	err := s.db.ForEach(func(key, value string) error {
+		if keySlot(key) != slot {
+			return nil
+		}
+		if limit >= 0 && len(keys) == limit {
+			return errEnough
+		}
+		count++
+		if limit >= 0 {
+			keys = append(keys, resp.StringValue(key))
+		}
+		return nil
+	})
+	if err != nil && err != errEnough {
+		return resp.ErrorValue(fmt.Errorf("ERR %v", err))
+	}
+	if limit < 0 {
+		return resp.IntegerValue(count)
+	}
+	return resp.ArrayValue(keys)
+}
+
+// setSlot executes CLUSTER SETSLOT: a slot is marked as MIGRATING to or IMPORTING from another node,
+// assigned to a NODE, which ends its migration, or made STABLE again. A node can be given by ID or,
+// to add a node to the cluster, by address.
+func setSlot(args []string) resp.Value {
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return resp.ErrorValue(err)
+	}
+	action := strings.ToUpper(args[1])
+
+	cluster.mu.Lock()
+	defer cluster.mu.Unlock()
+	var n *clusterNode
+	if action != "STABLE" {
+		if n = findNode(args[2]); n == nil {
+			return resp.ErrorValue(fmt.Errorf("ERR I don't know about node %s", args[2]))
+		}
+	}
+	switch action {
+	case "MIGRATING":
+		if cluster.slots[slot] != cluster.myself {
+			return resp.ErrorValue(fmt.Errorf("ERR I'm not the owner of hash slot %d", slot))
+		}
+		if n == cluster.myself {
+			return resp.ErrorValue(fmt.Errorf("ERR I can't migrate a slot to myself"))
+		}
+		cluster.migrating[slot] = n
+	case "IMPORTING":
+		if cluster.slots[slot] == cluster.myself {
+			return resp.ErrorValue(fmt.Errorf("ERR I'm already the owner of hash slot %d", slot))
+		}
+		if n == cluster.myself {
+			return resp.ErrorValue(fmt.Errorf("ERR I can't import a slot from myself"))
+		}
+		cluster.importing[slot] = n
+	case "NODE":
+		cluster.slots[slot] = n
+		delete(cluster.migrating, slot)
+		delete(cluster.importing, slot)
+	case "STABLE":
+		delete(cluster.migrating, slot)
+		delete(cluster.importing, slot)
+	}
+	if n != nil {
+		cluster.nodes[n.ID] = n
+	}
+	if err := saveCluster(); err != nil {
+		return resp.ErrorValue(fmt.Errorf("ERR saving the cluster configuration: %v", err))
+	}
+	return resp.SimpleStringValue("OK")
+}
+
+// findNode returns the node with an ID, or the node at an address, which is not known yet
+// if it is new to the cluster; the caller holds cluster.mu.
+func findNode(idOrAddr string) *clusterNode {
+	if n := cluster.nodes[idOrAddr]; n != nil {
+		return n
+	}
+	if _, _, err := net.SplitHostPort(idOrAddr); err != nil {
+		return nil
+	}
+	if n := cluster.nodes[nodeID(idOrAddr)]; n != nil {
+		return n
+	}
+	return &clusterNode{ID: nodeID(idOrAddr), Addr: idOrAddr}
+}
+
+// migrate executes a MIGRATE command: the keys are copied to the target node with SET and, unless COPY
+// is given, deleted here. In cluster mode every command is preceded by ASKING, since the target is still
+// importing the slot. REPLACE deletes the key on the target first; without it, an existing key is an error.
+func (s *session) migrate(c MIGRATEcommand) resp.Value {
+	if c.db != 0 {
+		return resp.ErrorValue(fmt.Errorf("ERR DB index is out of range"))
+	}
+	type pair struct{ key, value string }
+	var pairs []pair
+	for _, key := range c.keys {
+		value, ok, err := s.db.Get(key)
+		if err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR Error getting the value: %v", err))
+		}
+		if ok {
+			pairs = append(pairs, pair{key, value})
+		}
+	}
+	if len(pairs) == 0 {
+		return resp.SimpleStringValue("NOKEY")
+	}
+
+	timeout := c.timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	addr := net.JoinHostPort(c.host, c.port)
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return resp.ErrorValue(fmt.Errorf("IOERR error or timeout connecting to the client: %v", err))
+	}
+	defer conn.Close()
+	rd := resp.NewReader(bufio.NewReader(conn))
+	call := func(args ...string) (resp.Value, error) {
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(encodeCommand(args...)); err != nil {
+			return resp.Value{}, err
+		}
+		v, _, err := rd.ReadValue()
+		return v, err
+	}
+
+	var auth []string
+	switch {
+	case c.user != "":
+		auth = []string{CommandAUTH, c.user, c.password}
+	case c.password != "":
+		auth = []string{CommandAUTH, c.password}
+	}
+	cluster.mu.RLock()
+	asking := cluster.enabled
+	cluster.mu.RUnlock()
+	var commands [][]string
+	if auth != nil {
+		commands = append(commands, auth)
+	}
+	// ASKING only lets the next command through, so it precedes each of them
+	add := func(args ...string) {
+		if asking {
+			commands = append(commands, []string{CommandASKING})
+		}
+		commands = append(commands, args)
+	}
+	for _, p := range pairs {
+		if c.replace {
+			add(CommandDEL, p.key)
+		}
+		add(CommandSET, p.key, p.value)
+	}
+	for _, args := range commands {
+		v, err := call(args...)
+		if err != nil {
+			return resp.ErrorValue(fmt.Errorf("IOERR error or timeout reading to target instance: %v", err))
+		}
+		switch {
+		case v.Type() != resp.Error, args[0] == CommandDEL:
+		case args[0] == CommandSET && v.String() == "ERR Key already exists":
+			return resp.ErrorValue(fmt.Errorf("BUSYKEY Target key name already exists."))
+		default:
+			return resp.ErrorValue(fmt.Errorf("ERR Target instance replied with error: %s", v.String()))
+		}
+	}
+
+	if !c.copy {
+		for _, p := range pairs {
+			if reply := s.run(DELcommand{key: p.key}); reply.Type() == resp.Error {
+				return reply
+			}
+		}
+	}
+	return resp.SimpleStringValue("OK")
+}
+
+// clusterInfoLines reports the state of the cluster as CLUSTER INFO does; the caller holds cluster.mu.
+func clusterInfoLines() []string {
+	assigned := 0
+	owners := make(map[*clusterNode]struct{})
+	for _, n := range cluster.slots {
+		if n != nil {
+			assigned++
+			owners[n] = struct{}{}
+		}
+	}
+	state := "ok"
+	if assigned < clusterSlots {
+		state = "fail"
+	}
+	return []string{
+		"cluster_state:" + state,
+		fmt.Sprintf("cluster_slots_assigned:%d", assigned),
+		fmt.Sprintf("cluster_slots_ok:%d", assigned),
+		"cluster_slots_pfail:0",
+		"cluster_slots_fail:0",
+		fmt.Sprintf("cluster_known_nodes:%d", len(cluster.nodes)),
+		fmt.Sprintf("cluster_size:%d", len(owners)),
+	}
+}
+
+// clusterInfo reports the cluster section of INFO.
+func clusterInfo(db.Stats) []string {
+	cluster.mu.RLock()
+	defer cluster.mu.RUnlock()
+	if !cluster.enabled {
+		return []string{"cluster_enabled:0"}
+	}
+	return append([]string{"cluster_enabled:1"}, clusterInfoLines()...)
+}
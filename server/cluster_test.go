@@ -0,0 +1,268 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// startTestCluster puts the process in cluster mode as the node at myself, with the slots split over the nodes.
+func startTestCluster(t *testing.T, myself string, nodes ...string) {
+	t.Helper()
+	if err := configureCluster(myself, nodes, ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stopCluster)
+}
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"hello", 866},
+		{"123456789", 0x31c3 % clusterSlots},
+		{"{user1000}.following", keySlot("user1000")},
+		{"foo{{bar}}zap", keySlot("{bar")},
+		{"foo{bar}{zap}", keySlot("bar")},
+	}
+	for _, test := range tests {
+		if got := keySlot(test.key); got != test.slot {
+			t.Errorf("keySlot(%q) = %d, want %d", test.key, got, test.slot)
+		}
+	}
+	// An empty hashtag is not a tag, so the whole key is hashed
+	if keySlot("foo{}{bar}") == keySlot("bar") {
+		t.Error("expected an empty hashtag to be ignored")
+	}
+}
+
+func TestClusterRedirects(t *testing.T) {
+	addr := startTestServer(t)
+	other := "127.0.0.1:1"
+	startTestCluster(t, addr, addr, other)
+	c := dialTestClient(t, addr)
+
+	// Keys are split between the two nodes in address order
+	cluster.mu.RLock()
+	var mine, theirs string
+	for i := 0; mine == "" || theirs == ""; i++ {
+		key := fmt.Sprint("key", i)
+		if cluster.slots[keySlot(key)] == cluster.myself {
+			mine = key
+		} else {
+			theirs = key
+		}
+	}
+	cluster.mu.RUnlock()
+
+	if v := c.do(t, "SET", mine, "v"); v.String() != "OK" {
+		t.Errorf("expected the local key to be set, got %q", v.String())
+	}
+	if v := c.do(t, "GET", theirs).String(); v != fmt.Sprintf("MOVED %d %s", keySlot(theirs), other) {
+		t.Errorf("expected a redirection to the other node, got %q", v)
+	}
+	if v := c.do(t, "EVAL", "return 1", "2", mine, theirs).String(); v != "CROSSSLOT Keys in request don't hash to the same slot" {
+		t.Errorf("expected keys of different slots to be refused, got %q", v)
+	}
+	if v := c.do(t, "PING"); v.String() != "PONG" {
+		t.Errorf("expected commands without keys to be served, got %q", v.String())
+	}
+
+	// A redirected command aborts the transaction it is queued in
+	c.do(t, "MULTI")
+	c.do(t, "GET", theirs)
+	if v := c.do(t, "EXEC").String(); v != "EXECABORT Transaction discarded because of previous errors." {
+		t.Errorf("expected the transaction to be aborted, got %q", v)
+	}
+
+	// While the slot migrates, missing keys are asked of the target
+	slot := keySlot(mine)
+	if v := c.do(t, "CLUSTER", "SETSLOT", fmt.Sprint(slot), "MIGRATING", other); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	if v := c.do(t, "GET", mine); v.String() != "v" {
+		t.Errorf("expected the remaining key to be served, got %q", v.String())
+	}
+	if v := c.do(t, "GET", "{"+mine+"}.gone").String(); v != fmt.Sprintf("ASK %d %s", slot, other) {
+		t.Errorf("expected a missing key to be asked of the target, got %q", v)
+	}
+
+	// The importing node serves the slot to clients that ask, and only for the next command
+	slot = keySlot(theirs)
+	if v := c.do(t, "CLUSTER", "SETSLOT", fmt.Sprint(slot), "IMPORTING", other); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	c.do(t, "ASKING")
+	if v := c.do(t, "SET", theirs, "imported"); v.String() != "OK" {
+		t.Errorf("expected the key to be imported after ASKING, got %q", v.String())
+	}
+	if v := c.do(t, "GET", theirs).String(); v != fmt.Sprintf("MOVED %d %s", slot, other) {
+		t.Errorf("expected ASKING to apply to a single command, got %q", v)
+	}
+
+	// Once the slot is assigned here, it is served without ASKING
+	if v := c.do(t, "CLUSTER", "SETSLOT", fmt.Sprint(slot), "NODE", addr); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	if v := c.do(t, "GET", theirs); v.String() != "imported" {
+		t.Errorf("expected the imported key to be served, got %q", v.String())
+	}
+	if v := c.do(t, "CLUSTER", "COUNTKEYSINSLOT", fmt.Sprint(slot)); v.Integer() != 1 {
+		t.Errorf("expected a key in the slot, got %v", v)
+	}
+	if v := c.do(t, "CLUSTER", "GETKEYSINSLOT", fmt.Sprint(slot), "10").Array(); len(v) != 1 || v[0].String() != theirs {
+		t.Errorf("unexpected keys in the slot %v", v)
+	}
+}
+
+func TestClusterSlots(t *testing.T) {
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	if v := c.do(t, "CLUSTER", "KEYSLOT", "foo"); v.Integer() != 12182 {
+		t.Errorf("expected KEYSLOT without cluster mode, got %v", v)
+	}
+	if v := c.do(t, "CLUSTER", "SLOTS").String(); v != "ERR This instance has cluster support disabled" {
+		t.Errorf("unexpected reply %q", v)
+	}
+
+	other := "127.0.0.2:7000"
+	startTestCluster(t, addr, addr, other)
+	slots := c.do(t, "CLUSTER", "SLOTS").Array()
+	if len(slots) != 2 {
+		t.Fatalf("expected two slot ranges, got %v", slots)
+	}
+	first, second := slots[0].Array(), slots[1].Array()
+	if first[0].Integer() != 0 || first[1].Integer() != 8191 || first[2].Array()[2].String() != nodeID(addr) {
+		t.Errorf("unexpected first range %v", first)
+	}
+	if second[0].Integer() != 8192 || second[1].Integer() != 16383 ||
+		second[2].Array()[0].String() != "127.0.0.2" || second[2].Array()[1].Integer() != 7000 {
+		t.Errorf("unexpected second range %v", second)
+	}
+
+	shards := c.do(t, "CLUSTER", "SHARDS").Array()
+	if len(shards) != 2 {
+		t.Fatalf("expected two shards, got %v", shards)
+	}
+	for _, shard := range shards {
+		fields := shard.Array()
+		if fields[0].String() != "slots" || len(fields[1].Array()) != 2 || fields[2].String() != "nodes" {
+			t.Errorf("unexpected shard %v", fields)
+		}
+	}
+	if v := c.do(t, "CLUSTER", "MYID").String(); v != nodeID(addr) {
+		t.Errorf("unexpected ID %q", v)
+	}
+	if v := c.do(t, "CLUSTER", "INFO").String(); !containsAll(v, "cluster_state:ok", "cluster_known_nodes:2", "cluster_size:2") {
+		t.Errorf("unexpected CLUSTER INFO %q", v)
+	}
+	if v := c.do(t, "INFO", "cluster").String(); !containsAll(v, "cluster_enabled:1") {
+		t.Errorf("unexpected INFO cluster %q", v)
+	}
+	if v := c.do(t, "CLUSTER", "NODES").String(); !containsAll(v, nodeID(addr)+" "+addr, "myself,master", other+"@7000 master", "8192-16383") {
+		t.Errorf("unexpected CLUSTER NODES %q", v)
+	}
+}
+
+func TestClusterNodesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	cfg := Config{ClusterEnabled: true, ClusterAddr: "127.0.0.1:7001", ClusterNodes: []string{"127.0.0.1:7001", "127.0.0.1:7002"}}
+	if err := startCluster(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stopCluster)
+	if reply := setSlot([]string{"0", "NODE", "127.0.0.1:7003"}); reply.String() != "OK" {
+		t.Fatalf("unexpected reply %q", reply.String())
+	}
+	stopCluster()
+
+	// The slot table is read back instead of being split over the configured nodes again
+	if err := startCluster(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	cluster.mu.RLock()
+	owner, nodes := cluster.slots[0].Addr, len(cluster.nodes)
+	cluster.mu.RUnlock()
+	if owner != "127.0.0.1:7003" || nodes != 3 {
+		t.Errorf("expected slot 0 to stay with the added node, got %s and %d nodes", owner, nodes)
+	}
+
+	b, err := os.ReadFile(path + ".nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state clusterState
+	if err := json.Unmarshal(b, &state); err != nil || len(state.Slots) != 3 {
+		t.Errorf("unexpected nodes file %s, %v", b, err)
+	}
+	stopCluster()
+	cfg.ClusterAddr = "127.0.0.1:7002"
+	if err := startCluster(path, cfg); err == nil {
+		t.Error("expected the nodes file of another node to be refused")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	source, target := startTestServer(t), startTestServer(t)
+	c, d := dialTestClient(t, source), dialTestClient(t, target)
+	host, port := splitNodeAddr(target)
+	c.do(t, "SET", "a", "1")
+	c.do(t, "SET", "b", "2")
+	c.do(t, "SET", "c", "3")
+
+	if v := c.do(t, "MIGRATE", host, fmt.Sprint(port), "a", "0", "1000"); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	if v := c.do(t, "GET", "a"); !v.IsNull() {
+		t.Errorf("expected the key to be moved, got %q", v.String())
+	}
+	if v := d.do(t, "GET", "a"); v.String() != "1" {
+		t.Errorf("expected the key on the target, got %q", v.String())
+	}
+
+	// COPY keeps the keys, and an existing key needs REPLACE
+	if v := c.do(t, "MIGRATE", host, fmt.Sprint(port), "", "0", "1000", "COPY", "KEYS", "b", "c"); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	if v := c.do(t, "GET", "b"); v.String() != "2" {
+		t.Errorf("expected COPY to keep the key, got %q", v.String())
+	}
+	if v := c.do(t, "MIGRATE", host, fmt.Sprint(port), "b", "0", "1000"); v.String() != "BUSYKEY Target key name already exists." {
+		t.Errorf("expected an existing key to be refused, got %q", v.String())
+	}
+	if v := c.do(t, "MIGRATE", host, fmt.Sprint(port), "", "0", "1000", "REPLACE", "KEYS", "b", "missing"); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	if v := c.do(t, "MIGRATE", host, fmt.Sprint(port), "missing", "0", "1000"); v.String() != "NOKEY" {
+		t.Errorf("expected NOKEY, got %q", v.String())
+	}
+	if v := c.do(t, "MIGRATE", host, fmt.Sprint(port), "c", "1", "1000"); v.String() != "ERR DB index is out of range" {
+		t.Errorf("unexpected reply %q", v.String())
+	}
+
+	// In cluster mode the target only serves the importing slot after ASKING, which covers a single
+	// command, so REPLACE must ask again before the SET that follows its DEL
+	c.do(t, "SET", "d", "new")
+	d.do(t, "SET", "d", "old")
+	other := "127.0.0.1:1"
+	startTestCluster(t, source, source, other)
+	slot := keySlot("d")
+	if v := c.do(t, "CLUSTER", "SETSLOT", fmt.Sprint(slot), "NODE", other); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	if v := c.do(t, "CLUSTER", "SETSLOT", fmt.Sprint(slot), "IMPORTING", other); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	if v := c.do(t, "MIGRATE", host, fmt.Sprint(port), "", "0", "1000", "REPLACE", "KEYS", "d"); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	d.do(t, "ASKING")
+	if v := d.do(t, "GET", "d"); v.String() != "new" {
+		t.Errorf("expected REPLACE to overwrite the key on the target, got %q", v.String())
+	}
+}
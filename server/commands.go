@@ -0,0 +1,185 @@
+package server
+
+import (
+	db "database/database"
+	"fmt"
+
+	"github.com/tidwall/resp"
+)
+
+// store is the set of database operations that data and server commands run against.
+// Both *db.DB and *db.Tx implement it, so the same code serves single commands and EXEC.
+type store interface {
+	Get(key string) (string, bool, error)
+	Put(key string, value string) error
+	Del(key string) error
+	Stats() (db.Stats, error)
+	Counters() db.Counters
+}
+
+// run executes a single command outside of a transaction and returns its reply.
+// Commands that modify keys run under the database's write lock, so that invalidating
+// the transactions watching those keys is atomic with the change itself.
+// In a Raft group they go through the log instead, and run once it is committed.
+func (s *session) run(cmd command) resp.Value {
+	if !isWriteCommand(cmd) {
+		return s.execute(s.db, cmd)
+	}
+	if node := consensusNode(); node != nil {
+		replies, err := s.propose(node, []command{cmd})
+		if err != nil {
+			return resp.ErrorValue(err)
+		}
+		return replies[0]
+	}
+	var reply resp.Value
+	s.db.Update(func(tx *db.Tx) error {
+		reply = s.execute(tx, cmd)
+		return nil
+	})
+	return reply
+}
+
+// isWriteCommand reports whether a command may modify keys.
+// Scripts count as writes, since they run atomically under the write lock.
+func isWriteCommand(cmd command) bool {
+	switch cmd.(type) {
+	case SETcommand, DELcommand, EVALcommand, EVALSHAcommand:
+		return true
+	}
+	return false
+}
+
+// execute runs a data or server command against st and returns its reply.
+// A follower refuses the commands that modify keys, since its keys follow the leader.
+func (s *session) execute(st store, cmd command) resp.Value {
+	if err := readOnlyError(cmd); err != nil {
+		return resp.ErrorValue(err)
+	}
+	switch c := cmd.(type) {
+	case SETcommand:
+		// Handle SET command: Store key-value pair in database
+		_, exists, _ := st.Get(c.key)
+		if exists {
+			return resp.ErrorValue(fmt.Errorf("ERR Key already exists"))
+		}
+		if err := st.Put(c.key, c.val); err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR Error setting the value: %v", err))
+		}
+		return resp.SimpleStringValue("OK")
+
+	case GETcommand:
+		// Handle GET command: Retrieve value for the given key
+		value, found, err := st.Get(c.key)
+		if err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR Error getting the value: %v", err))
+		}
+		if found {
+			serverStats.keyspaceHits.Add(1)
+		} else {
+			serverStats.keyspaceMisses.Add(1)
+		}
+		if value == "" {
+			return resp.NullValue()
+		}
+		return resp.StringValue(value)
+
+	case DELcommand:
+		// Handle DEL command: Delete the given key from the database
+		if err := st.Del(c.key); err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR Error deleting the value: %v", err))
+		}
+		return resp.SimpleStringValue("OK")
+
+	case PINGcommand:
+		// Handle PING command: In subscription mode the reply is a pong message
+		if s.subscriptions() > 0 {
+			return resp.ArrayValue([]resp.Value{resp.StringValue("pong"), resp.StringValue(c.message)})
+		}
+		if c.message != "" {
+			return resp.StringValue(c.message)
+		}
+		return resp.SimpleStringValue("PONG")
+
+	case PUBLISHcommand:
+		// Handle PUBLISH command: Reply with the number of clients that received the message
+		return resp.IntegerValue(pubsub.publish(c.channel, c.message))
+
+	case CONFIGcommand:
+		// Handle CONFIG command: GET replies with name, value pairs, SET applies every pair
+		return configCommand(c)
+
+	case EVALcommand:
+		// Handle EVAL command: Run the script against the same store
+		return s.eval(st, c.script, c.keys, c.args)
+
+	case EVALSHAcommand:
+		// Handle EVALSHA command: Run a cached script against the same store
+		return s.evalSHA(st, c)
+
+	case SCRIPTcommand:
+		// Handle SCRIPT command: Manage the script cache
+		return scriptCommand(c)
+
+	case AUTHcommand:
+		// Handle AUTH command: Switch the connection to another user
+		return s.authCommand(c)
+
+	case ACLcommand:
+		// Handle ACL command: Manage users
+		return s.aclCommand(c)
+
+	case CLIENTcommand:
+		// Handle CLIENT command: Inspect and manage the connections
+		return s.clientCommand(c)
+
+	case INFOcommand:
+		// Handle INFO command: Report the requested sections
+		return infoCommand(st, c)
+
+	case SLOWLOGcommand:
+		// Handle SLOWLOG command: Read or reset the log of slow commands
+		return slowlogCommand(c)
+
+	case LATENCYcommand:
+		// Handle LATENCY command: Read or reset the latency of internal events
+		return latencyCommand(c)
+
+	case MONITORcommand:
+		// Handle MONITOR command: Stream every following command to the client
+		return s.monitor()
+
+	case ROLEcommand:
+		// Handle ROLE command: Report whether the server is a leader or a follower
+		return roleCommand()
+
+	case RAFTcommand:
+		// Handle RAFT command: Answer an RPC of another member of the Raft group
+		return raftCommand(c)
+
+	case LASTSAVEcommand:
+		// Handle LASTSAVE command: Report when the last backup was written
+		return lastsaveCommand()
+	}
+
+	// Default response for unknown commands, :: This is done inorder to pass the redis-benchmarks
+	return resp.SimpleStringValue("OK")
+}
+
+// configCommand executes a CONFIG GET or CONFIG SET command and returns the reply.
+func configCommand(c CONFIGcommand) resp.Value {
+	if c.subcommand == "GET" {
+		pairs := configGet(c.args[0])
+		values := make([]resp.Value, len(pairs))
+		for i, s := range pairs {
+			values[i] = resp.StringValue(s)
+		}
+		return resp.ArrayValue(values)
+	}
+	for i := 0; i < len(c.args); i += 2 {
+		if err := configSet(c.args[i], c.args[i+1]); err != nil {
+			return resp.ErrorValue(err)
+		}
+	}
+	return resp.SimpleStringValue("OK")
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// compactCommand executes a COMPACT command: the database file is rebuilt densely and swapped in,
+// and the reply is the number of bytes reclaimed. Writes wait for it, while reads keep being served.
+// The rebuild is abandoned if the client goes away first.
+func (s *session) compactCommand() resp.Value {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	start := time.Now()
+	reclaimed, err := s.db.Compact(ctx)
+	if err != nil {
+		s.log.Warn("compacting the database failed", "err", err)
+		return resp.ErrorValue(fmt.Errorf("ERR %v", err))
+	}
+	s.log.Info("database compacted", "reclaimed", reclaimed, "duration", time.Since(start))
+	return resp.IntegerValue(int(reclaimed))
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+func TestCompactCommand(t *testing.T) {
+	c := dialTestClient(t, startTestServer(t))
+	for i := 0; i < 500; i++ {
+		c.do(t, "SET", fmt.Sprintf("key%03d", i), fmt.Sprint(i))
+	}
+	if v := c.do(t, "COMPACT"); v.Type() != resp.Integer || v.Integer() <= 0 {
+		t.Errorf("expected bytes to be reclaimed, got %v", v)
+	}
+	if v := c.do(t, "GET", "key499"); v.String() != "499" {
+		t.Errorf("expected the key after compaction, got %q", v.String())
+	}
+	if v := c.do(t, "SET", "key500", "500"); v.String() != "OK" {
+		t.Errorf("expected writes after compaction, got %q", v.String())
+	}
+
+	c.do(t, "MULTI")
+	if v := c.do(t, "COMPACT"); v.Type() != resp.Error {
+		t.Errorf("expected COMPACT to be refused in a transaction, got %q", v.String())
+	}
+	c.do(t, "DISCARD")
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config holds the settings the server is started with.
+type Config struct {
+	Port           int    // Plain TCP port; 0 disables the plain listener
+	TLSPort        int    // TLS port; 0 disables the TLS listener
+	TLSCertFile    string // PEM file with the server certificate
+	TLSKeyFile     string // PEM file with the private key of the server certificate
+	TLSCAFile      string // PEM file with the CA certificates that sign client certificates
+	TLSAuthClients bool   // Whether clients must present a certificate signed by the CA
+	UnixSocket     string // Path of a Unix domain socket to listen on; empty disables it
+	UnixSocketPerm uint32 // Permissions of the Unix domain socket file, such as 0700; 0 keeps the umask default
+	MaxClients     int    // Maximum number of connected clients; 0 keeps the default of 10000
+	Timeout        int    // Seconds a client may stay idle before it is closed; 0 keeps idle clients
+	TCPKeepAlive   int    // Seconds between TCP keepalive probes; 0 keeps the default of 300, negative disables them
+	MetricsAddr    string // Address of the HTTP listener serving Prometheus metrics on /metrics; empty disables it
+	DBPath         string // Path of the database file; empty keeps the default of ../data/db
+	ReplicaOf      string // Address of a leader to follow as "host:port"; empty starts the server as a leader
+	RaftAddr       string // Address the other members of the Raft group reach this server at; empty uses 127.0.0.1 and Port
+	BackupDir      string // Directory SAVE and BGSAVE write backups to; empty uses the directory of the database file
+
+	// CompactFillFactor is the share of a full B-tree node that COMPACT fills the rebuilt nodes with,
+	// from 0.5 to 1; 0 keeps the default of 0.9, which leaves room for insertions before nodes split again.
+	CompactFillFactor float64
+
+	// AppendOnly makes every change be appended to the append-only file next to the database file, which is
+	// replayed on startup, replacing the contents of the database; without the file, it is written from them.
+	AppendOnly bool
+
+	// AppendFsync is when the append-only file is flushed to disk: always, everysec or no; empty keeps everysec.
+	AppendFsync string
+
+	// RaftPeers are the addresses of every member of the Raft group, this server included, as "host:port".
+	// With 3 or 5 members, writes are committed by a majority and a new leader is elected when the leader fails.
+	// Empty runs the server on its own.
+	RaftPeers []string
+
+	ClusterEnabled bool   // Whether keys are partitioned into hash slots served by the nodes of a cluster
+	ClusterAddr    string // Address clients of the cluster reach this server at; empty uses 127.0.0.1 and Port
+
+	// ClusterNodes are the addresses of every node of the cluster, this server included, as "host:port".
+	// The 16384 hash slots are split evenly over them on the first start; after that the slot table is read
+	// back from the nodes file next to the database, which keeps the changes made with CLUSTER SETSLOT.
+	// Empty serves every slot from this server.
+	ClusterNodes []string
+
+	// Logger receives the log records of the server and its database; nil uses slog.Default.
+	// NewLogger creates one with a given format and level.
+	Logger *slog.Logger
+
+	// Listeners are additional listeners supplied by an embedding program, served like the built-in ones
+	// and closed on shutdown.
+	Listeners []net.Listener
+}
+
+// configParam is a runtime parameter that can be read with CONFIG GET and changed with CONFIG SET.
+type configParam struct {
+	value   string                   // The current value as reported by CONFIG GET.
+	apply   func(value string) error // Validates and applies a new value; nil for read-only parameters.
+	current func() string            // Reports the current value instead of value, for partially settable parameters.
+}
+
+// runtimeConfig holds the parameters that can be changed while the server is running.
+var runtimeConfig = struct {
+	mu     sync.RWMutex            // Mutex to protect the parameters.
+	params map[string]*configParam // Parameters by lower-case name.
+}{
+	params: map[string]*configParam{
+		"notify-keyspace-events":     {value: "", apply: setKeyspaceEvents},
+		"requirepass":                {value: "", apply: setRequirePass},
+		"maxclients":                 {value: strconv.Itoa(defaultMaxClients), apply: setMaxClients},
+		"timeout":                    {value: "0", apply: setTimeout},
+		"tcp-keepalive":              {value: strconv.Itoa(int(defaultTCPKeepAlive / time.Second)), apply: setTCPKeepAlive},
+		"client-output-buffer-limit": {apply: setOutputBufferLimit, current: outputBufferLimitValue},
+		"slowlog-log-slower-than":    {value: "10000", apply: setSlowlogThreshold},
+		"slowlog-max-len":            {value: "128", apply: setSlowlogMaxLen},
+		"latency-monitor-threshold":  {value: "0", apply: setLatencyThreshold},
+		"masterauth":                 {value: "", apply: setMasterAuth},
+		"dir":                        {value: "", apply: setBackupDir},
+		"dbfilename":                 {value: "dump.db", apply: setBackupFilename},
+		"appendonly":                 {apply: setAppendOnly, current: appendOnlyValue},
+		"appendfsync":                {value: aofFsyncEverysec, apply: setAppendFsync},
+		"appendfilename":             {current: appendFilenameValue},
+	},
+}
+
+// configGet returns the name and value of every parameter matching the glob-style pattern,
+// flattened into name, value pairs and sorted by name.
+func configGet(pattern string) []string {
+	runtimeConfig.mu.RLock()
+	defer runtimeConfig.mu.RUnlock()
+
+	pattern = strings.ToLower(pattern)
+	names := make([]string, 0, len(runtimeConfig.params))
+	for name := range runtimeConfig.params {
+		if matchPattern(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	result := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		param := runtimeConfig.params[name]
+		value := param.value
+		if param.current != nil {
+			value = param.current()
+		}
+		result = append(result, name, value)
+	}
+	return result
+}
+
+// configSet validates and applies a new value for the named parameter.
+func configSet(name, value string) error {
+	runtimeConfig.mu.Lock()
+	defer runtimeConfig.mu.Unlock()
+
+	param, ok := runtimeConfig.params[strings.ToLower(name)]
+	if !ok || param.apply == nil {
+		return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name)
+	}
+	if err := param.apply(value); err != nil {
+		return err
+	}
+	param.value = value
+	return nil
+}
+
+// applyLimits sets the connection limits given in the configuration, keeping the defaults for zero values.
+func (cfg Config) applyLimits() error {
+	var settings [][2]string
+	if cfg.MaxClients != 0 {
+		settings = append(settings, [2]string{"maxclients", strconv.Itoa(cfg.MaxClients)})
+	}
+	if cfg.Timeout != 0 {
+		settings = append(settings, [2]string{"timeout", strconv.Itoa(cfg.Timeout)})
+	}
+	if cfg.TCPKeepAlive < 0 {
+		settings = append(settings, [2]string{"tcp-keepalive", "0"})
+	} else if cfg.TCPKeepAlive > 0 {
+		settings = append(settings, [2]string{"tcp-keepalive", strconv.Itoa(cfg.TCPKeepAlive)})
+	}
+	for _, setting := range settings {
+		if err := configSet(setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	db "database/database"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/resp"
+)
+
+const raftSuffix = ".raft" // Suffix of the Raft files of the member, next to the database file
+
+// consensus holds the Raft member of the server when it is started with RaftPeers.
+// Writes then go through the Raft log of the group instead of straight to the database.
+var consensus = struct {
+	mu   sync.Mutex // Mutex to protect node.
+	node *raftNode  // The member; nil when the server runs on its own.
+}{}
+
+// consensusNode returns the Raft member of the server, or nil if consensus is disabled.
+func consensusNode() *raftNode {
+	consensus.mu.Lock()
+	defer consensus.mu.Unlock()
+	return consensus.node
+}
+
+// startConsensus joins the Raft group described by cfg, keeping the log next to the database file,
+// and runs the member until the context is canceled.
+func startConsensus(ctx context.Context, database *db.DB, path string, cfg Config) error {
+	if cfg.ReplicaOf != "" {
+		return fmt.Errorf("a server can not both follow a leader and be part of a Raft group")
+	}
+	id := cfg.RaftAddr
+	if id == "" {
+		id = "127.0.0.1:" + strconv.Itoa(cfg.Port)
+	}
+	if !slices.Contains(cfg.RaftPeers, id) {
+		return fmt.Errorf("the Raft address %s is not one of the members %v", id, cfg.RaftPeers)
+	}
+	node, err := newRaftNode(id, cfg.RaftPeers, newTCPTransport(), database, applyRaftEntry, path+raftSuffix)
+	if err != nil {
+		return err
+	}
+	consensus.mu.Lock()
+	consensus.node = node
+	consensus.mu.Unlock()
+	logger().Info("joined Raft group", "addr", id, "members", strings.Join(cfg.RaftPeers, ","))
+	go node.run(ctx)
+	return nil
+}
+
+// stopConsensus forgets the Raft member once the server shuts down.
+func stopConsensus() {
+	consensus.mu.Lock()
+	defer consensus.mu.Unlock()
+	consensus.node = nil
+}
+
+// raftArgs returns the arguments a command is proposed to the Raft log with. EVALSHA is proposed as EVAL,
+// since the script cache of the other members may not hold the script.
+// Only data commands and scripts can be proposed; the others depend on the connection or the member.
+func raftArgs(cmd command) ([]string, error) {
+	switch c := cmd.(type) {
+	case SETcommand:
+		return []string{CommandSET, c.key, c.val}, nil
+	case GETcommand:
+		return []string{CommandGET, c.key}, nil
+	case DELcommand:
+		return []string{CommandDEL, c.key}, nil
+	case PINGcommand:
+		if c.message == "" {
+			return []string{CommandPING}, nil
+		}
+		return []string{CommandPING, c.message}, nil
+	case EVALcommand:
+		return evalCommandArgs(c.script, c.keys, c.args), nil
+	case EVALSHAcommand:
+		scripts.mu.RLock()
+		body, ok := scripts.bodies[strings.ToLower(c.sha)]
+		scripts.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		return evalCommandArgs(body, c.keys, c.args), nil
+	}
+	return nil, fmt.Errorf("ERR Command not allowed in a transaction of a Raft group")
+}
+
+// evalCommandArgs returns the arguments of an EVAL command.
+func evalCommandArgs(script string, keys, args []string) []string {
+	result := []string{CommandEVAL, script, strconv.Itoa(len(keys))}
+	result = append(result, keys...)
+	return append(result, args...)
+}
+
+// propose proposes write commands to the Raft log and returns their replies once the group committed
+// and applied them. A follower redirects the client to the leader with -MOVED, reporting the slot
+// of the first key, or answers -READONLY while no leader is elected.
+func (s *session) propose(node *raftNode, cmds []command) ([]resp.Value, error) {
+	commands := make([][]string, len(cmds))
+	for i, cmd := range cmds {
+		args, err := raftArgs(cmd)
+		if err != nil {
+			return nil, err
+		}
+		commands[i] = args
+	}
+	replies, err := node.propose(s.user, commands)
+	var notLeader *notLeaderError
+	switch {
+	case errors.As(err, &notLeader) && notLeader.leader != "":
+		slot := 0
+		if keys := commandKeys(cmds[0]); len(keys) > 0 {
+			slot = keySlot(keys[0])
+		}
+		return nil, fmt.Errorf("MOVED %d %s", slot, notLeader.leader)
+	case errors.As(err, &notLeader):
+		return nil, fmt.Errorf("READONLY You can't write against a read only replica.")
+	case err != nil:
+		return nil, fmt.Errorf("ERR %v", err)
+	}
+	return replies, nil
+}
+
+// applyRaftEntry applies the commands of a committed entry on behalf of the user that proposed them.
+// It runs on every member, so the commands go through a session without a connection.
+func applyRaftEntry(tx *db.Tx, e raftEntry) []resp.Value {
+	s := &session{user: e.User, log: logger()}
+	replies := make([]resp.Value, len(e.Commands))
+	for i, args := range e.Commands {
+		values := make([]resp.Value, len(args))
+		for j, arg := range args {
+			values[j] = resp.StringValue(arg)
+		}
+		cmd, err := parseArray(values)
+		if err != nil {
+			replies[i] = resp.ErrorValue(fmt.Errorf("ERR %v", err))
+			continue
+		}
+		replies[i] = s.execute(tx, cmd)
+	}
+	return replies
+}
+
+// info reports the Raft section of INFO for the member.
+func (n *raftNode) info() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return []string{
+		"raft_enabled:1",
+		"raft_addr:" + n.id,
+		"raft_role:" + n.role.String(),
+		fmt.Sprintf("raft_term:%d", n.term),
+		"raft_leader:" + n.leader,
+		fmt.Sprintf("raft_members:%d", len(n.peers)+1),
+		fmt.Sprintf("raft_commit_index:%d", n.commitIndex),
+		fmt.Sprintf("raft_last_applied:%d", n.lastApplied),
+		fmt.Sprintf("raft_snapshot_index:%d", n.snapshotIndex),
+		fmt.Sprintf("raft_log_entries:%d", len(n.entries)),
+	}
+}
+
+// raftInfo reports the Raft section: the role of the server in its group and the progress of the log.
+func raftInfo(db.Stats) []string {
+	node := consensusNode()
+	if node == nil {
+		return []string{"raft_enabled:0"}
+	}
+	return node.info()
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// ErrNoListeners is returned when the configuration enables neither a port nor a Unix socket.
+var ErrNoListeners = errors.New("no port, TLS port or Unix socket configured")
+
+// ListenError reports a listener that could not be opened.
+type ListenError struct {
+	Network string // Network of the listener: "tcp", "tls" or "unix".
+	Addr    string // Address or socket path the listener was opened on.
+	Err     error  // The underlying error.
+}
+
+// Error implements the error interface.
+func (e *ListenError) Error() string {
+	return fmt.Sprintf("listening on %s %s: %v", e.Network, e.Addr, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ListenError) Unwrap() error {
+	return e.Err
+}
+
+// AcceptError reports a listener that failed to accept a connection, which stops the server.
+type AcceptError struct {
+	Addr string // Address of the listener.
+	Err  error  // The underlying error.
+}
+
+// Error implements the error interface.
+func (e *AcceptError) Error() string {
+	return fmt.Sprintf("accepting connections on %s: %v", e.Addr, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *AcceptError) Unwrap() error {
+	return e.Err
+}
+
+// ProtocolError reports a request that is not valid RESP. The client that sent it gets an error reply
+// and is disconnected, as the rest of its input cannot be parsed reliably.
+type ProtocolError struct {
+	Err error // The underlying error from the RESP reader.
+}
+
+// Error implements the error interface, in the form Redis uses for its error reply.
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + strings.TrimPrefix(e.Err.Error(), "Protocol error: ")
+}
+
+// Unwrap returns the underlying error.
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// isProtocolError reports whether an error returned by the RESP reader is caused by malformed input
+// rather than by the connection.
+func isProtocolError(err error) bool {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMalformedRequest(t *testing.T) {
+	addr := startTestServer(t)
+	bad := dialTestClient(t, addr)
+	if _, err := bad.conn.Write([]byte("*1\r\n$x\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	bad.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	v, _, err := bad.rd.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(v.Error().Error(), "ERR Protocol error: ") {
+		t.Errorf("unexpected reply %q", v.String())
+	}
+	if _, _, err := bad.rd.ReadValue(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected the client to be disconnected, got %v", err)
+	}
+
+	// The server keeps serving the other clients.
+	if v := dialTestClient(t, addr).do(t, "PING"); v.String() != "PONG" {
+		t.Errorf("unexpected reply %q", v.String())
+	}
+}
+
+func TestParseCommandMalformed(t *testing.T) {
+	_, err := parseCommand("*1\r\n$x\r\n")
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected a ProtocolError, got %v", err)
+	}
+}
+
+func TestClientListenError(t *testing.T) {
+	l, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The port is taken, so Client must return instead of exiting the process.
+	err = Client(context.Background(), Config{Port: l.Addr().(*net.TCPAddr).Port})
+	var listenErr *ListenError
+	if !errors.As(err, &listenErr) || listenErr.Network != "tcp" {
+		t.Fatalf("expected a ListenError, got %v", err)
+	}
+	if err := Client(context.Background(), Config{}); !errors.Is(err, ErrNoListeners) {
+		t.Errorf("expected ErrNoListeners, got %v", err)
+	}
+}
+
+func TestServeAcceptError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close() // Closed without canceling the context, so accepting fails
+	err = serve(context.Background(), l, openTestDB(t))
+	var acceptErr *AcceptError
+	if !errors.As(err, &acceptErr) || !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected an AcceptError, got %v", err)
+	}
+}
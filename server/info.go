@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	db "database/database"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	opsSampleInterval = 100 * time.Millisecond // How often the command counter is sampled for instantaneous_ops_per_sec
+	opsSamples        = 16                     // Number of samples instantaneous_ops_per_sec is averaged over
+)
+
+// serverStats holds the counters reported by INFO.
+var serverStats = struct {
+	started        time.Time    // When the server started.
+	connections    atomic.Int64 // Number of connections accepted.
+	rejected       atomic.Int64 // Number of connections refused because of maxclients.
+	commands       atomic.Int64 // Number of commands processed.
+	keyspaceHits   atomic.Int64 // Number of lookups that found the key.
+	keyspaceMisses atomic.Int64 // Number of lookups that did not find the key.
+
+	opsMu      sync.Mutex          // Mutex to protect the samples below.
+	opsSamples [opsSamples]float64 // Commands per second measured over the last sample intervals.
+	opsNext    int                 // Index of the next sample to overwrite.
+}{
+	started: time.Now(),
+}
+
+// sampleOps records the commands processed per second at every sample interval until the context is canceled.
+func sampleOps(ctx context.Context) {
+	ticker := time.NewTicker(opsSampleInterval)
+	defer ticker.Stop()
+	last, lastTime := serverStats.commands.Load(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			commands := serverStats.commands.Load()
+			rate := float64(commands-last) / now.Sub(lastTime).Seconds()
+			last, lastTime = commands, now
+
+			serverStats.opsMu.Lock()
+			serverStats.opsSamples[serverStats.opsNext] = rate
+			serverStats.opsNext = (serverStats.opsNext + 1) % opsSamples
+			serverStats.opsMu.Unlock()
+		}
+	}
+}
+
+// instantaneousOps returns the commands processed per second, averaged over the recent samples.
+func instantaneousOps() int {
+	serverStats.opsMu.Lock()
+	defer serverStats.opsMu.Unlock()
+	sum := 0.0
+	for _, rate := range serverStats.opsSamples {
+		sum += rate
+	}
+	return int(sum / opsSamples)
+}
+
+// infoSection is a section of the INFO reply.
+type infoSection struct {
+	name   string                        // Lower-case name used to select the section.
+	fields func(stats db.Stats) []string // Produces the name:value lines of the section.
+	shape  bool                          // Whether the section reports the shape of the B-tree, which takes walking it.
+}
+
+// infoSections lists the sections in the order INFO reports them.
+var infoSections = []infoSection{
+	{"server", serverInfo, false},
+	{"clients", clientsInfo, false},
+	{"memory", memoryInfo, false},
+	{"persistence", persistenceInfo, true},
+	{"stats", statsInfo, false},
+	{"replication", replicationInfo, false},
+	{"raft", raftInfo, false},
+	{"cluster", clusterInfo, false},
+	{"keyspace", keyspaceInfo, false},
+}
+
+// infoCommand executes an INFO command and returns the requested sections in the Redis format.
+// Without arguments, and for "default", "all" or "everything", every section is reported.
+func infoCommand(st store, c INFOcommand) resp.Value {
+	selected := make(map[string]bool)
+	for _, name := range c.sections {
+		selected[strings.ToLower(name)] = true
+	}
+	all := len(selected) == 0 || selected["default"] || selected["all"] || selected["everything"]
+	var sections []infoSection
+	for _, section := range infoSections {
+		if all || selected[section.name] {
+			sections = append(sections, section)
+		}
+	}
+	// The tree is only walked for the sections that report its shape; the others read the counters
+	stats := db.Stats{Counters: st.Counters()}
+	if slices.ContainsFunc(sections, func(section infoSection) bool { return section.shape }) {
+		var err error
+		if stats, err = st.Stats(); err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR %v", err))
+		}
+	}
+
+	var b strings.Builder
+	for _, section := range sections {
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, field := range section.fields(stats) {
+			b.WriteString(field + "\r\n")
+		}
+	}
+	return resp.StringValue(b.String())
+}
+
+// serverInfo reports the Server section: versions and uptime.
+func serverInfo(db.Stats) []string {
+	uptime := time.Since(serverStats.started)
+	return []string{
+		"redis_version:7.0.0", // The Redis version whose protocol and INFO format the server follows
+		"redis_mode:standalone",
+		"os:" + runtime.GOOS,
+		"arch_bits:" + fmt.Sprint(32<<(^uint(0)>>63)),
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(uptime.Seconds())),
+		fmt.Sprintf("uptime_in_days:%d", int64(uptime.Hours()/24)),
+	}
+}
+
+// clientsInfo reports the Clients section.
+func clientsInfo(db.Stats) []string {
+	limits.mu.RLock()
+	maxClients := limits.maxClients
+	limits.mu.RUnlock()
+	return []string{
+		fmt.Sprintf("connected_clients:%d", connectedClients.Load()),
+		fmt.Sprintf("maxclients:%d", maxClients),
+		"blocked_clients:0",
+	}
+}
+
+// memoryInfo reports the Memory section: Go heap usage.
+func memoryInfo(db.Stats) []string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return []string{
+		fmt.Sprintf("used_memory:%d", mem.HeapAlloc),
+		"used_memory_human:" + humanBytes(int64(mem.HeapAlloc)),
+		fmt.Sprintf("used_memory_rss:%d", mem.Sys),
+	}
+}
+
+// persistenceInfo reports the Persistence section: the shape of the B-tree, the database file and its backups.
+func persistenceInfo(stats db.Stats) []string {
+	return append([]string{
+		"loading:0",
+		fmt.Sprintf("btree_depth:%d", stats.Depth),
+		fmt.Sprintf("btree_nodes:%d", stats.Nodes),
+		fmt.Sprintf("db_pages:%d", stats.Pages),
+		fmt.Sprintf("db_file_size:%d", stats.FileSize),
+		fmt.Sprintf("block_reads:%d", stats.BlockReads),
+		fmt.Sprintf("block_writes:%d", stats.BlockWrites),
+	}, append(saveInfo(), aofInfo()...)...)
+}
+
+// statsInfo reports the Stats section: connection, command and keyspace counters.
+func statsInfo(db.Stats) []string {
+	pubsub.mu.RLock()
+	channels, patterns := len(pubsub.channels), len(pubsub.patterns)
+	pubsub.mu.RUnlock()
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", serverStats.connections.Load()),
+		fmt.Sprintf("total_commands_processed:%d", serverStats.commands.Load()),
+		fmt.Sprintf("instantaneous_ops_per_sec:%d", instantaneousOps()),
+		fmt.Sprintf("rejected_connections:%d", serverStats.rejected.Load()),
+		fmt.Sprintf("keyspace_hits:%d", serverStats.keyspaceHits.Load()),
+		fmt.Sprintf("keyspace_misses:%d", serverStats.keyspaceMisses.Load()),
+		fmt.Sprintf("pubsub_channels:%d", channels),
+		fmt.Sprintf("pubsub_patterns:%d", patterns),
+	}
+}
+
+// keyspaceInfo reports the Keyspace section, which like in Redis lists only databases that hold keys.
+func keyspaceInfo(stats db.Stats) []string {
+	if stats.Keys == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("db0:keys=%d,expires=0,avg_ttl=0", stats.Keys)}
+}
+
+// humanBytes formats a byte count the way Redis does in the *_human fields, such as 1.50M.
+func humanBytes(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", value, units[unit])
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// infoFields parses an INFO reply into its name:value fields.
+func infoFields(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\r\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok && !strings.HasPrefix(line, "#") {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+func TestInfo(t *testing.T) {
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	c.do(t, "SET", "info-key", "value")
+	c.do(t, "GET", "info-key")
+	c.do(t, "GET", "info-missing")
+
+	info := c.do(t, "INFO").String()
+	for _, header := range []string{"# Server\r\n", "# Clients\r\n", "# Memory\r\n", "# Persistence\r\n", "# Stats\r\n", "# Keyspace\r\n"} {
+		if !strings.Contains(info, header) {
+			t.Errorf("expected section %q in %q", header, info)
+		}
+	}
+	fields := infoFields(info)
+	if fields["db0"] != "keys=1,expires=0,avg_ttl=0" {
+		t.Errorf("unexpected keyspace %q", fields["db0"])
+	}
+	if fields["btree_depth"] != "1" || fields["db_pages"] == "0" {
+		t.Errorf("unexpected persistence fields %q %q", fields["btree_depth"], fields["db_pages"])
+	}
+	for _, name := range []string{"uptime_in_seconds", "connected_clients", "total_commands_processed", "instantaneous_ops_per_sec",
+		"keyspace_hits", "keyspace_misses", "block_reads", "db_file_size"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("expected field %s in %q", name, info)
+		}
+	}
+	if fields["keyspace_hits"] == "0" || fields["keyspace_misses"] == "0" {
+		t.Errorf("expected keyspace hits and misses to be counted, got %q and %q", fields["keyspace_hits"], fields["keyspace_misses"])
+	}
+
+	// A single section is reported on its own, whatever the case of its name.
+	clientsOnly := c.do(t, "INFO", "CLIENTS").String()
+	if !strings.HasPrefix(clientsOnly, "# Clients\r\n") || strings.Contains(clientsOnly, "# Server") {
+		t.Errorf("unexpected reply %q", clientsOnly)
+	}
+}
+
+func TestInfoWalksTreeOnlyForPersistence(t *testing.T) {
+	database := openTestDB(t)
+	for i := 0; i < 100; i++ {
+		if err := database.Put(fmt.Sprintf("key%03d", i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reads := func() uint64 {
+		return database.Counters().BlockReads
+	}
+
+	// The keyspace section reports the running key count, without reading a block of the tree
+	before := reads()
+	fields := infoFields(infoCommand(database, INFOcommand{sections: []string{"server", "keyspace"}}).String())
+	if fields["db0"] != "keys=100,expires=0,avg_ttl=0" {
+		t.Errorf("unexpected keyspace %q", fields["db0"])
+	}
+	if after := reads(); after != before {
+		t.Errorf("expected INFO keyspace not to walk the tree, got %d block reads", after-before)
+	}
+
+	before = reads()
+	fields = infoFields(infoCommand(database, INFOcommand{sections: []string{"persistence"}}).String())
+	if fields["btree_depth"] != "2" {
+		t.Errorf("unexpected persistence depth %q", fields["btree_depth"])
+	}
+	if after := reads(); after == before {
+		t.Error("expected INFO persistence to walk the tree")
+	}
+}
+
+func TestInstantaneousOps(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sampleOps(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for instantaneousOps() == 0 {
+		serverStats.commands.Add(1000)
+		if time.Now().After(deadline) {
+			t.Fatal("expected the processed commands to show up in instantaneous_ops_per_sec")
+		}
+		time.Sleep(opsSampleInterval)
+	}
+}
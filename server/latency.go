@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	latencyEventCommand   = "command" // Event of a command that took at least latency-monitor-threshold
+	latencyHistorySamples = 160       // Number of samples kept per event, like in Redis
+)
+
+// latencySample is the worst latency of an event within one second.
+type latencySample struct {
+	time    int64 // Unix time of the second.
+	latency int64 // Latency in milliseconds.
+}
+
+// latencyEvent is the latency history of an event.
+type latencyEvent struct {
+	history []latencySample // The most recent samples, oldest first.
+	max     int64           // Highest latency ever recorded, in milliseconds.
+}
+
+// latencyMonitor records the events that took at least latency-monitor-threshold.
+var latencyMonitor = struct {
+	mu        sync.Mutex               // Mutex to protect the fields below.
+	threshold time.Duration            // Events taking at least this long are recorded; 0 disables the monitor.
+	events    map[string]*latencyEvent // Recorded events by name.
+}{
+	events: make(map[string]*latencyEvent),
+}
+
+// recordLatency records an event that took d if the monitor is enabled and d reaches the threshold.
+// Samples within the same second are merged, keeping the highest latency.
+func recordLatency(event string, d time.Duration) {
+	latencyMonitor.mu.Lock()
+	defer latencyMonitor.mu.Unlock()
+	if latencyMonitor.threshold == 0 || d < latencyMonitor.threshold {
+		return
+	}
+	e := latencyMonitor.events[event]
+	if e == nil {
+		e = &latencyEvent{}
+		latencyMonitor.events[event] = e
+	}
+	ms, now := d.Milliseconds(), time.Now().Unix()
+	if ms > e.max {
+		e.max = ms
+	}
+	if last := len(e.history) - 1; last >= 0 && e.history[last].time == now {
+		if ms > e.history[last].latency {
+			e.history[last].latency = ms
+		}
+		return
+	}
+	e.history = append(e.history, latencySample{time: now, latency: ms})
+	if len(e.history) > latencyHistorySamples {
+		e.history = append([]latencySample(nil), e.history[1:]...)
+	}
+}
+
+// setLatencyThreshold applies a new latency-monitor-threshold value, in milliseconds.
+func setLatencyThreshold(value string) error {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET 'latency-monitor-threshold'", value)
+	}
+	latencyMonitor.mu.Lock()
+	defer latencyMonitor.mu.Unlock()
+	latencyMonitor.threshold = time.Duration(n) * time.Millisecond
+	return nil
+}
+
+// latencyCommand executes a LATENCY LATEST, HISTORY or RESET command and returns the reply.
+func latencyCommand(c LATENCYcommand) resp.Value {
+	latencyMonitor.mu.Lock()
+	defer latencyMonitor.mu.Unlock()
+	switch c.subcommand {
+	case "LATEST":
+		// Every event with the time and latency of its latest sample and its all-time maximum
+		names := make([]string, 0, len(latencyMonitor.events))
+		for name := range latencyMonitor.events {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		values := make([]resp.Value, 0, len(names))
+		for _, name := range names {
+			e := latencyMonitor.events[name]
+			latest := e.history[len(e.history)-1]
+			values = append(values, resp.ArrayValue([]resp.Value{
+				resp.StringValue(name),
+				resp.IntegerValue(int(latest.time)),
+				resp.IntegerValue(int(latest.latency)),
+				resp.IntegerValue(int(e.max)),
+			}))
+		}
+		return resp.ArrayValue(values)
+	case "HISTORY":
+		// Time and latency pairs of an event, oldest first
+		var values []resp.Value
+		if e := latencyMonitor.events[strings.ToLower(c.args[0])]; e != nil {
+			for _, sample := range e.history {
+				values = append(values, resp.ArrayValue([]resp.Value{
+					resp.IntegerValue(int(sample.time)),
+					resp.IntegerValue(int(sample.latency)),
+				}))
+			}
+		}
+		return resp.ArrayValue(values)
+	}
+	// RESET: the named events, or all of them, replying with the number of events reset
+	if len(c.args) == 0 {
+		reset := len(latencyMonitor.events)
+		latencyMonitor.events = make(map[string]*latencyEvent)
+		return resp.IntegerValue(reset)
+	}
+	reset := 0
+	for _, name := range c.args {
+		name = strings.ToLower(name)
+		if _, ok := latencyMonitor.events[name]; ok {
+			delete(latencyMonitor.events, name)
+			reset++
+		}
+	}
+	return resp.IntegerValue(reset)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	db "database/database"
+)
+
+func TestLatencyMonitor(t *testing.T) {
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	t.Cleanup(func() { c.do(t, "LATENCY", "RESET") })
+
+	// A disabled monitor records nothing.
+	recordLatency(db.LatencyFsync, time.Second)
+	if v := c.do(t, "LATENCY", "LATEST").Array(); len(v) != 0 {
+		t.Fatalf("unexpected events %v", v)
+	}
+
+	setConfig(t, "latency-monitor-threshold", "100", "0")
+	recordLatency(db.LatencyFsync, 50*time.Millisecond) // Below the threshold
+	recordLatency(db.LatencyFsync, 200*time.Millisecond)
+	recordLatency(db.LatencyFsync, 300*time.Millisecond) // Merged into the same second
+	recordLatency(db.LatencyBtreeSplit, 150*time.Millisecond)
+
+	latest := c.do(t, "LATENCY", "LATEST").Array()
+	if len(latest) != 2 {
+		t.Fatalf("expected 2 events, got %v", latest)
+	}
+	split, fsync := latest[0].Array(), latest[1].Array()
+	if split[0].String() != db.LatencyBtreeSplit || split[2].Integer() != 150 {
+		t.Errorf("unexpected event %v", split)
+	}
+	if fsync[0].String() != db.LatencyFsync || fsync[2].Integer() != 300 || fsync[3].Integer() != 300 {
+		t.Errorf("unexpected event %v", fsync)
+	}
+
+	history := c.do(t, "LATENCY", "HISTORY", db.LatencyFsync).Array()
+	if len(history) != 1 || history[0].Array()[1].Integer() != 300 {
+		t.Errorf("unexpected history %v", history)
+	}
+	if v := c.do(t, "LATENCY", "RESET", db.LatencyFsync, "unknown"); v.Integer() != 1 {
+		t.Errorf("expected one event to be reset, got %v", v)
+	}
+	if v := c.do(t, "LATENCY", "LATEST").Array(); len(v) != 1 {
+		t.Errorf("expected only the split event to remain, got %v", v)
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of the connection limits, matching the Redis defaults.
+const (
+	defaultMaxClients   = 10000             // Maximum number of connected clients
+	defaultTCPKeepAlive = 300 * time.Second // Period of the TCP keepalive probes
+)
+
+// Output buffer limit classes: replies to commands are accounted against the normal class,
+// messages published to subscribers against the pubsub class and the replication stream against the replica class.
+const (
+	classNormal  = "normal"
+	classPubSub  = "pubsub"
+	classReplica = "replica"
+)
+
+// outputBufferLimit bounds the number of bytes queued for a client that are not yet written to its connection.
+type outputBufferLimit struct {
+	hard        int64         // The client is disconnected as soon as this many bytes are queued; 0 disables it.
+	soft        int64         // The client is disconnected if this many bytes stay queued for softSeconds; 0 disables it.
+	softSeconds time.Duration // How long the soft limit may be exceeded.
+}
+
+// limits holds the connection limits that can be changed with CONFIG SET.
+var limits = struct {
+	mu           sync.RWMutex                 // Mutex to protect the limits.
+	maxClients   int                          // Maximum number of connected clients.
+	timeout      time.Duration                // Idle time after which a client is closed; 0 disables it.
+	keepAlive    time.Duration                // Period of the TCP keepalive probes; 0 disables them.
+	outputBuffer map[string]outputBufferLimit // Output buffer limits by client class.
+}{
+	maxClients: defaultMaxClients,
+	keepAlive:  defaultTCPKeepAlive,
+	outputBuffer: map[string]outputBufferLimit{
+		classNormal:  {},
+		classPubSub:  {hard: 32 << 20, soft: 8 << 20, softSeconds: 60 * time.Second},
+		classReplica: {hard: 256 << 20, soft: 64 << 20, softSeconds: 60 * time.Second},
+	},
+}
+
+// connectedClients is the number of clients currently connected.
+var connectedClients atomic.Int64
+
+// acquireClient counts a new connection, unless maxclients clients are already connected.
+func acquireClient() bool {
+	limits.mu.RLock()
+	max := int64(limits.maxClients)
+	limits.mu.RUnlock()
+	if connectedClients.Add(1) > max {
+		connectedClients.Add(-1)
+		return false
+	}
+	return true
+}
+
+// releaseClient uncounts a connection counted by acquireClient.
+func releaseClient() {
+	connectedClients.Add(-1)
+}
+
+// rejectClient tells a client over the limit why it is refused and closes its connection.
+func rejectClient(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("-ERR max number of clients reached\r\n"))
+}
+
+// idleTimeout returns the idle time after which a client is closed, or 0 if idle clients are kept.
+func idleTimeout() time.Duration {
+	limits.mu.RLock()
+	defer limits.mu.RUnlock()
+	return limits.timeout
+}
+
+// setKeepAlive configures the TCP keepalive probes of a freshly accepted connection.
+// Connections that are not TCP, such as Unix domain sockets, are left alone.
+func setKeepAlive(conn net.Conn) {
+	if nc, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = nc.NetConn() // TLS connection
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	limits.mu.RLock()
+	period := limits.keepAlive
+	limits.mu.RUnlock()
+	if period == 0 {
+		tcpConn.SetKeepAlive(false)
+		return
+	}
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(period)
+}
+
+// outputLimit returns the output buffer limit of a client class.
+func outputLimit(class string) outputBufferLimit {
+	limits.mu.RLock()
+	defer limits.mu.RUnlock()
+	return limits.outputBuffer[class]
+}
+
+// setMaxClients applies a new maxclients value.
+func setMaxClients(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET 'maxclients'", value)
+	}
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+	limits.maxClients = n
+	return nil
+}
+
+// setTimeout applies a new timeout value, in seconds.
+func setTimeout(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET 'timeout'", value)
+	}
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+	limits.timeout = time.Duration(n) * time.Second
+	return nil
+}
+
+// setTCPKeepAlive applies a new tcp-keepalive value, in seconds. It affects connections accepted afterwards.
+func setTCPKeepAlive(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET 'tcp-keepalive'", value)
+	}
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+	limits.keepAlive = time.Duration(n) * time.Second
+	return nil
+}
+
+// setOutputBufferLimit applies a client-output-buffer-limit value such as "pubsub 32mb 8mb 60",
+// made of class, hard limit, soft limit and soft seconds groups. Classes that are not mentioned keep their limits.
+func setOutputBufferLimit(value string) error {
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return fmt.Errorf("ERR Wrong number of arguments in buffer limit configuration.")
+	}
+	parsed := make(map[string]outputBufferLimit)
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class != classNormal && class != classPubSub && class != classReplica {
+			return fmt.Errorf("ERR Invalid client class specified in buffer limit configuration.")
+		}
+		hard, err1 := parseMemory(fields[i+1])
+		soft, err2 := parseMemory(fields[i+2])
+		seconds, err3 := strconv.Atoi(fields[i+3])
+		if err1 != nil || err2 != nil || err3 != nil || seconds < 0 {
+			return fmt.Errorf("ERR Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		parsed[class] = outputBufferLimit{hard: hard, soft: soft, softSeconds: time.Duration(seconds) * time.Second}
+	}
+
+	limits.mu.Lock()
+	defer limits.mu.Unlock()
+	for class, limit := range parsed {
+		limits.outputBuffer[class] = limit
+	}
+	return nil
+}
+
+// outputBufferLimitValue reports the client-output-buffer-limit value of every class.
+func outputBufferLimitValue() string {
+	limits.mu.RLock()
+	defer limits.mu.RUnlock()
+	classes := make([]string, 0, len(limits.outputBuffer))
+	for class := range limits.outputBuffer {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	parts := make([]string, 0, 4*len(classes))
+	for _, class := range classes {
+		limit := limits.outputBuffer[class]
+		parts = append(parts, class, strconv.FormatInt(limit.hard, 10), strconv.FormatInt(limit.soft, 10),
+			strconv.Itoa(int(limit.softSeconds/time.Second)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseMemory parses a byte count with an optional Redis-style unit: k, kb, m, mb, g or gb.
+// The units without b are powers of 1000, the ones with b powers of 1024.
+func parseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	} {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory amount %q", value)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// setConfig changes a runtime parameter for the duration of a test.
+func setConfig(t *testing.T, name, value, restore string) {
+	if err := configSet(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { configSet(name, restore) })
+}
+
+// startTestServer serves a test database on a loopback TCP listener.
+func startTestServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		l.Close()
+	})
+	go serve(ctx, l, openTestDB(t))
+	return l.Addr().String()
+}
+
+// roundTrip sends an inline command on conn and returns the first reply line.
+func roundTrip(t *testing.T, conn net.Conn, command string) string {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(command + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(line)
+}
+
+// waitForClients waits until the connections of earlier clients are handled to the end.
+func waitForClients(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for connectedClients.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("clients are still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	waitForClients(t)
+	setConfig(t, "maxclients", "1", "10000")
+	addr := startTestServer(t)
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply := roundTrip(t, first, "PING"); reply != "+PONG" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(second).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(line) != "-ERR max number of clients reached" {
+		t.Errorf("unexpected reply %q", line)
+	}
+
+	// Once the first client leaves there is room again.
+	first.Close()
+	waitForClients(t)
+	third, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if reply := roundTrip(t, third, "PING"); reply != "+PONG" {
+		t.Errorf("unexpected reply %q", reply)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	setConfig(t, "timeout", "1", "0")
+	addr := startTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if reply := roundTrip(t, conn, "PING"); reply != "+PONG" {
+		t.Fatalf("unexpected reply %q", reply)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the idle client to be closed, got %v", err)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	setConfig(t, "timeout", "1", "0")
+	// The client never reads, so the write of the reply blocks until its deadline
+	s, _ := newPipeSession(t)
+	s.reply(resp.StringValue("never read"))
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the client that stopped reading to be closed")
+	}
+}
+
+func TestOutputBufferHardLimit(t *testing.T) {
+	setConfig(t, "client-output-buffer-limit", "pubsub 64 0 0", "pubsub 32mb 8mb 60")
+	s, _ := newPipeSession(t)
+
+	if s.push(resp.StringValue(strings.Repeat("x", 100))) {
+		t.Error("expected a message over the hard limit to be refused")
+	}
+	select {
+	case <-s.done:
+	default:
+		t.Error("expected the client to be disconnected")
+	}
+}
+
+func TestOutputBufferSoftLimit(t *testing.T) {
+	setConfig(t, "client-output-buffer-limit", "pubsub 0 10 0", "pubsub 32mb 8mb 60")
+	// The client never reads, so the queued bytes stay over the soft limit.
+	s, _ := newPipeSession(t)
+
+	if !s.push(resp.StringValue("first message")) {
+		t.Fatal("expected the first message over the soft limit to be queued")
+	}
+	if s.push(resp.StringValue("second message")) {
+		t.Error("expected the client to be disconnected once the soft limit lasted too long")
+	}
+}
+
+func TestOutputBufferLimitConfig(t *testing.T) {
+	setConfig(t, "client-output-buffer-limit", "pubsub 1mb 256kb 10", "pubsub 32mb 8mb 60")
+	got := configGet("client-output-buffer-limit")
+	want := []string{"client-output-buffer-limit", "normal 0 0 0 pubsub 1048576 262144 10 replica 268435456 67108864 60"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	for _, value := range []string{"pubsub 1 2", "master 0 0 0", "pubsub x 0 0"} {
+		if err := configSet("client-output-buffer-limit", value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := map[string]int64{"100": 100, "1k": 1000, "1kb": 1024, "2mb": 2 << 20, "1g": 1000 * 1000 * 1000, "5b": 5}
+	for value, want := range tests {
+		got, err := parseMemory(value)
+		if err != nil || got != want {
+			t.Errorf("parseMemory(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	if _, err := parseMemory("-1"); err == nil {
+		t.Error("expected a negative amount to be rejected")
+	}
+}
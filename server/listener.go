@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// listen opens a listener for every endpoint enabled in the configuration: the plain TCP port,
+// the TLS port and the Unix domain socket, followed by the listeners supplied by the embedding program.
+// If one of them cannot be opened, the ones already open are closed again and a *ListenError is returned.
+func (cfg Config) listen() ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, open := range []func() (net.Listener, error){cfg.listenTCP, cfg.listenTLS, cfg.listenUnix} {
+		l, err := open()
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		if l != nil {
+			listeners = append(listeners, l)
+		}
+	}
+	listeners = append(listeners, cfg.Listeners...)
+	if len(listeners) == 0 {
+		return nil, ErrNoListeners
+	}
+	return listeners, nil
+}
+
+// listenTCP opens the plain TCP listener, or returns nil if it is disabled.
+func (cfg Config) listenTCP() (net.Listener, error) {
+	if cfg.Port == 0 {
+		return nil, nil
+	}
+	addr := fmt.Sprintf("0.0.0.0:%d", cfg.Port)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, &ListenError{Network: "tcp", Addr: addr, Err: err}
+	}
+	return l, nil
+}
+
+// listenTLS opens the TLS listener, or returns nil if it is disabled.
+func (cfg Config) listenTLS() (net.Listener, error) {
+	if cfg.TLSPort == 0 {
+		return nil, nil
+	}
+	addr := fmt.Sprintf("0.0.0.0:%d", cfg.TLSPort)
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, &ListenError{Network: "tls", Addr: addr, Err: err}
+	}
+	l, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		return nil, &ListenError{Network: "tls", Addr: addr, Err: err}
+	}
+	return l, nil
+}
+
+// listenUnix opens the Unix domain socket listener, or returns nil if it is disabled.
+// A socket file left behind by a previous run is removed first, and the socket file
+// is removed again when the listener is closed.
+func (cfg Config) listenUnix() (net.Listener, error) {
+	if cfg.UnixSocket == "" {
+		return nil, nil
+	}
+	l, err := cfg.openUnixSocket()
+	if err != nil {
+		return nil, &ListenError{Network: "unix", Addr: cfg.UnixSocket, Err: err}
+	}
+	return l, nil
+}
+
+// openUnixSocket replaces a stale socket file and listens on the Unix domain socket.
+func (cfg Config) openUnixSocket() (net.Listener, error) {
+	if fi, err := os.Lstat(cfg.UnixSocket); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", cfg.UnixSocket)
+		}
+		if err := os.Remove(cfg.UnixSocket); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.UnixSocketPerm == 0 {
+		l, err := net.Listen("unix", cfg.UnixSocket)
+		if err != nil {
+			return nil, err
+		}
+		l.(*net.UnixListener).SetUnlinkOnClose(true)
+		return l, nil
+	}
+
+	// The socket is created in a private directory and given its permissions there, then renamed into place,
+	// so that it never accepts connections from users the permissions leave out
+	dir, err := os.MkdirTemp(filepath.Dir(cfg.UnixSocket), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ul := l.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, os.FileMode(cfg.UnixSocketPerm)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, cfg.UnixSocket); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: ul, path: cfg.UnixSocket}, nil
+}
+
+// unixListener is a Unix domain socket listener whose socket file was renamed after it was bound,
+// so it removes the file at its final path when it is closed.
+type unixListener struct {
+	*net.UnixListener
+	path string // Path of the socket file.
+}
+
+// Close stops listening and removes the socket file.
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if rmErr := os.Remove(l.path); err == nil && !errors.Is(rmErr, os.ErrNotExist) {
+		err = rmErr
+	}
+	return err
+}
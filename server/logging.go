@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// logging holds the logger the server reports to, set from Config.Logger.
+var logging = struct {
+	mu     sync.RWMutex // Mutex to protect logger.
+	logger *slog.Logger // The configured logger; nil uses slog.Default.
+}{}
+
+// NewLogger creates a logger writing to w in the given format, "text" or "json",
+// that discards records below the given level: "debug", "info", "warn" or "error".
+func NewLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, expected text or json", format)
+}
+
+// setLogger makes the server report to l; nil reverts to slog.Default.
+func setLogger(l *slog.Logger) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.logger = l
+}
+
+// logger returns the logger the server reports to.
+func logger() *slog.Logger {
+	logging.mu.RLock()
+	defer logging.mu.RUnlock()
+	if logging.logger == nil {
+		return slog.Default()
+	}
+	return logging.logger
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lockedBuffer is a bytes.Buffer that is safe to write from the connection goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "json", "warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept", "conn", 7)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "kept" || record["level"] != "WARN" || record["conn"] != float64(7) {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	if _, err := NewLogger(&buf, "xml", "info"); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+	if _, err := NewLogger(&buf, "text", "verbose"); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+}
+
+func TestCommandLogging(t *testing.T) {
+	var buf lockedBuffer
+	logger, err := NewLogger(&buf, "text", "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setLogger(logger)
+	t.Cleanup(func() { setLogger(nil) })
+
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	id := c.do(t, "CLIENT", "ID").Integer()
+	c.do(t, "PING")
+
+	// The command is logged once it completes, so wait for the next reply before looking.
+	c.do(t, "PING")
+	want := "msg=command conn=" + strconv.Itoa(id) + " addr=" + c.conn.LocalAddr().String() + " cmd=ping duration="
+	if logged := buf.String(); !strings.Contains(logged, want) {
+		t.Errorf("expected %q in %q", want, logged)
+	}
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	db "database/database"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const metricsNamespace = "database" // Prefix of every metric name
+
+// latencyBuckets are the upper bounds in seconds of the command latency histogram buckets.
+var latencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// histogram counts observations into cumulative buckets, like a Prometheus histogram.
+type histogram struct {
+	counts []atomic.Uint64 // Observations per bucket of latencyBuckets, and above the last bound.
+	sum    atomic.Int64    // Sum of the observations in nanoseconds.
+}
+
+// newHistogram creates a histogram with the latency buckets.
+func newHistogram() *histogram {
+	return &histogram{counts: make([]atomic.Uint64, len(latencyBuckets)+1)}
+}
+
+// observe adds a duration to the histogram.
+func (h *histogram) observe(d time.Duration) {
+	i := sort.SearchFloat64s(latencyBuckets, d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// commandLatency holds the latency histogram of every command name seen by the dispatch path.
+var commandLatency = struct {
+	mu         sync.RWMutex          // Mutex to protect histograms.
+	histograms map[string]*histogram // Histograms by lower-case command name.
+}{
+	histograms: make(map[string]*histogram),
+}
+
+// observeCommand records how long dispatching a command took. Names of unknown commands are
+// reported as "unknown", so clients cannot create an unbounded number of series.
+func observeCommand(name string, d time.Duration) {
+	if _, ok := commandCategories[name]; !ok {
+		name = "unknown"
+	}
+	name = strings.ToLower(name)
+
+	commandLatency.mu.RLock()
+	h := commandLatency.histograms[name]
+	commandLatency.mu.RUnlock()
+	if h == nil {
+		commandLatency.mu.Lock()
+		if h = commandLatency.histograms[name]; h == nil {
+			h = newHistogram()
+			commandLatency.histograms[name] = h
+		}
+		commandLatency.mu.Unlock()
+	}
+	h.observe(d)
+}
+
+// serveMetrics serves the metrics on addr over HTTP until the context is canceled.
+// It returns once the listener is open, so a bad address is reported to the caller.
+func serveMetrics(ctx context.Context, addr string, database *db.DB) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler(database))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(l)
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	return nil
+}
+
+// metricsHandler serves the metrics in the Prometheus text exposition format.
+func metricsHandler(database *db.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		e := &expositionWriter{w: bufio.NewWriter(w)}
+		writeMetrics(e, database)
+		e.w.Flush()
+	})
+}
+
+// writeMetrics writes every metric of the server and the database.
+func writeMetrics(e *expositionWriter, database *db.DB) {
+	limits.mu.RLock()
+	maxClients := limits.maxClients
+	limits.mu.RUnlock()
+
+	e.metric("uptime_seconds", "gauge", "Seconds since the server started.")
+	e.sample("uptime_seconds", nil, time.Since(serverStats.started).Seconds())
+	e.metric("connected_clients", "gauge", "Number of connected clients.")
+	e.sample("connected_clients", nil, float64(connectedClients.Load()))
+	e.metric("maxclients", "gauge", "Maximum number of connected clients.")
+	e.sample("maxclients", nil, float64(maxClients))
+	e.metric("connections_received_total", "counter", "Number of connections accepted.")
+	e.sample("connections_received_total", nil, float64(serverStats.connections.Load()))
+	e.metric("rejected_connections_total", "counter", "Number of connections refused because of maxclients.")
+	e.sample("rejected_connections_total", nil, float64(serverStats.rejected.Load()))
+	e.metric("commands_processed_total", "counter", "Number of commands processed.")
+	e.sample("commands_processed_total", nil, float64(serverStats.commands.Load()))
+	e.metric("keyspace_hits_total", "counter", "Number of lookups that found the key.")
+	e.sample("keyspace_hits_total", nil, float64(serverStats.keyspaceHits.Load()))
+	e.metric("keyspace_misses_total", "counter", "Number of lookups that did not find the key.")
+	e.sample("keyspace_misses_total", nil, float64(serverStats.keyspaceMisses.Load()))
+
+	commandLatency.mu.RLock()
+	histograms := make(map[string]*histogram, len(commandLatency.histograms))
+	names := make([]string, 0, len(commandLatency.histograms))
+	for name, h := range commandLatency.histograms {
+		histograms[name] = h
+		names = append(names, name)
+	}
+	commandLatency.mu.RUnlock()
+	sort.Strings(names)
+	e.metric("command_duration_seconds", "histogram", "Time spent dispatching a command, by command name.")
+	for _, name := range names {
+		e.histogram("command_duration_seconds", []string{"command", name}, histograms[name])
+	}
+
+	if database == nil {
+		return
+	}
+	c := database.Counters()
+	e.metric("db_operations_total", "counter", "Number of database operations, by operation.")
+	e.sample("db_operations_total", []string{"op", "get"}, float64(c.Gets))
+	e.sample("db_operations_total", []string{"op", "put"}, float64(c.Puts))
+	e.sample("db_operations_total", []string{"op", "del"}, float64(c.Dels))
+	e.metric("btree_splits_total", "counter", "Number of B-tree nodes split because they overflowed.")
+	e.sample("btree_splits_total", nil, float64(c.Splits))
+	e.metric("btree_merges_total", "counter", "Number of B-tree nodes merged with a sibling because they underflowed.")
+	e.sample("btree_merges_total", nil, float64(c.Merges))
+	e.metric("block_reads_total", "counter", "Number of blocks read from disk.")
+	e.sample("block_reads_total", nil, float64(c.BlockReads))
+	e.metric("block_writes_total", "counter", "Number of blocks written to disk.")
+	e.sample("block_writes_total", nil, float64(c.BlockWrites))
+}
+
+// expositionWriter writes metrics in the Prometheus text exposition format.
+type expositionWriter struct {
+	w *bufio.Writer // Destination of the exposition.
+}
+
+// metric writes the HELP and TYPE lines that introduce a metric.
+func (e *expositionWriter) metric(name, kind, help string) {
+	name = metricsNamespace + "_" + name
+	e.w.WriteString("# HELP " + name + " " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help) + "\n")
+	e.w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// sample writes a single sample of a metric, with labels given as name, value pairs.
+func (e *expositionWriter) sample(name string, labels []string, value float64) {
+	e.w.WriteString(metricsNamespace + "_" + name)
+	if len(labels) > 0 {
+		e.w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				e.w.WriteByte(',')
+			}
+			e.w.WriteString(labels[i] + `="` + escapeLabelValue(labels[i+1]) + `"`)
+		}
+		e.w.WriteByte('}')
+	}
+	e.w.WriteString(" " + formatMetricValue(value) + "\n")
+}
+
+// histogram writes the cumulative buckets, sum and count samples of a histogram.
+func (e *expositionWriter) histogram(name string, labels []string, h *histogram) {
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		bound := math.Inf(1)
+		if i < len(latencyBuckets) {
+			bound = latencyBuckets[i]
+		}
+		e.sample(name+"_bucket", append(append([]string(nil), labels...), "le", formatMetricValue(bound)), float64(cumulative))
+	}
+	e.sample(name+"_sum", labels, time.Duration(h.sum.Load()).Seconds())
+	e.sample(name+"_count", labels, float64(cumulative))
+}
+
+// escapeLabelValue escapes backslashes, double quotes and newlines in a label value.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatMetricValue formats a sample value, spelling infinities the way Prometheus expects.
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	database := openTestDB(t)
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	c.do(t, "PING")
+	c.do(t, "NOSUCHCOMMAND")
+
+	srv := httptest.NewServer(metricsHandler(database))
+	defer srv.Close()
+	database.Put("metrics-key", "value")
+
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", res.Header.Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(res.Body)
+	metrics := string(body)
+
+	for _, want := range []string{
+		"# TYPE database_command_duration_seconds histogram\n",
+		`database_command_duration_seconds_bucket{command="ping",le="+Inf"} `,
+		`database_command_duration_seconds_count{command="unknown"} `,
+		"# TYPE database_connected_clients gauge\n",
+		`database_db_operations_total{op="put"} 1` + "\n",
+		"database_btree_splits_total 0\n",
+		"database_block_writes_total ",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("expected %q in the metrics", want)
+		}
+	}
+
+	// Every line is a comment or a sample with a name and a value.
+	sc := bufio.NewScanner(strings.NewReader(metrics))
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, "# ") && len(strings.Fields(line)) != 2 {
+			t.Errorf("malformed line %q", line)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	h := newHistogram()
+	h.observe(50 * time.Microsecond) // First bucket
+	h.observe(time.Millisecond)      // The bucket bounded by exactly 0.001
+	h.observe(2 * time.Second)       // Above every bound
+
+	var b strings.Builder
+	e := &expositionWriter{w: bufio.NewWriter(&b)}
+	e.histogram("test", []string{"command", `a"b`}, h)
+	e.w.Flush()
+	out := b.String()
+
+	for _, want := range []string{
+		`database_test_bucket{command="a\"b",le="0.0001"} 1` + "\n",
+		`database_test_bucket{command="a\"b",le="0.001"} 2` + "\n",
+		`database_test_bucket{command="a\"b",le="1"} 2` + "\n",
+		`database_test_bucket{command="a\"b",le="+Inf"} 3` + "\n",
+		`database_test_sum{command="a\"b"} 2.00105` + "\n",
+		`database_test_count{command="a\"b"} 3` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+}
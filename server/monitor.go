@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// monitors is the registry of the clients that ran MONITOR.
+var monitors = struct {
+	mu       sync.RWMutex          // Mutex to protect sessions.
+	sessions map[*session]struct{} // Monitoring sessions.
+}{
+	sessions: make(map[*session]struct{}),
+}
+
+// monitor turns the session into a monitor that receives every command processed by the server.
+func (s *session) monitor() resp.Value {
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+	monitors.sessions[s] = struct{}{}
+	s.monitoring.Store(true)
+	return resp.SimpleStringValue("OK")
+}
+
+// stopMonitoring removes the session from the monitors, if it is one.
+func stopMonitoring(s *session) {
+	monitors.mu.Lock()
+	defer monitors.mu.Unlock()
+	delete(monitors.sessions, s)
+}
+
+// feedMonitors sends a command received from source to every monitor, like Redis does:
+// a status line with the time, the database and the source, followed by the quoted arguments.
+// Administrative commands are not shown and the password of AUTH is redacted.
+// Monitors are fed with push, so one that cannot keep up is disconnected instead of stalling the caller.
+func feedMonitors(source string, values []resp.Value) {
+	monitors.mu.RLock()
+	if len(monitors.sessions) == 0 {
+		monitors.mu.RUnlock()
+		return
+	}
+	sessions := make([]*session, 0, len(monitors.sessions))
+	for s := range monitors.sessions {
+		sessions = append(sessions, s)
+	}
+	monitors.mu.RUnlock()
+
+	name := commandName(values)
+	for _, category := range commandCategories[name] {
+		if category == "admin" {
+			return
+		}
+	}
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, source)
+	for i, value := range values {
+		arg := value.String()
+		if i > 0 && name == CommandAUTH {
+			arg = "(redacted)"
+		}
+		b.WriteString(" " + quoteArgument(arg))
+	}
+	line := resp.SimpleStringValue(b.String())
+
+	for _, s := range sessions {
+		if !s.push(line) {
+			stopMonitoring(s)
+		}
+	}
+}
+
+// monitorSource returns how MONITOR shows the client: its address, or the socket path for Unix sockets.
+func (s *session) monitorSource() string {
+	if s.conn.LocalAddr().Network() == "unix" {
+		return "unix:" + s.conn.LocalAddr().String()
+	}
+	return s.conn.RemoteAddr().String()
+}
+
+// quoteArgument quotes an argument the way MONITOR shows it, escaping quotes, backslashes
+// and non-printable bytes.
+func quoteArgument(arg string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
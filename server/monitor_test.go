@@ -0,0 +1,69 @@
+package server
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+func TestMonitor(t *testing.T) {
+	addr := startTestServer(t)
+	monitor := dialTestClient(t, addr)
+	client := dialTestClient(t, addr)
+
+	if v := monitor.do(t, "MONITOR"); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	client.do(t, "CONFIG", "GET", "timeout") // Administrative commands are not shown
+	client.do(t, "AUTH", "secret")
+	client.do(t, "SET", "monitored", "a \"b\"\n")
+
+	source := regexp.QuoteMeta(client.conn.LocalAddr().String())
+	for _, want := range []string{
+		`^\d+\.\d{6} \[0 ` + source + `\] "AUTH" "\(redacted\)"$`,
+		`^\d+\.\d{6} \[0 ` + source + `\] "SET" "monitored" "a \\"b\\"\\n"$`,
+	} {
+		monitor.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		v, _, err := monitor.rd.ReadValue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(want).MatchString(v.String()) {
+			t.Errorf("expected %s, got %q", want, v.String())
+		}
+	}
+}
+
+func TestMonitorDropsSlowClient(t *testing.T) {
+	s, _ := newPipeSession(t) // Nothing reads from the client side of the pipe
+	if v := s.monitor(); v.String() != "OK" {
+		t.Fatalf("unexpected reply %q", v.String())
+	}
+	defer stopMonitoring(s)
+
+	values := []resp.Value{resp.StringValue("GET"), resp.StringValue("key")}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*outputBufferSize; i++ {
+			feedMonitors("127.0.0.1:1", values)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected feeding a slow monitor not to block")
+	}
+	select {
+	case <-s.done:
+	default:
+		t.Fatal("expected the slow monitor to be disconnected")
+	}
+	monitors.mu.RLock()
+	defer monitors.mu.RUnlock()
+	if _, ok := monitors.sessions[s]; ok {
+		t.Error("expected the slow monitor to be removed")
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	db "database/database"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/tidwall/resp"
+)
+
+// watchedKeys tracks the sessions watching each key, so that modifying a key
+// invalidates the pending transaction of every session watching it.
+var watchedKeys = struct {
+	mu   sync.Mutex                       // Mutex to protect keys.
+	keys map[string]map[*session]struct{} // Watching sessions per key.
+}{
+	keys: make(map[string]map[*session]struct{}),
+}
+
+// touchKey marks every session watching the key as dirty, so its next EXEC is aborted.
+// It is called by the apply function of the database, with the write lock held, right after the key is modified.
+func touchKey(key string) {
+	watchedKeys.mu.Lock()
+	defer watchedKeys.mu.Unlock()
+	for s := range watchedKeys.keys[key] {
+		s.dirty.Store(true)
+	}
+}
+
+// touchAllKeys marks every session watching a key as dirty. It is called with the database write lock held
+// when the contents of the database are replaced without a change per key, as when loading a snapshot.
+func touchAllKeys() {
+	watchedKeys.mu.Lock()
+	defer watchedKeys.mu.Unlock()
+	for _, sessions := range watchedKeys.keys {
+		for s := range sessions {
+			s.dirty.Store(true)
+		}
+	}
+}
+
+// unwatchAll forgets every key watched by the session and clears its dirty flag.
+func unwatchAll(s *session) {
+	watchedKeys.mu.Lock()
+	defer watchedKeys.mu.Unlock()
+	for key := range s.watching {
+		removeSubscriber(watchedKeys.keys, key, s)
+	}
+	s.watching = make(map[string]struct{})
+	s.dirty.Store(false)
+}
+
+// isTransactionCommand reports whether a command controls a transaction rather than being queued by it.
+func isTransactionCommand(cmd command) bool {
+	switch cmd.(type) {
+	case MULTIcommand, EXECcommand, DISCARDcommand, WATCHcommand, UNWATCHcommand:
+		return true
+	}
+	return false
+}
+
+// multiCommand starts a transaction.
+func (s *session) multiCommand() resp.Value {
+	if s.multi {
+		return resp.ErrorValue(fmt.Errorf("ERR MULTI calls can not be nested"))
+	}
+	s.multi = true
+	return resp.SimpleStringValue("OK")
+}
+
+// queue adds a command to the pending transaction. A command that cannot be parsed
+// or that cannot run inside a transaction makes the following EXEC fail.
+func (s *session) queue(cmd command, parseErr error) resp.Value {
+	if parseErr != nil {
+		s.multiErr = true
+		return resp.ErrorValue(fmt.Errorf("ERR %v", parseErr))
+	}
+	if allowedWhileSubscribed(cmd) {
+		if _, ok := cmd.(PINGcommand); !ok {
+			s.multiErr = true
+			return resp.ErrorValue(fmt.Errorf("ERR Command not allowed inside a transaction"))
+		}
+	}
+	switch cmd.(type) {
+	case REPLICAOFcommand, PSYNCcommand, REPLCONFcommand, RAFTcommand, CLUSTERcommand, ASKINGcommand, MIGRATEcommand,
+		SAVEcommand, BGSAVEcommand, COMPACTcommand, BGREWRITEAOFcommand:
+		s.multiErr = true
+		return resp.ErrorValue(fmt.Errorf("ERR Command not allowed inside a transaction"))
+	}
+	s.queued = append(s.queued, cmd)
+	return resp.SimpleStringValue("QUEUED")
+}
+
+// exec runs the queued commands under a single database write lock and replies with their results.
+// If a watched key was modified since WATCH, nothing runs and the reply is null.
+func (s *session) exec() resp.Value {
+	if !s.multi {
+		return resp.ErrorValue(fmt.Errorf("ERR EXEC without MULTI"))
+	}
+	defer s.resetTransaction()
+	if s.multiErr {
+		return resp.ErrorValue(fmt.Errorf("EXECABORT Transaction discarded because of previous errors."))
+	}
+	if node := consensusNode(); node != nil && slices.ContainsFunc(s.queued, isWriteCommand) {
+		// In a Raft group the transaction is a single log entry; watched keys are checked when it is proposed
+		if s.dirty.Load() {
+			return resp.NullValue()
+		}
+		replies, err := s.propose(node, s.queued)
+		if err != nil {
+			return resp.ErrorValue(err)
+		}
+		return resp.ArrayValue(replies)
+	}
+
+	var replies []resp.Value
+	aborted := false
+	s.db.Update(func(tx *db.Tx) error {
+		// Checking the dirty flag under the write lock makes the check and the commands atomic.
+		if s.dirty.Load() {
+			aborted = true
+			return nil
+		}
+		replies = make([]resp.Value, len(s.queued))
+		for i, cmd := range s.queued {
+			replies[i] = s.execute(tx, cmd)
+		}
+		return nil
+	})
+	if aborted {
+		return resp.NullValue()
+	}
+	return resp.ArrayValue(replies)
+}
+
+// discard drops the queued commands and ends the transaction.
+func (s *session) discard() resp.Value {
+	if !s.multi {
+		return resp.ErrorValue(fmt.Errorf("ERR DISCARD without MULTI"))
+	}
+	s.resetTransaction()
+	return resp.SimpleStringValue("OK")
+}
+
+// watch starts watching keys for modifications until the next EXEC, DISCARD or UNWATCH.
+func (s *session) watch(keys []string) resp.Value {
+	if s.multi {
+		return resp.ErrorValue(fmt.Errorf("ERR WATCH inside MULTI is not allowed"))
+	}
+	watchedKeys.mu.Lock()
+	defer watchedKeys.mu.Unlock()
+	for _, key := range keys {
+		if _, ok := s.watching[key]; ok {
+			continue
+		}
+		s.watching[key] = struct{}{}
+		addSubscriber(watchedKeys.keys, key, s)
+	}
+	return resp.SimpleStringValue("OK")
+}
+
+// resetTransaction leaves the transaction state and forgets every watched key.
+func (s *session) resetTransaction() {
+	s.multi = false
+	s.multiErr = false
+	s.queued = nil
+	unwatchAll(s)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	db "database/database"
+	"path/filepath"
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+// openTestDB opens a database in a temporary directory that is closed when the test ends.
+func openTestDB(t *testing.T) *db.DB {
+	path := filepath.Join(t.TempDir(), "test.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close(path) })
+	database.SetApplyFunc(applyChange)
+	return database
+}
+
+func TestMultiExec(t *testing.T) {
+	database := openTestDB(t)
+	s := newSession(nil, database)
+
+	if reply := s.multiCommand(); reply.String() != "OK" {
+		t.Fatalf("unexpected MULTI reply %v", reply)
+	}
+	for _, cmd := range []command{SETcommand{key: "a", val: "1"}, GETcommand{key: "a"}} {
+		if reply := s.queue(cmd, nil); reply.String() != "QUEUED" {
+			t.Fatalf("unexpected queue reply %v", reply)
+		}
+	}
+	// Nothing runs before EXEC.
+	if _, found, _ := database.Get("a"); found {
+		t.Fatal("queued command ran before EXEC")
+	}
+
+	replies := s.exec().Array()
+	if len(replies) != 2 || replies[0].String() != "OK" || replies[1].String() != "1" {
+		t.Errorf("unexpected EXEC replies %v", replies)
+	}
+	if s.multi || len(s.queued) != 0 {
+		t.Error("EXEC should end the transaction")
+	}
+	if reply := s.exec(); reply.Type() != resp.Error {
+		t.Error("EXEC without MULTI should fail")
+	}
+}
+
+func TestExecAbortsAfterQueueError(t *testing.T) {
+	s := newSession(nil, openTestDB(t))
+	s.multiCommand()
+	if _, err := parseArray([]resp.Value{resp.StringValue("GET")}); err != nil {
+		s.queue(nil, err)
+	}
+	if reply := s.exec(); reply.Type() != resp.Error {
+		t.Errorf("expected EXECABORT, got %v", reply)
+	}
+}
+
+func TestWatchAbortsExec(t *testing.T) {
+	database := openTestDB(t)
+	watcher := newSession(nil, database)
+	other := newSession(nil, database)
+	defer unwatchAll(watcher)
+
+	watcher.watch([]string{"balance"})
+	other.run(SETcommand{key: "balance", val: "100"})
+
+	watcher.multiCommand()
+	watcher.queue(SETcommand{key: "other", val: "x"}, nil)
+	if reply := watcher.exec(); !reply.IsNull() {
+		t.Fatalf("EXEC should be aborted after a watched key changed, got %v", reply)
+	}
+	if _, found, _ := database.Get("other"); found {
+		t.Error("aborted transaction must not run its commands")
+	}
+
+	// The next transaction starts clean.
+	watcher.watch([]string{"balance"})
+	watcher.multiCommand()
+	watcher.queue(DELcommand{key: "balance"}, nil)
+	if reply := watcher.exec(); reply.IsNull() {
+		t.Error("EXEC should run when no watched key changed")
+	}
+}
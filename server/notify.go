@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	db "database/database"
+	"fmt"
+	"sync/atomic"
+)
+
+// Keyspace notification classes, as selected by the notify-keyspace-events parameter.
+const (
+	notifyKeyspace = 1 << iota // K: publish to __keyspace@0__:<key>
+	notifyKeyevent             // E: publish to __keyevent@0__:<event>
+	notifyGeneric              // g: generic commands such as DEL
+	notifyString               // $: string commands such as SET
+)
+
+// keyspaceEvents holds the currently enabled notification classes.
+var keyspaceEvents atomic.Uint32
+
+// parseKeyspaceEvents converts a notify-keyspace-events string such as "KEA" into notification classes.
+// Classes for data types this server does not have are accepted and ignored, like Redis does.
+func parseKeyspaceEvents(classes string) (uint32, error) {
+	var flags uint32
+	for _, c := range classes {
+		switch c {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'A':
+			flags |= notifyGeneric | notifyString
+		case 'l', 's', 'h', 'z', 'x', 'e', 't', 'm', 'd', 'n':
+			// Event classes of data types and features that do not exist here.
+		default:
+			return 0, fmt.Errorf("ERR Invalid event class character. Use 'Ag$lshzxetmdnKE'.")
+		}
+	}
+	// Without K or E nothing is published, whatever the classes.
+	if flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return 0, nil
+	}
+	return flags, nil
+}
+
+// setKeyspaceEvents applies a new notify-keyspace-events value.
+func setKeyspaceEvents(value string) error {
+	flags, err := parseKeyspaceEvents(value)
+	if err != nil {
+		return err
+	}
+	keyspaceEvents.Store(flags)
+	return nil
+}
+
+// notifyKeyspaceEvents forwards database changes to Pub/Sub as Redis-style keyspace notifications
+// until the context is canceled or the database is closed.
+func notifyKeyspaceEvents(ctx context.Context, database *db.DB) {
+	events := database.Watch("")
+	defer database.Unwatch(events)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			publishKeyspaceEvent(event)
+		}
+	}
+}
+
+// publishKeyspaceEvent publishes a single database change according to the enabled notification classes.
+func publishKeyspaceEvent(event db.Event) {
+	flags := keyspaceEvents.Load()
+	name, class := "set", uint32(notifyString)
+	if event.Op == db.OpDel {
+		name, class = "del", notifyGeneric
+	}
+	if flags&class == 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		pubsub.publish("__keyspace@0__:"+event.Key, name)
+	}
+	if flags&notifyKeyevent != 0 {
+		pubsub.publish("__keyevent@0__:"+name, event.Key)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	db "database/database"
+	"testing"
+)
+
+func TestParseKeyspaceEvents(t *testing.T) {
+	flags, err := parseKeyspaceEvents("KEA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != notifyKeyspace|notifyKeyevent|notifyGeneric|notifyString {
+		t.Errorf("unexpected flags %b", flags)
+	}
+	if flags, _ := parseKeyspaceEvents("A"); flags != 0 {
+		t.Error("classes without K or E should disable notifications")
+	}
+	if _, err := parseKeyspaceEvents("Kq"); err == nil {
+		t.Error("unknown class characters should be rejected")
+	}
+}
+
+func TestKeyspaceNotification(t *testing.T) {
+	if err := configSet("notify-keyspace-events", "K$"); err != nil {
+		t.Fatal(err)
+	}
+	defer configSet("notify-keyspace-events", "")
+	if got := configGet("notify-*"); len(got) != 2 || got[1] != "K$" {
+		t.Fatalf("unexpected CONFIG GET result %v", got)
+	}
+
+	s, rd := newPipeSession(t)
+	pubsub.psubscribe(s, "__key*__:*")
+	defer pubsub.unsubscribeAll(s)
+
+	// DEL belongs to the generic class, which is not enabled.
+	publishKeyspaceEvent(db.Event{Key: "foo", Op: db.OpDel})
+	publishKeyspaceEvent(db.Event{Key: "foo", Op: db.OpPut, Value: "bar"})
+
+	v, _, err := rd.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := v.Array()
+	if len(msg) != 4 || msg[2].String() != "__keyspace@0__:foo" || msg[3].String() != "set" {
+		t.Errorf("unexpected notification %v", msg)
+	}
+}
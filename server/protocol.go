@@ -4,15 +4,52 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/tidwall/resp"
 )
 
 const (
-	CommandSET = "SET" // Command for setting a key-value pair
-	CommandGET = "GET" // Command for getting the value of a key
-	CommandDEL = "DEL" // Command for deleting a key
+	CommandSET          = "SET"          // Command for setting a key-value pair
+	CommandGET          = "GET"          // Command for getting the value of a key
+	CommandDEL          = "DEL"          // Command for deleting a key
+	CommandPING         = "PING"         // Command for checking that the connection is alive
+	CommandSUBSCRIBE    = "SUBSCRIBE"    // Command for subscribing to channels
+	CommandUNSUBSCRIBE  = "UNSUBSCRIBE"  // Command for unsubscribing from channels
+	CommandPSUBSCRIBE   = "PSUBSCRIBE"   // Command for subscribing to channel patterns
+	CommandPUNSUBSCRIBE = "PUNSUBSCRIBE" // Command for unsubscribing from channel patterns
+	CommandPUBLISH      = "PUBLISH"      // Command for publishing a message to a channel
+	CommandCONFIG       = "CONFIG"       // Command for reading and changing runtime parameters
+	CommandMULTI        = "MULTI"        // Command for starting a transaction
+	CommandEXEC         = "EXEC"         // Command for executing a transaction
+	CommandDISCARD      = "DISCARD"      // Command for discarding a transaction
+	CommandWATCH        = "WATCH"        // Command for watching keys for optimistic locking
+	CommandUNWATCH      = "UNWATCH"      // Command for forgetting watched keys
+	CommandEVAL         = "EVAL"         // Command for running a Lua script
+	CommandEVALSHA      = "EVALSHA"      // Command for running a cached Lua script by its SHA1 digest
+	CommandSCRIPT       = "SCRIPT"       // Command for managing the script cache
+	CommandAUTH         = "AUTH"         // Command for authenticating the connection
+	CommandACL          = "ACL"          // Command for managing ACL users
+	CommandCLIENT       = "CLIENT"       // Command for inspecting and managing client connections
+	CommandINFO         = "INFO"         // Command for reporting information and statistics about the server
+	CommandSLOWLOG      = "SLOWLOG"      // Command for reading and resetting the log of slow commands
+	CommandLATENCY      = "LATENCY"      // Command for reading and resetting the latency of internal events
+	CommandMONITOR      = "MONITOR"      // Command for streaming every command processed by the server
+	CommandREPLICAOF    = "REPLICAOF"    // Command for following a leader or becoming one again
+	CommandROLE         = "ROLE"         // Command for reporting the replication role of the server
+	CommandPSYNC        = "PSYNC"        // Command sent by a follower to receive the replication stream
+	CommandREPLCONF     = "REPLCONF"     // Command sent by a follower to configure its link and acknowledge offsets
+	CommandRAFT         = "RAFT"         // Command carrying the RPCs between the members of a Raft group
+	CommandCLUSTER      = "CLUSTER"      // Command for inspecting and changing the slot table of the cluster
+	CommandASKING       = "ASKING"       // Command for accessing a slot being imported on the next command
+	CommandMIGRATE      = "MIGRATE"      // Command for moving keys to another node
+	CommandSAVE         = "SAVE"         // Command for writing a backup of the database before replying
+	CommandBGSAVE       = "BGSAVE"       // Command for writing a backup of the database in the background
+	CommandLASTSAVE     = "LASTSAVE"     // Command for reporting when the last backup was written
+	CommandCOMPACT      = "COMPACT"      // Command for rebuilding the database file densely
+	CommandBGREWRITEAOF = "BGREWRITEAOF" // Command for rewriting the append-only file in the background
 )
 
 // command is an empty interface implemented by different command types.
@@ -33,8 +70,178 @@ type DELcommand struct {
 	key string
 }
 
+// PINGcommand represents a PING command with an optional message.
+type PINGcommand struct {
+	message string
+}
+
+// SUBSCRIBEcommand represents a SUBSCRIBE command with one or more channels.
+type SUBSCRIBEcommand struct {
+	channels []string
+}
+
+// UNSUBSCRIBEcommand represents an UNSUBSCRIBE command; no channels means all of them.
+type UNSUBSCRIBEcommand struct {
+	channels []string
+}
+
+// PSUBSCRIBEcommand represents a PSUBSCRIBE command with one or more patterns.
+type PSUBSCRIBEcommand struct {
+	patterns []string
+}
+
+// PUNSUBSCRIBEcommand represents a PUNSUBSCRIBE command; no patterns means all of them.
+type PUNSUBSCRIBEcommand struct {
+	patterns []string
+}
+
+// PUBLISHcommand represents a PUBLISH command with a channel and a message.
+type PUBLISHcommand struct {
+	channel, message string
+}
+
+// CONFIGcommand represents a CONFIG command with a subcommand (GET or SET) and its arguments.
+type CONFIGcommand struct {
+	subcommand string
+	args       []string
+}
+
+// MULTIcommand represents a MULTI command.
+type MULTIcommand struct{}
+
+// EXECcommand represents an EXEC command.
+type EXECcommand struct{}
+
+// DISCARDcommand represents a DISCARD command.
+type DISCARDcommand struct{}
+
+// WATCHcommand represents a WATCH command with one or more keys.
+type WATCHcommand struct {
+	keys []string
+}
+
+// UNWATCHcommand represents an UNWATCH command.
+type UNWATCHcommand struct{}
+
+// EVALcommand represents an EVAL command with a script, its keys and its arguments.
+type EVALcommand struct {
+	script     string
+	keys, args []string
+}
+
+// EVALSHAcommand represents an EVALSHA command with a script digest, its keys and its arguments.
+type EVALSHAcommand struct {
+	sha        string
+	keys, args []string
+}
+
+// SCRIPTcommand represents a SCRIPT command with a subcommand (LOAD, EXISTS or FLUSH) and its arguments.
+type SCRIPTcommand struct {
+	subcommand string
+	args       []string
+}
+
+// AUTHcommand represents an AUTH command with an optional user name and a password.
+type AUTHcommand struct {
+	user, password string
+}
+
+// SAVEcommand represents a SAVE command.
+type SAVEcommand struct{}
+
+// BGSAVEcommand represents a BGSAVE command.
+type BGSAVEcommand struct{}
+
+// LASTSAVEcommand represents a LASTSAVE command.
+type LASTSAVEcommand struct{}
+
+// COMPACTcommand represents a COMPACT command.
+type COMPACTcommand struct{}
+
+// BGREWRITEAOFcommand represents a BGREWRITEAOF command.
+type BGREWRITEAOFcommand struct{}
+
+// ACLcommand represents an ACL command with a subcommand (SETUSER, DELUSER, LIST or WHOAMI) and its arguments.
+type ACLcommand struct {
+	subcommand string
+	args       []string
+}
+
+// CLIENTcommand represents a CLIENT command with a subcommand (LIST, INFO, ID, SETNAME, GETNAME, KILL, PAUSE or UNPAUSE)
+// and its arguments.
+type CLIENTcommand struct {
+	subcommand string
+	args       []string
+}
+
+// INFOcommand represents an INFO command with the names of the sections to report.
+type INFOcommand struct {
+	sections []string
+}
+
+// SLOWLOGcommand represents a SLOWLOG command with a subcommand (GET, LEN or RESET) and its arguments.
+type SLOWLOGcommand struct {
+	subcommand string
+	args       []string
+}
+
+// LATENCYcommand represents a LATENCY command with a subcommand (LATEST, HISTORY or RESET) and its arguments.
+type LATENCYcommand struct {
+	subcommand string
+	args       []string
+}
+
+// MONITORcommand represents a MONITOR command.
+type MONITORcommand struct{}
+
+// REPLICAOFcommand represents a REPLICAOF command with the host and port of the leader, or NO ONE.
+type REPLICAOFcommand struct {
+	host, port string
+}
+
+// ROLEcommand represents a ROLE command.
+type ROLEcommand struct{}
+
+// PSYNCcommand represents a PSYNC command with the replication ID and the offset the follower continues from.
+type PSYNCcommand struct {
+	replID string
+	offset int64
+}
+
+// RAFTcommand represents a RAFT command with an RPC (VOTE, APPEND or SNAPSHOT) and its JSON-encoded request.
+type RAFTcommand struct {
+	subcommand string
+	arg        string
+}
+
+// REPLCONFcommand represents a REPLCONF command with an option (listening-port or ACK) and its value.
+type REPLCONFcommand struct {
+	args []string
+}
+
+// CLUSTERcommand represents a CLUSTER command with a subcommand (SLOTS, SHARDS, KEYSLOT, INFO, MYID, NODES,
+// SETSLOT, COUNTKEYSINSLOT or GETKEYSINSLOT) and its arguments.
+type CLUSTERcommand struct {
+	subcommand string
+	args       []string
+}
+
+// ASKINGcommand represents an ASKING command.
+type ASKINGcommand struct{}
+
+// MIGRATEcommand represents a MIGRATE command with the target node, the keys to move and its options.
+type MIGRATEcommand struct {
+	host, port     string
+	keys           []string
+	db             int
+	timeout        time.Duration
+	copy, replace  bool
+	user, password string
+}
+
 // parseCommand parses a RESP-formatted message into a command.
-// It identifies the command type (SET, GET, DEL) and extracts parameters.
+// It reads the first RESP array in the message and hands it to parseArray.
+// Malformed RESP is reported as a *ProtocolError.
 func parseCommand(msg string) (command, error) {
 	rd := resp.NewReader(bytes.NewBufferString(msg))
 
@@ -45,52 +252,422 @@ func parseCommand(msg string) (command, error) {
 			break // End of input
 		}
 		if err != nil {
-			log.Fatal(err) // Fatal error in reading
+			return nil, &ProtocolError{Err: err}
 		}
 
 		if v.Type() == resp.Array {
-			// Process the array from the RESP protocol
-			for _, value := range v.Array() {
-				switch value.String() {
-
-				case CommandSET:
-					// Handle SET command
-					if len(v.Array()) != 3 {
-						return nil, fmt.Errorf("wrong number of parameters for SET command")
-					}
-					cmd := SETcommand{
-						key: v.Array()[1].String(),
-						val: v.Array()[2].String(),
-					}
-					return cmd, nil
-
-				case CommandGET:
-					// Handle GET command
-					if len(v.Array()) != 2 {
-						return nil, fmt.Errorf("wrong number of parameters for GET command")
-					}
-					cmd := GETcommand{
-						key: v.Array()[1].String(),
-					}
-					return cmd, nil
-
-				case CommandDEL:
-					// Handle DEL command
-					if len(v.Array()) != 2 {
-						return nil, fmt.Errorf("wrong number of parameters for DEL command")
-					}
-					cmd := DELcommand{
-						key: v.Array()[1].String(),
-					}
-					return cmd, nil
-
-				default:
-					// Unknown command, no action
+			return parseArray(v.Array())
+		}
+	}
+
+	// Return an error if no valid command is found
+	return nil, fmt.Errorf("invalid or unknown command")
+}
+
+// parseArray converts the elements of a RESP array into a command.
+// It identifies the command type from the first element and extracts parameters.
+func parseArray(values []resp.Value) (command, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("invalid or unknown command")
+	}
+	args := make([]string, len(values)-1)
+	for i, value := range values[1:] {
+		args[i] = value.String()
+	}
+
+	switch strings.ToUpper(values[0].String()) {
+	case CommandSET:
+		// Handle SET command
+		if len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of parameters for SET command")
+		}
+		return SETcommand{key: args[0], val: args[1]}, nil
+
+	case CommandGET:
+		// Handle GET command
+		if len(args) != 1 {
+			return nil, fmt.Errorf("wrong number of parameters for GET command")
+		}
+		return GETcommand{key: args[0]}, nil
+
+	case CommandDEL:
+		// Handle DEL command
+		if len(args) != 1 {
+			return nil, fmt.Errorf("wrong number of parameters for DEL command")
+		}
+		return DELcommand{key: args[0]}, nil
+
+	case CommandPING:
+		// Handle PING command
+		if len(args) > 1 {
+			return nil, fmt.Errorf("wrong number of parameters for PING command")
+		}
+		cmd := PINGcommand{}
+		if len(args) == 1 {
+			cmd.message = args[0]
+		}
+		return cmd, nil
+
+	case CommandSUBSCRIBE:
+		// Handle SUBSCRIBE command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for SUBSCRIBE command")
+		}
+		return SUBSCRIBEcommand{channels: args}, nil
+
+	case CommandUNSUBSCRIBE:
+		// Handle UNSUBSCRIBE command
+		return UNSUBSCRIBEcommand{channels: args}, nil
+
+	case CommandPSUBSCRIBE:
+		// Handle PSUBSCRIBE command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for PSUBSCRIBE command")
+		}
+		return PSUBSCRIBEcommand{patterns: args}, nil
+
+	case CommandPUNSUBSCRIBE:
+		// Handle PUNSUBSCRIBE command
+		return PUNSUBSCRIBEcommand{patterns: args}, nil
+
+	case CommandPUBLISH:
+		// Handle PUBLISH command
+		if len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of parameters for PUBLISH command")
+		}
+		return PUBLISHcommand{channel: args[0], message: args[1]}, nil
+
+	case CommandCONFIG:
+		// Handle CONFIG command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for CONFIG command")
+		}
+		sub := strings.ToUpper(args[0])
+		switch {
+		case sub == "GET" && len(args) == 2:
+		case sub == "SET" && len(args) >= 3 && len(args)%2 == 1:
+		default:
+			return nil, fmt.Errorf("wrong number of parameters for CONFIG %s command", sub)
+		}
+		return CONFIGcommand{subcommand: sub, args: args[1:]}, nil
+
+	case CommandMULTI, CommandEXEC, CommandDISCARD, CommandUNWATCH:
+		// Handle transaction commands without arguments
+		if len(args) != 0 {
+			return nil, fmt.Errorf("wrong number of parameters for %s command", strings.ToUpper(values[0].String()))
+		}
+		switch strings.ToUpper(values[0].String()) {
+		case CommandMULTI:
+			return MULTIcommand{}, nil
+		case CommandEXEC:
+			return EXECcommand{}, nil
+		case CommandDISCARD:
+			return DISCARDcommand{}, nil
+		}
+		return UNWATCHcommand{}, nil
+
+	case CommandWATCH:
+		// Handle WATCH command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for WATCH command")
+		}
+		return WATCHcommand{keys: args}, nil
+
+	case CommandEVAL, CommandEVALSHA:
+		// Handle EVAL and EVALSHA commands: script numkeys key [key ...] arg [arg ...]
+		name := strings.ToUpper(values[0].String())
+		if len(args) < 2 {
+			return nil, fmt.Errorf("wrong number of parameters for %s command", name)
+		}
+		numKeys, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("value is not an integer or out of range")
+		}
+		if numKeys < 0 {
+			return nil, fmt.Errorf("Number of keys can't be negative")
+		}
+		if numKeys > len(args)-2 {
+			return nil, fmt.Errorf("Number of keys can't be greater than number of args")
+		}
+		keys, scriptArgs := args[2:2+numKeys], args[2+numKeys:]
+		if name == CommandEVAL {
+			return EVALcommand{script: args[0], keys: keys, args: scriptArgs}, nil
+		}
+		return EVALSHAcommand{sha: args[0], keys: keys, args: scriptArgs}, nil
+
+	case CommandSCRIPT:
+		// Handle SCRIPT command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for SCRIPT command")
+		}
+		sub := strings.ToUpper(args[0])
+		switch {
+		case sub == "LOAD" && len(args) == 2:
+		case sub == "EXISTS" && len(args) >= 2:
+		case sub == "FLUSH" && len(args) <= 2:
+		default:
+			return nil, fmt.Errorf("wrong number of parameters for SCRIPT %s command", sub)
+		}
+		return SCRIPTcommand{subcommand: sub, args: args[1:]}, nil
+
+	case CommandAUTH:
+		// Handle AUTH command: AUTH password authenticates as the default user
+		switch len(args) {
+		case 1:
+			return AUTHcommand{user: defaultUser, password: args[0]}, nil
+		case 2:
+			return AUTHcommand{user: args[0], password: args[1]}, nil
+		}
+		return nil, fmt.Errorf("wrong number of parameters for AUTH command")
+
+	case CommandACL:
+		// Handle ACL command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for ACL command")
+		}
+		sub := strings.ToUpper(args[0])
+		switch {
+		case (sub == "LIST" || sub == "WHOAMI") && len(args) == 1:
+		case (sub == "SETUSER" || sub == "DELUSER") && len(args) >= 2:
+		default:
+			return nil, fmt.Errorf("wrong number of parameters for ACL %s command", sub)
+		}
+		return ACLcommand{subcommand: sub, args: args[1:]}, nil
+
+	case CommandCLIENT:
+		// Handle CLIENT command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for CLIENT command")
+		}
+		sub := strings.ToUpper(args[0])
+		switch {
+		case (sub == "INFO" || sub == "ID" || sub == "GETNAME" || sub == "UNPAUSE") && len(args) == 1:
+		case sub == "LIST" && (len(args) == 1 || len(args) >= 3 && strings.EqualFold(args[1], "ID")):
+		case sub == "SETNAME" && len(args) == 2:
+		case sub == "KILL" && (len(args) == 2 || len(args) >= 3 && len(args)%2 == 1):
+		case sub == "PAUSE" && (len(args) == 2 || len(args) == 3):
+		default:
+			return nil, fmt.Errorf("wrong number of parameters for CLIENT %s command", sub)
+		}
+		return CLIENTcommand{subcommand: sub, args: args[1:]}, nil
+
+	case CommandINFO:
+		// Handle INFO command: Without sections the default ones are reported
+		return INFOcommand{sections: args}, nil
+
+	case CommandSLOWLOG:
+		// Handle SLOWLOG command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for SLOWLOG command")
+		}
+		sub := strings.ToUpper(args[0])
+		switch {
+		case (sub == "LEN" || sub == "RESET") && len(args) == 1:
+		case sub == "GET" && (len(args) == 1 || len(args) == 2):
+		default:
+			return nil, fmt.Errorf("wrong number of parameters for SLOWLOG %s command", sub)
+		}
+		return SLOWLOGcommand{subcommand: sub, args: args[1:]}, nil
+
+	case CommandLATENCY:
+		// Handle LATENCY command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for LATENCY command")
+		}
+		sub := strings.ToUpper(args[0])
+		switch {
+		case sub == "LATEST" && len(args) == 1:
+		case sub == "HISTORY" && len(args) == 2:
+		case sub == "RESET":
+		default:
+			return nil, fmt.Errorf("wrong number of parameters for LATENCY %s command", sub)
+		}
+		return LATENCYcommand{subcommand: sub, args: args[1:]}, nil
+
+	case CommandMONITOR:
+		// Handle MONITOR command
+		if len(args) != 0 {
+			return nil, fmt.Errorf("wrong number of parameters for MONITOR command")
+		}
+		return MONITORcommand{}, nil
+
+	case CommandREPLICAOF:
+		// Handle REPLICAOF command
+		if len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of parameters for REPLICAOF command")
+		}
+		return REPLICAOFcommand{host: args[0], port: args[1]}, nil
+
+	case CommandROLE:
+		// Handle ROLE command
+		if len(args) != 0 {
+			return nil, fmt.Errorf("wrong number of parameters for ROLE command")
+		}
+		return ROLEcommand{}, nil
+
+	case CommandPSYNC:
+		// Handle PSYNC command: An offset of -1 asks for a full resynchronization
+		if len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of parameters for PSYNC command")
+		}
+		offset, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value is not an integer or out of range")
+		}
+		return PSYNCcommand{replID: args[0], offset: offset}, nil
+
+	case CommandREPLCONF:
+		// Handle REPLCONF command
+		if len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of parameters for REPLCONF command")
+		}
+		switch strings.ToLower(args[0]) {
+		case "listening-port", "ack":
+		default:
+			return nil, fmt.Errorf("unrecognized REPLCONF option: %s", args[0])
+		}
+		return REPLCONFcommand{args: args}, nil
+
+	case CommandRAFT:
+		// Handle RAFT command
+		if len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of parameters for RAFT command")
+		}
+		sub := strings.ToUpper(args[0])
+		switch sub {
+		case "VOTE", "APPEND", "SNAPSHOT":
+		default:
+			return nil, fmt.Errorf("unknown RAFT subcommand %s", args[0])
+		}
+		return RAFTcommand{subcommand: sub, arg: args[1]}, nil
+
+	case CommandCLUSTER:
+		// Handle CLUSTER command
+		if len(args) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for CLUSTER command")
+		}
+		sub := strings.ToUpper(args[0])
+		switch sub {
+		case "SLOTS", "SHARDS", "INFO", "MYID", "NODES":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("wrong number of parameters for CLUSTER %s command", sub)
+			}
+		case "KEYSLOT", "COUNTKEYSINSLOT":
+			if len(args) != 2 {
+				return nil, fmt.Errorf("wrong number of parameters for CLUSTER %s command", sub)
+			}
+		case "GETKEYSINSLOT":
+			if len(args) != 3 {
+				return nil, fmt.Errorf("wrong number of parameters for CLUSTER %s command", sub)
+			}
+		case "SETSLOT":
+			if len(args) < 3 {
+				return nil, fmt.Errorf("wrong number of parameters for CLUSTER %s command", sub)
+			}
+			switch action := strings.ToUpper(args[2]); {
+			case action == "STABLE" && len(args) == 3:
+			case (action == "MIGRATING" || action == "IMPORTING" || action == "NODE") && len(args) == 4:
+			default:
+				return nil, fmt.Errorf("wrong number of parameters for CLUSTER %s command", sub)
+			}
+		default:
+			return nil, fmt.Errorf("unknown CLUSTER subcommand %s", args[0])
+		}
+		return CLUSTERcommand{subcommand: sub, args: args[1:]}, nil
+
+	case CommandASKING:
+		// Handle ASKING command
+		if len(args) != 0 {
+			return nil, fmt.Errorf("wrong number of parameters for ASKING command")
+		}
+		return ASKINGcommand{}, nil
+
+	case CommandMIGRATE:
+		// Handle MIGRATE command: With an empty key, the keys follow the KEYS option
+		if len(args) < 5 {
+			return nil, fmt.Errorf("wrong number of parameters for MIGRATE command")
+		}
+		dbIndex, err := strconv.Atoi(args[3])
+		if err != nil {
+			return nil, fmt.Errorf("value is not an integer or out of range")
+		}
+		timeout, err := strconv.Atoi(args[4])
+		if err != nil {
+			return nil, fmt.Errorf("value is not an integer or out of range")
+		}
+		c := MIGRATEcommand{host: args[0], port: args[1], db: dbIndex, timeout: time.Duration(timeout) * time.Millisecond}
+		if args[2] != "" {
+			c.keys = []string{args[2]}
+		}
+		for i := 5; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "COPY":
+				c.copy = true
+			case "REPLACE":
+				c.replace = true
+			case "AUTH":
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("syntax error in MIGRATE command")
+				}
+				c.password = args[i+1]
+				i++
+			case "AUTH2":
+				if i+2 >= len(args) {
+					return nil, fmt.Errorf("syntax error in MIGRATE command")
+				}
+				c.user, c.password = args[i+1], args[i+2]
+				i += 2
+			case "KEYS":
+				if args[2] != "" {
+					return nil, fmt.Errorf("when using MIGRATE KEYS option, the key argument must be set to the empty string")
 				}
+				c.keys = args[i+1:]
+				i = len(args)
+			default:
+				return nil, fmt.Errorf("syntax error in MIGRATE command")
 			}
 		}
+		if len(c.keys) == 0 {
+			return nil, fmt.Errorf("wrong number of parameters for MIGRATE command")
+		}
+		return c, nil
+
+	case CommandSAVE:
+		// Handle SAVE command
+		if len(args) != 0 {
+			return nil, fmt.Errorf("wrong number of parameters for SAVE command")
+		}
+		return SAVEcommand{}, nil
+
+	case CommandBGSAVE:
+		// Handle BGSAVE command
+		if len(args) != 0 {
+			return nil, fmt.Errorf("wrong number of parameters for BGSAVE command")
+		}
+		return BGSAVEcommand{}, nil
+
+	case CommandLASTSAVE:
+		// Handle LASTSAVE command
+		if len(args) != 0 {
+			return nil, fmt.Errorf("wrong number of parameters for LASTSAVE command")
+		}
+		return LASTSAVEcommand{}, nil
+
+	case CommandCOMPACT:
+		// Handle COMPACT command
+		if len(args) != 0 {
+			return nil, fmt.Errorf("wrong number of parameters for COMPACT command")
+		}
+		return COMPACTcommand{}, nil
+
+	case CommandBGREWRITEAOF:
+		// Handle BGREWRITEAOF command
+		if len(args) != 0 {
+			return nil, fmt.Errorf("wrong number of parameters for BGREWRITEAOF command")
+		}
+		return BGREWRITEAOFcommand{}, nil
 	}
 
-	// Return an error if no valid command is found
+	// Unknown command, no action
 	return nil, fmt.Errorf("invalid or unknown command")
 }
@@ -7,7 +7,7 @@ import (
 
 func TestSETProtocol(t *testing.T) {
 	raw := "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"
-	cmd, err := ParseCommand(raw)
+	cmd, err := parseCommand(raw)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -22,7 +22,7 @@ func TestSETProtocol(t *testing.T) {
 
 func TestGETProtocol(t *testing.T) {
 	raw := "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"
-	cmd, err := ParseCommand(raw)
+	cmd, err := parseCommand(raw)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -37,7 +37,7 @@ func TestGETProtocol(t *testing.T) {
 
 func TestDELProtocol(t *testing.T) {
 	raw := "*2\r\n$3\r\nDEL\r\n$3\r\nfoo\r\n"
-	cmd, err := ParseCommand(raw)
+	cmd, err := parseCommand(raw)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -49,3 +49,25 @@ func TestDELProtocol(t *testing.T) {
 		t.Error("the parsing failed")
 	}
 }
+
+func TestSUBSCRIBEProtocol(t *testing.T) {
+	raw := "*3\r\n$9\r\nsubscribe\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"
+	cmd, err := parseCommand(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cmd, SUBSCRIBEcommand{channels: []string{"foo", "bar"}}) {
+		t.Error("the parsing failed")
+	}
+}
+
+func TestPUBLISHProtocol(t *testing.T) {
+	raw := "*3\r\n$7\r\nPUBLISH\r\n$3\r\nfoo\r\n$5\r\nhello\r\n"
+	cmd, err := parseCommand(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cmd, PUBLISHcommand{channel: "foo", message: "hello"}) {
+		t.Error("the parsing failed")
+	}
+}
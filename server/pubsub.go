@@ -0,0 +1,241 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/tidwall/resp"
+)
+
+// broker fans published messages out to the sessions subscribed to a channel or a pattern.
+type broker struct {
+	mu       sync.RWMutex                     // Protects channels and patterns.
+	channels map[string]map[*session]struct{} // Subscribers per channel name.
+	patterns map[string]map[*session]struct{} // Subscribers per glob-style pattern.
+}
+
+// pubsub is the broker shared by every connection of the server.
+var pubsub = newBroker()
+
+// newBroker creates an empty broker.
+func newBroker() *broker {
+	return &broker{
+		channels: make(map[string]map[*session]struct{}),
+		patterns: make(map[string]map[*session]struct{}),
+	}
+}
+
+// subscribe adds the session to the subscribers of the given channel.
+// It returns false if the session was already subscribed.
+func (b *broker) subscribe(s *session, channel string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := s.channels[channel]; ok {
+		return false
+	}
+	s.channels[channel] = struct{}{}
+	addSubscriber(b.channels, channel, s)
+	return true
+}
+
+// unsubscribe removes the session from the subscribers of the given channel.
+// It returns false if the session was not subscribed.
+func (b *broker) unsubscribe(s *session, channel string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := s.channels[channel]; !ok {
+		return false
+	}
+	delete(s.channels, channel)
+	removeSubscriber(b.channels, channel, s)
+	return true
+}
+
+// psubscribe adds the session to the subscribers of the given pattern.
+// It returns false if the session was already subscribed.
+func (b *broker) psubscribe(s *session, pattern string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := s.patterns[pattern]; ok {
+		return false
+	}
+	s.patterns[pattern] = struct{}{}
+	addSubscriber(b.patterns, pattern, s)
+	return true
+}
+
+// punsubscribe removes the session from the subscribers of the given pattern.
+// It returns false if the session was not subscribed.
+func (b *broker) punsubscribe(s *session, pattern string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := s.patterns[pattern]; !ok {
+		return false
+	}
+	delete(s.patterns, pattern)
+	removeSubscriber(b.patterns, pattern, s)
+	return true
+}
+
+// unsubscribeAll drops every channel and pattern subscription of the session.
+// It is called when the connection goes away.
+func (b *broker) unsubscribeAll(s *session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for channel := range s.channels {
+		removeSubscriber(b.channels, channel, s)
+	}
+	for pattern := range s.patterns {
+		removeSubscriber(b.patterns, pattern, s)
+	}
+	s.channels = make(map[string]struct{})
+	s.patterns = make(map[string]struct{})
+}
+
+// publish delivers a message to every subscriber of the channel and of every matching pattern.
+// Delivery never blocks: subscribers whose output buffer is full are disconnected instead.
+// It returns the number of subscribers the message was delivered to.
+func (b *broker) publish(channel, message string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	receivers := 0
+	for s := range b.channels[channel] {
+		msg := resp.ArrayValue([]resp.Value{
+			resp.StringValue("message"),
+			resp.StringValue(channel),
+			resp.StringValue(message),
+		})
+		if s.push(msg) {
+			receivers++
+		}
+	}
+	for pattern, subscribers := range b.patterns {
+		if !matchPattern(pattern, channel) {
+			continue
+		}
+		for s := range subscribers {
+			msg := resp.ArrayValue([]resp.Value{
+				resp.StringValue("pmessage"),
+				resp.StringValue(pattern),
+				resp.StringValue(channel),
+				resp.StringValue(message),
+			})
+			if s.push(msg) {
+				receivers++
+			}
+		}
+	}
+	return receivers
+}
+
+// addSubscriber registers s under name in the given subscription table.
+func addSubscriber(table map[string]map[*session]struct{}, name string, s *session) {
+	subscribers, ok := table[name]
+	if !ok {
+		subscribers = make(map[*session]struct{})
+		table[name] = subscribers
+	}
+	subscribers[s] = struct{}{}
+}
+
+// removeSubscriber unregisters s from name in the given subscription table,
+// dropping the entry altogether once nobody is subscribed.
+func removeSubscriber(table map[string]map[*session]struct{}, name string, s *session) {
+	subscribers, ok := table[name]
+	if !ok {
+		return
+	}
+	delete(subscribers, s)
+	if len(subscribers) == 0 {
+		delete(table, name)
+	}
+}
+
+// matchPattern reports whether str matches the Redis glob-style pattern.
+// It supports '*', '?', character classes such as [abc], [^a] and [a-z], and '\' escapes.
+func matchPattern(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Collapse consecutive stars, then try every possible split point.
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if matchPattern(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], str[0])
+			if !matched {
+				return false
+			}
+			str = str[1:]
+			pattern = rest
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(str) == 0
+}
+
+// matchClass matches c against the character class at the start of pattern (just after '[').
+// It returns whether c matched and the remainder of the pattern after the closing ']'.
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			if pattern[1] == c {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		pattern = pattern[1:] // Skip the closing ']'.
+	}
+	return matched != negate, pattern
+}
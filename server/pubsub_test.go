@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// newPipeSession returns a session backed by one end of an in-memory pipe and a reader for the other end.
+func newPipeSession(t *testing.T) (*session, *resp.Reader) {
+	serverConn, clientConn := net.Pipe()
+	s := newSession(serverConn, nil)
+	go s.writeLoop()
+	t.Cleanup(func() {
+		s.kill()
+		clientConn.Close()
+	})
+	return s, resp.NewReader(clientConn)
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, str string
+		want         bool
+	}{
+		{"*", "anything", true},
+		{"news.*", "news.sports", true},
+		{"news.*", "weather", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.str); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.str, got, tt.want)
+		}
+	}
+}
+
+func TestPublishToChannelAndPattern(t *testing.T) {
+	b := newBroker()
+	channelSub, channelReader := newPipeSession(t)
+	patternSub, patternReader := newPipeSession(t)
+
+	b.subscribe(channelSub, "news.sports")
+	b.psubscribe(patternSub, "news.*")
+
+	if n := b.publish("news.sports", "goal"); n != 2 {
+		t.Fatalf("expected 2 receivers, got %d", n)
+	}
+
+	v, _, err := channelReader.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"message", "news.sports", "goal"}
+	for i, value := range v.Array() {
+		if value.String() != want[i] {
+			t.Errorf("message element %d = %q, want %q", i, value.String(), want[i])
+		}
+	}
+
+	v, _, err = patternReader.ReadValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"pmessage", "news.*", "news.sports", "goal"}
+	for i, value := range v.Array() {
+		if value.String() != want[i] {
+			t.Errorf("pmessage element %d = %q, want %q", i, value.String(), want[i])
+		}
+	}
+
+	if n := b.publish("weather", "rain"); n != 0 {
+		t.Errorf("expected no receivers, got %d", n)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := newBroker()
+	s, _ := newPipeSession(t)
+
+	if !b.subscribe(s, "a") || b.subscribe(s, "a") {
+		t.Fatal("subscribe should only succeed once per channel")
+	}
+	b.psubscribe(s, "b.*")
+	if s.subscriptions() != 2 {
+		t.Fatalf("expected 2 subscriptions, got %d", s.subscriptions())
+	}
+
+	if !b.unsubscribe(s, "a") || b.unsubscribe(s, "a") {
+		t.Fatal("unsubscribe should only succeed once per channel")
+	}
+	b.unsubscribeAll(s)
+	if s.subscriptions() != 0 || len(b.channels) != 0 || len(b.patterns) != 0 {
+		t.Error("unsubscribeAll should drop every subscription")
+	}
+}
+
+func TestSlowSubscriberIsDisconnected(t *testing.T) {
+	b := newBroker()
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	// The writer is never started, so nothing drains the output buffer.
+	slow := newSession(serverConn, nil)
+	b.subscribe(slow, "flood")
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i <= outputBufferSize; i++ {
+			b.publish("flood", "payload")
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher blocked on a slow subscriber")
+	}
+	select {
+	case <-slow.done:
+	default:
+		t.Error("slow subscriber should have been disconnected")
+	}
+}
@@ -0,0 +1,789 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	db "database/database"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	raftTickPeriod       = 10 * time.Millisecond  // How often a member checks its timers
+	raftHeartbeatPeriod  = 50 * time.Millisecond  // How often a leader sends AppendEntries to every follower
+	raftElectionTimeout  = 300 * time.Millisecond // Minimum silence before a follower starts an election; randomized up to twice as long
+	raftRPCTimeout       = time.Second            // Longest wait for the reply of another member
+	raftProposeTimeout   = 5 * time.Second        // Longest a client waits for its write to be committed
+	raftMaxAppendEntries = 256                    // Entries sent in a single AppendEntries request
+	raftCompactThreshold = 1024                   // Applied entries kept in the log before it is compacted into the B-tree file
+)
+
+// raftRole is the role of a member in its Raft group.
+type raftRole int
+
+const (
+	raftFollower  raftRole = iota // Follows the leader and votes in elections.
+	raftCandidate                 // Asks the other members to vote for it.
+	raftLeader                    // Accepts writes and replicates them.
+)
+
+// String returns the lower-case name of the role.
+func (r raftRole) String() string {
+	switch r {
+	case raftCandidate:
+		return "candidate"
+	case raftLeader:
+		return "leader"
+	}
+	return "follower"
+}
+
+// raftEntry is an entry of the Raft log: a batch of commands applied atomically, like the commands of EXEC.
+type raftEntry struct {
+	Term     uint64     `json:"term"`               // Term of the leader that created the entry.
+	Index    uint64     `json:"index"`              // Position of the entry in the log, starting at 1.
+	User     string     `json:"user,omitempty"`     // ACL user that proposed the commands; scripts run as this user.
+	Commands [][]string `json:"commands,omitempty"` // The commands; none for the entry a new leader starts its term with.
+}
+
+// voteRequest is the RequestVote RPC sent by a candidate.
+type voteRequest struct {
+	Term         uint64 `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+// voteReply is the reply to a voteRequest.
+type voteReply struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+// appendRequest is the AppendEntries RPC sent by the leader, with no entries as a heartbeat.
+type appendRequest struct {
+	Term         uint64      `json:"term"`
+	Leader       string      `json:"leader"`
+	PrevLogIndex uint64      `json:"prev_log_index"`
+	PrevLogTerm  uint64      `json:"prev_log_term"`
+	Entries      []raftEntry `json:"entries,omitempty"`
+	LeaderCommit uint64      `json:"leader_commit"`
+}
+
+// appendReply is the reply to an appendRequest. A follower that rejects the entries
+// reports where the leader should continue from in ConflictIndex.
+type appendReply struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+// snapshotRequest is the InstallSnapshot RPC sent by the leader to a follower missing compacted entries.
+// Data is a copy of the B-tree file of the leader with every entry up to LastIndex applied.
+type snapshotRequest struct {
+	Term      uint64 `json:"term"`
+	Leader    string `json:"leader"`
+	LastIndex uint64 `json:"last_index"`
+	LastTerm  uint64 `json:"last_term"`
+	Data      []byte `json:"data"`
+}
+
+// snapshotReply is the reply to a snapshotRequest.
+type snapshotReply struct {
+	Term uint64 `json:"term"`
+}
+
+// raftTransport delivers the RPCs of a member to the other members of its group, identified by address.
+type raftTransport interface {
+	requestVote(ctx context.Context, peer string, req *voteRequest) (*voteReply, error)
+	appendEntries(ctx context.Context, peer string, req *appendRequest) (*appendReply, error)
+	installSnapshot(ctx context.Context, peer string, req *snapshotRequest) (*snapshotReply, error)
+}
+
+// raftApplyFunc applies the commands of a committed entry to the database and returns their replies.
+// It runs on every member in log order, under the database write lock.
+type raftApplyFunc func(tx *db.Tx, entry raftEntry) []resp.Value
+
+// notLeaderError is returned by propose on a member that is not the leader.
+type notLeaderError struct {
+	leader string // Address of the leader; empty while no leader is known.
+}
+
+// Error returns the error message.
+func (e *notLeaderError) Error() string {
+	if e.leader == "" {
+		return "no leader is elected"
+	}
+	return "the leader is " + e.leader
+}
+
+// errLeadershipLost is returned by propose when the member stops being the leader before the entry is applied.
+// The entry may still be committed by the next leader.
+var errLeadershipLost = errors.New("leadership lost before the command was committed")
+
+// raftWaiter is a client waiting for the entry it proposed to be applied.
+type raftWaiter struct {
+	term    uint64            // Term the entry was proposed in; an entry of another term at the index is not the client's.
+	replies chan []resp.Value // Receives the replies of the commands, or nil if the entry was lost.
+}
+
+// raftNode is a member of a Raft group. Writes are appended to the replicated log by the leader
+// and applied to the database by every member once a majority stored them.
+// The B-tree file doubles as the snapshot of the applied entries, so compacting the log only takes an fsync.
+type raftNode struct {
+	id        string        // Address of the member, which the other members and clients reach it at.
+	peers     []string      // Addresses of the other members.
+	transport raftTransport // Delivers RPCs to the other members.
+	db        *db.DB        // The database the entries are applied to.
+	apply     raftApplyFunc // Applies committed entries.
+	storage   *raftStorage  // Persists the term, the vote and the log.
+	log       *slog.Logger  // Logger with the member address attached.
+	applyCh   chan struct{} // Signals the apply loop that the commit index advanced.
+	kick      chan struct{} // Signals the leader to replicate new entries right away.
+	compactAt uint64        // Applied entries kept in the log before it is compacted.
+
+	mu               sync.Mutex             // Mutex to protect the fields below.
+	role             raftRole               // Current role of the member.
+	term             uint64                 // Latest term the member has seen.
+	votedFor         string                 // Candidate voted for in the current term; empty if none.
+	leader           string                 // Address of the current leader; empty if unknown.
+	entries          []raftEntry            // Log entries after the snapshot.
+	snapshotIndex    uint64                 // Index of the last entry compacted into the B-tree file.
+	snapshotTerm     uint64                 // Term of the entry at snapshotIndex.
+	commitIndex      uint64                 // Index of the last entry stored by a majority.
+	lastApplied      uint64                 // Index of the last entry applied to the database.
+	nextIndex        map[string]uint64      // Leader only: next entry to send to each follower.
+	matchIndex       map[string]uint64      // Leader only: last entry known to be stored by each follower.
+	inflight         map[string]bool        // Leader only: whether a request to the follower is pending.
+	waiters          map[uint64]*raftWaiter // Leader only: clients waiting for their entry, by index.
+	electionDeadline time.Time              // When the member starts an election unless it hears from a leader.
+	heartbeatDue     time.Time              // Leader only: when the next heartbeat is due.
+}
+
+// newRaftNode creates a member of the group formed by members, which includes id,
+// restoring its term, vote and log from the files starting with path.
+func newRaftNode(id string, members []string, transport raftTransport, database *db.DB, apply raftApplyFunc, path string) (*raftNode, error) {
+	storage, state, entries, err := openRaftStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	n := &raftNode{
+		id:            id,
+		transport:     transport,
+		db:            database,
+		apply:         apply,
+		storage:       storage,
+		log:           logger().With("raft", id),
+		applyCh:       make(chan struct{}, 1),
+		kick:          make(chan struct{}, 1),
+		compactAt:     raftCompactThreshold,
+		term:          state.Term,
+		votedFor:      state.VotedFor,
+		entries:       entries,
+		snapshotIndex: state.SnapshotIndex,
+		snapshotTerm:  state.SnapshotTerm,
+		commitIndex:   max(state.SnapshotIndex, state.Applied),
+		lastApplied:   max(state.SnapshotIndex, state.Applied),
+		waiters:       make(map[uint64]*raftWaiter),
+	}
+	for _, member := range members {
+		if member != id {
+			n.peers = append(n.peers, member)
+		}
+	}
+	n.resetElectionDeadline()
+	return n, nil
+}
+
+// run drives the member until the context is canceled: it starts elections, sends heartbeats and applies entries.
+func (n *raftNode) run(ctx context.Context) {
+	go n.applyLoop(ctx)
+	ticker := time.NewTicker(raftTickPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			n.failWaiters()
+			n.storage.close()
+			return
+		case <-n.kick:
+			n.mu.Lock()
+			n.replicate(ctx)
+			n.mu.Unlock()
+		case now := <-ticker.C:
+			n.mu.Lock()
+			switch {
+			case n.role == raftLeader && !now.Before(n.heartbeatDue):
+				n.heartbeatDue = now.Add(raftHeartbeatPeriod)
+				n.replicate(ctx)
+			case n.role != raftLeader && !now.Before(n.electionDeadline):
+				n.startElection(ctx)
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// status reports the role, term and leader of the member.
+func (n *raftNode) status() (role raftRole, term uint64, leader string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role, n.term, n.leader
+}
+
+// quorum returns the number of members that form a majority.
+func (n *raftNode) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// resetElectionDeadline sets a new randomized election timeout; the caller holds the mutex.
+func (n *raftNode) resetElectionDeadline() {
+	timeout := raftElectionTimeout + time.Duration(rand.Int63n(int64(raftElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// lastIndex returns the index of the last entry of the log; the caller holds the mutex.
+func (n *raftNode) lastIndex() uint64 {
+	if len(n.entries) == 0 {
+		return n.snapshotIndex
+	}
+	return n.entries[len(n.entries)-1].Index
+}
+
+// termAt returns the term of the entry at index, which is either compacted last or still in the log;
+// the caller holds the mutex.
+func (n *raftNode) termAt(index uint64) uint64 {
+	if index == n.snapshotIndex {
+		return n.snapshotTerm
+	}
+	if index < n.snapshotIndex || index > n.lastIndex() {
+		return 0
+	}
+	return n.entries[index-n.snapshotIndex-1].Term
+}
+
+// entriesFrom returns a copy of at most max entries starting at index; the caller holds the mutex.
+func (n *raftNode) entriesFrom(index uint64, max int) []raftEntry {
+	if index > n.lastIndex() {
+		return nil
+	}
+	tail := n.entries[index-n.snapshotIndex-1:]
+	if len(tail) > max {
+		tail = tail[:max]
+	}
+	return append([]raftEntry(nil), tail...)
+}
+
+// persistState saves the term, the vote, the snapshot position and the applied index; the caller holds the mutex.
+// A member that cannot persist its vote must not take part, so the error is fatal for the request at hand.
+func (n *raftNode) persistState() error {
+	err := n.storage.saveState(raftState{
+		Term: n.term, VotedFor: n.votedFor, SnapshotIndex: n.snapshotIndex, SnapshotTerm: n.snapshotTerm, Applied: n.lastApplied,
+	})
+	if err != nil {
+		n.log.Error("persisting Raft state", "err", err)
+	}
+	return err
+}
+
+// stepDown makes the member a follower, adopting a newer term; the caller holds the mutex.
+func (n *raftNode) stepDown(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leader = ""
+		n.persistState()
+	}
+	if n.role == raftLeader {
+		n.log.Info("stepping down", "term", n.term)
+		n.failWaiters()
+	}
+	n.role = raftFollower
+}
+
+// failWaiters tells every client waiting for an entry that it was lost; the caller holds the mutex.
+func (n *raftNode) failWaiters() {
+	for index, w := range n.waiters {
+		w.replies <- nil
+		delete(n.waiters, index)
+	}
+}
+
+// startElection becomes a candidate for a new term and asks the other members for their votes;
+// the caller holds the mutex.
+func (n *raftNode) startElection(ctx context.Context) {
+	n.role = raftCandidate
+	n.term++
+	n.votedFor = n.id
+	n.leader = ""
+	n.resetElectionDeadline()
+	if n.persistState() != nil {
+		return
+	}
+	n.log.Debug("starting election", "term", n.term)
+	term := n.term
+	req := &voteRequest{Term: term, Candidate: n.id, LastLogIndex: n.lastIndex(), LastLogTerm: n.termAt(n.lastIndex())}
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	for _, peer := range n.peers {
+		go func(peer string) {
+			rctx, cancel := context.WithTimeout(ctx, raftRPCTimeout)
+			defer cancel()
+			reply, err := n.transport.requestVote(rctx, peer, req)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.term {
+				n.stepDown(reply.Term)
+				return
+			}
+			if n.role != raftCandidate || n.term != term || !reply.Granted {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader()
+				n.replicate(ctx)
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader takes the lead of the group after winning an election. It appends an empty entry,
+// whose commitment also commits the entries of previous terms; the caller holds the mutex.
+func (n *raftNode) becomeLeader() {
+	n.role = raftLeader
+	n.leader = n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.inflight = make(map[string]bool)
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+	}
+	n.heartbeatDue = time.Now().Add(raftHeartbeatPeriod)
+	n.log.Info("elected leader", "term", n.term)
+	n.appendEntry(raftEntry{Term: n.term, Index: n.lastIndex() + 1})
+	n.advanceCommit()
+}
+
+// appendEntry adds an entry to the log of the leader; the caller holds the mutex.
+func (n *raftNode) appendEntry(e raftEntry) error {
+	if err := n.storage.append([]raftEntry{e}); err != nil {
+		n.log.Error("appending to the Raft log", "err", err)
+		return err
+	}
+	n.entries = append(n.entries, e)
+	return nil
+}
+
+// propose appends a batch of commands to the log and waits until it is applied, returning the replies
+// of the commands. It fails with a *notLeaderError on a member that is not the leader.
+func (n *raftNode) propose(user string, commands [][]string) ([]resp.Value, error) {
+	n.mu.Lock()
+	if n.role != raftLeader {
+		leader := n.leader
+		n.mu.Unlock()
+		return nil, &notLeaderError{leader: leader}
+	}
+	e := raftEntry{Term: n.term, Index: n.lastIndex() + 1, User: user, Commands: commands}
+	if err := n.appendEntry(e); err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	w := &raftWaiter{term: e.Term, replies: make(chan []resp.Value, 1)}
+	n.waiters[e.Index] = w
+	n.advanceCommit()
+	n.mu.Unlock()
+
+	select {
+	case n.kick <- struct{}{}:
+	default:
+	}
+	timer := time.NewTimer(raftProposeTimeout)
+	defer timer.Stop()
+	select {
+	case replies := <-w.replies:
+		if replies == nil {
+			return nil, errLeadershipLost
+		}
+		return replies, nil
+	case <-timer.C:
+		n.mu.Lock()
+		delete(n.waiters, e.Index)
+		n.mu.Unlock()
+		return nil, fmt.Errorf("timed out waiting for a majority to store the command")
+	}
+}
+
+// replicate sends the entries each follower is missing, or a heartbeat to those that are up to date;
+// the caller holds the mutex.
+func (n *raftNode) replicate(ctx context.Context) {
+	if n.role != raftLeader {
+		return
+	}
+	for _, peer := range n.peers {
+		if !n.inflight[peer] {
+			n.inflight[peer] = true
+			go n.replicateTo(ctx, peer)
+		}
+	}
+}
+
+// replicateTo sends a single AppendEntries or InstallSnapshot request to a follower and handles the reply,
+// continuing while the follower is missing entries.
+func (n *raftNode) replicateTo(ctx context.Context, peer string) {
+	for {
+		n.mu.Lock()
+		if n.role != raftLeader || ctx.Err() != nil {
+			n.inflight[peer] = false
+			n.mu.Unlock()
+			return
+		}
+		term, next := n.term, n.nextIndex[peer]
+		if next <= n.snapshotIndex {
+			n.mu.Unlock()
+			if !n.sendSnapshot(ctx, peer, term) {
+				return
+			}
+			continue
+		}
+		req := &appendRequest{
+			Term:         term,
+			Leader:       n.id,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  n.termAt(next - 1),
+			Entries:      n.entriesFrom(next, raftMaxAppendEntries),
+			LeaderCommit: n.commitIndex,
+		}
+		n.mu.Unlock()
+
+		rctx, cancel := context.WithTimeout(ctx, raftRPCTimeout)
+		reply, err := n.transport.appendEntries(rctx, peer, req)
+		cancel()
+
+		n.mu.Lock()
+		if err != nil || reply.Term > n.term || n.role != raftLeader || n.term != term {
+			if err == nil && reply.Term > n.term {
+				n.stepDown(reply.Term)
+			}
+			n.inflight[peer] = false
+			n.mu.Unlock()
+			return
+		}
+		if reply.Success {
+			match := req.PrevLogIndex + uint64(len(req.Entries))
+			if match > n.matchIndex[peer] {
+				n.matchIndex[peer] = match
+			}
+			n.nextIndex[peer] = match + 1
+			n.advanceCommit()
+		} else {
+			n.nextIndex[peer] = max(1, min(reply.ConflictIndex, req.PrevLogIndex))
+		}
+		more := n.nextIndex[peer] <= n.lastIndex()
+		if !more {
+			n.inflight[peer] = false
+		}
+		n.mu.Unlock()
+		if !more {
+			return
+		}
+	}
+}
+
+// sendSnapshot sends the B-tree file to a follower that is missing compacted entries.
+// It reports whether replication to the follower should continue.
+func (n *raftNode) sendSnapshot(ctx context.Context, peer string, term uint64) bool {
+	req := &snapshotRequest{Term: term, Leader: n.id}
+	var data bytes.Buffer
+	err := n.db.Update(func(tx *db.Tx) error {
+		// Entries are applied under the write lock, so the file matches lastApplied.
+		n.mu.Lock()
+		req.LastIndex, req.LastTerm = n.lastApplied, n.termAt(n.lastApplied)
+		n.mu.Unlock()
+		return tx.WriteSnapshot(&data)
+	})
+	req.Data = data.Bytes()
+
+	var reply *snapshotReply
+	if err == nil {
+		n.log.Info("sending snapshot", "peer", peer, "index", req.LastIndex, "bytes", len(req.Data))
+		rctx, cancel := context.WithTimeout(ctx, raftProposeTimeout)
+		reply, err = n.transport.installSnapshot(rctx, peer, req)
+		cancel()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err != nil || reply.Term > n.term || n.role != raftLeader || n.term != term {
+		if err == nil && reply.Term > n.term {
+			n.stepDown(reply.Term)
+		}
+		n.inflight[peer] = false
+		return false
+	}
+	n.matchIndex[peer] = max(n.matchIndex[peer], req.LastIndex)
+	n.nextIndex[peer] = req.LastIndex + 1
+	n.advanceCommit()
+	return true
+}
+
+// advanceCommit commits the latest entry of the current term stored by a majority; the caller holds the mutex.
+// Entries of previous terms are committed along with it.
+func (n *raftNode) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && n.termAt(index) == n.term; index-- {
+		count := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.commitIndex = index
+			n.signalApply()
+			return
+		}
+	}
+}
+
+// signalApply wakes up the apply loop; the caller holds the mutex.
+func (n *raftNode) signalApply() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// handleVote handles a RequestVote RPC: the vote goes to the first candidate of the term
+// whose log is at least as up to date as the member's.
+func (n *raftNode) handleVote(req *voteRequest) *voteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if req.Term > n.term {
+		n.stepDown(req.Term)
+	}
+	if req.Term < n.term {
+		return &voteReply{Term: n.term}
+	}
+	lastTerm := n.termAt(n.lastIndex())
+	upToDate := req.LastLogTerm > lastTerm || (req.LastLogTerm == lastTerm && req.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == req.Candidate) && upToDate {
+		n.votedFor = req.Candidate
+		if n.persistState() != nil {
+			return &voteReply{Term: n.term}
+		}
+		n.resetElectionDeadline()
+		return &voteReply{Term: n.term, Granted: true}
+	}
+	return &voteReply{Term: n.term}
+}
+
+// handleAppend handles an AppendEntries RPC: entries that conflict with the leader's are replaced,
+// and the commit index follows the leader's.
+func (n *raftNode) handleAppend(req *appendRequest) *appendReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if req.Term < n.term {
+		return &appendReply{Term: n.term}
+	}
+	if req.Term > n.term || n.role != raftFollower {
+		n.stepDown(req.Term)
+	}
+	if n.leader != req.Leader {
+		n.leader = req.Leader
+		n.log.Info("following leader", "leader", req.Leader, "term", n.term)
+	}
+	n.resetElectionDeadline()
+
+	prevIndex, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	if prevIndex < n.snapshotIndex {
+		// The entries up to the snapshot are committed, so they match the leader's
+		for len(entries) > 0 && entries[0].Index <= n.snapshotIndex {
+			entries = entries[1:]
+		}
+		prevIndex, prevTerm = n.snapshotIndex, n.snapshotTerm
+	}
+	if prevIndex > n.lastIndex() {
+		return &appendReply{Term: n.term, ConflictIndex: n.lastIndex() + 1}
+	}
+	if term := n.termAt(prevIndex); term != prevTerm {
+		// Skip the whole conflicting term rather than a single entry per round trip
+		conflict := prevIndex
+		for conflict > n.snapshotIndex+1 && n.termAt(conflict-1) == term {
+			conflict--
+		}
+		return &appendReply{Term: n.term, ConflictIndex: conflict}
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			// A conflicting entry is never committed; drop it and everything after it
+			n.entries = n.entries[:e.Index-n.snapshotIndex-1]
+			if err := n.storage.rewrite(n.entries); err != nil {
+				n.log.Error("truncating the Raft log", "err", err)
+				return &appendReply{Term: n.term, ConflictIndex: n.lastIndex() + 1}
+			}
+		}
+		if err := n.storage.append(entries[i:]); err != nil {
+			n.log.Error("appending to the Raft log", "err", err)
+			return &appendReply{Term: n.term, ConflictIndex: n.lastIndex() + 1}
+		}
+		n.entries = append(n.entries, entries[i:]...)
+		break
+	}
+
+	if last := prevIndex + uint64(len(entries)); req.LeaderCommit > n.commitIndex && last > n.commitIndex {
+		n.commitIndex = min(req.LeaderCommit, last)
+		n.signalApply()
+	}
+	return &appendReply{Term: n.term, Success: true}
+}
+
+// handleSnapshot handles an InstallSnapshot RPC: the B-tree file is replaced with the leader's
+// and the log continues after the snapshot.
+func (n *raftNode) handleSnapshot(req *snapshotRequest) *snapshotReply {
+	n.mu.Lock()
+	if req.Term < n.term {
+		defer n.mu.Unlock()
+		return &snapshotReply{Term: n.term}
+	}
+	if req.Term > n.term || n.role != raftFollower {
+		n.stepDown(req.Term)
+	}
+	n.leader = req.Leader
+	n.resetElectionDeadline()
+	term := n.term
+	n.mu.Unlock()
+
+	err := n.db.Update(func(tx *db.Tx) error {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if req.LastIndex <= n.lastApplied {
+			return nil // Already applied
+		}
+		if err := tx.LoadSnapshot(bytes.NewReader(req.Data)); err != nil {
+			return err
+		}
+		touchAllKeys()
+		if err := snapshotAOF(tx); err != nil {
+			return err
+		}
+		if req.LastIndex < n.lastIndex() && n.termAt(req.LastIndex) == req.LastTerm {
+			n.entries = append([]raftEntry(nil), n.entries[req.LastIndex-n.snapshotIndex:]...)
+		} else {
+			n.entries = nil
+		}
+		n.snapshotIndex, n.snapshotTerm = req.LastIndex, req.LastTerm
+		n.lastApplied = req.LastIndex
+		n.commitIndex = max(n.commitIndex, req.LastIndex)
+		if err := n.persistState(); err != nil {
+			return err
+		}
+		return n.storage.rewrite(n.entries)
+	})
+	if err != nil {
+		n.log.Error("installing snapshot", "err", err)
+	} else {
+		n.log.Info("installed snapshot", "index", req.LastIndex)
+	}
+	return &snapshotReply{Term: term}
+}
+
+// applyLoop applies the committed entries to the database in log order until the context is canceled,
+// handing the replies to the clients waiting for them, and compacts the log as it grows.
+func (n *raftNode) applyLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.applyCh:
+		}
+		n.mu.Lock()
+		var committed []raftEntry
+		if n.commitIndex > n.lastApplied {
+			committed = n.entriesFrom(n.lastApplied+1, int(n.commitIndex-n.lastApplied))
+		}
+		n.mu.Unlock()
+
+		for _, e := range committed {
+			err := n.db.Update(func(tx *db.Tx) error {
+				n.mu.Lock()
+				applied := e.Index <= n.lastApplied // A snapshot was installed in the meantime
+				n.mu.Unlock()
+				if applied {
+					return nil
+				}
+				replies := n.apply(tx, e)
+				n.mu.Lock()
+				defer n.mu.Unlock()
+				n.lastApplied = e.Index
+				// The index is saved with the change, so a restarted member does not apply the entry again
+				if err := n.persistState(); err != nil {
+					return err
+				}
+				if w := n.waiters[e.Index]; w != nil {
+					delete(n.waiters, e.Index)
+					if w.term != e.Term {
+						replies = nil // Another leader replaced the entry of the client
+					}
+					w.replies <- replies
+				}
+				return nil
+			})
+			if err != nil {
+				n.log.Error("applying Raft entry", "index", e.Index, "err", err)
+				return
+			}
+		}
+		n.compact()
+	}
+}
+
+// compact drops the applied entries from the log once there are enough of them.
+// The B-tree file already holds their effects, so it only has to be synced to become the snapshot.
+func (n *raftNode) compact() {
+	n.mu.Lock()
+	index := n.lastApplied
+	due := index-n.snapshotIndex >= n.compactAt
+	n.mu.Unlock()
+	if !due {
+		return
+	}
+	if err := n.db.Sync(); err != nil {
+		n.log.Error("syncing the database before compacting the Raft log", "err", err)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if index <= n.snapshotIndex {
+		return
+	}
+	// The state is saved first: entries it marks as compacted are skipped when the log is read back
+	term := n.termAt(index)
+	n.entries = append([]raftEntry(nil), n.entries[index-n.snapshotIndex:]...)
+	n.snapshotIndex, n.snapshotTerm = index, term
+	if err := n.persistState(); err != nil {
+		return
+	}
+	if err := n.storage.rewrite(n.entries); err != nil {
+		n.log.Error("compacting the Raft log", "err", err)
+		return
+	}
+	n.log.Debug("compacted Raft log", "index", index)
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	raftStateSuffix = ".state" // Suffix of the state file of a member
+	raftLogSuffix   = ".log"   // Suffix of the log file of a member
+	syncTempSuffix  = ".tmp"   // Suffix of the temporary file writeFileSync renames over the file it replaces
+)
+
+// raftState is what a member must remember across restarts besides its log.
+type raftState struct {
+	Term          uint64 `json:"term"`           // Latest term the member has seen.
+	VotedFor      string `json:"voted_for"`      // Candidate voted for in that term.
+	SnapshotIndex uint64 `json:"snapshot_index"` // Index of the last entry compacted into the B-tree file.
+	SnapshotTerm  uint64 `json:"snapshot_term"`  // Term of that entry.
+	Applied       uint64 `json:"applied"`        // Index of the last entry applied to the database.
+}
+
+// raftStorage persists the state of a member in path.state, replaced atomically on every change,
+// and its log in path.log, one JSON entry per line. Both are synced before a change is acted upon.
+type raftStorage struct {
+	path string   // Common prefix of the file names.
+	log  *os.File // The log file, opened for appending.
+}
+
+// openRaftStorage opens the files of a member and reads back its state and the log entries after the snapshot.
+// A torn last line, left by a crash in the middle of an append, is dropped.
+func openRaftStorage(path string) (*raftStorage, raftState, []raftEntry, error) {
+	var state raftState
+	b, err := os.ReadFile(path + raftStateSuffix)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &state); err != nil {
+			return nil, state, nil, fmt.Errorf("reading %s.state: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, state, nil, err
+	}
+
+	var entries []raftEntry
+	f, err := os.Open(path + raftLogSuffix)
+	switch {
+	case err == nil:
+		rd := bufio.NewReader(f)
+		for {
+			line, err := rd.ReadBytes('\n')
+			if err == io.EOF {
+				break // A line without its newline was not completely written
+			}
+			if err != nil {
+				f.Close()
+				return nil, state, nil, err
+			}
+			var e raftEntry
+			if err := json.Unmarshal(line, &e); err != nil {
+				f.Close()
+				return nil, state, nil, fmt.Errorf("reading %s.log: %w", path, err)
+			}
+			if e.Index > state.SnapshotIndex {
+				entries = append(entries, e)
+			}
+		}
+		f.Close()
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, state, nil, err
+	}
+	for i, e := range entries {
+		if e.Index != state.SnapshotIndex+uint64(i)+1 {
+			return nil, state, nil, fmt.Errorf("reading %s.log: entry %d found where %d was expected", path, e.Index, state.SnapshotIndex+uint64(i)+1)
+		}
+	}
+
+	s := &raftStorage{path: path}
+	if err := s.rewrite(entries); err != nil {
+		return nil, state, nil, err
+	}
+	return s, state, entries, nil
+}
+
+// saveState replaces the state file.
+func (s *raftStorage) saveState(state raftState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(s.path+raftStateSuffix, b)
+}
+
+// append adds entries to the log file.
+func (s *raftStorage) append(entries []raftEntry) error {
+	b, err := encodeRaftEntries(entries)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.Write(b); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// rewrite replaces the log file with the given entries, after a truncation or a compaction.
+func (s *raftStorage) rewrite(entries []raftEntry) error {
+	b, err := encodeRaftEntries(entries)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(s.path+raftLogSuffix, b); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path+raftLogSuffix, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.log = f
+	return nil
+}
+
+// close closes the log file.
+func (s *raftStorage) close() error {
+	return s.log.Close()
+}
+
+// encodeRaftEntries encodes entries as JSON lines.
+func encodeRaftEntries(entries []raftEntry) ([]byte, error) {
+	var b []byte
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(b, line...), '\n')
+	}
+	return b, nil
+}
+
+// writeFileSync replaces a file atomically: the data is written and synced to a temporary file,
+// which is then renamed over the file.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + syncTempSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
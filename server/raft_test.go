@@ -0,0 +1,399 @@
+package server
+
+import (
+	"context"
+	db "database/database"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// memTransport delivers the RPCs of the members of a test group in the same process.
+// Members can be cut off from the others to simulate a network partition.
+type memTransport struct {
+	mu    sync.Mutex           // Mutex to protect the fields below.
+	nodes map[string]*raftNode // Members by address.
+	cut   map[string]bool      // Members that can neither send nor receive RPCs.
+}
+
+// memEndpoint is the transport of a single member of a memTransport.
+type memEndpoint struct {
+	t    *memTransport
+	from string
+}
+
+// reach returns the member an RPC from the endpoint is delivered to, or an error if either side is cut off.
+func (e memEndpoint) reach(peer string) (*raftNode, error) {
+	e.t.mu.Lock()
+	defer e.t.mu.Unlock()
+	if e.t.cut[e.from] || e.t.cut[peer] {
+		return nil, fmt.Errorf("%s is unreachable from %s", peer, e.from)
+	}
+	return e.t.nodes[peer], nil
+}
+
+func (e memEndpoint) requestVote(ctx context.Context, peer string, req *voteRequest) (*voteReply, error) {
+	n, err := e.reach(peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleVote(req), nil
+}
+
+func (e memEndpoint) appendEntries(ctx context.Context, peer string, req *appendRequest) (*appendReply, error) {
+	n, err := e.reach(peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleAppend(req), nil
+}
+
+func (e memEndpoint) installSnapshot(ctx context.Context, peer string, req *snapshotRequest) (*snapshotReply, error) {
+	n, err := e.reach(peer)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleSnapshot(req), nil
+}
+
+// testGroup is a Raft group of members running in the test process, each with its own database.
+type testGroup struct {
+	transport *memTransport
+	members   []string
+	nodes     []*raftNode
+	dbs       []*db.DB
+}
+
+// startTestGroup starts a group of size members that stops when the test ends.
+func startTestGroup(t *testing.T, size int) *testGroup {
+	g := &testGroup{transport: &memTransport{nodes: make(map[string]*raftNode), cut: make(map[string]bool)}}
+	for i := 0; i < size; i++ {
+		g.members = append(g.members, fmt.Sprintf("member%d", i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	dir := t.TempDir()
+	for _, id := range g.members {
+		path := filepath.Join(dir, id+".db")
+		database, err := db.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { database.Close(path) })
+		n, err := newRaftNode(id, g.members, memEndpoint{t: g.transport, from: id}, database, applyRaftEntry, path+".raft")
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.transport.nodes[id] = n
+		g.nodes = append(g.nodes, n)
+		g.dbs = append(g.dbs, database)
+	}
+	for _, n := range g.nodes {
+		go n.run(ctx)
+	}
+	return g
+}
+
+// leader waits until a single reachable member leads the group and returns its position.
+func (g *testGroup) leader(t *testing.T) int {
+	t.Helper()
+	found := -1
+	waitFor(t, "a leader", func() bool {
+		found = -1
+		for i, n := range g.nodes {
+			g.transport.mu.Lock()
+			cut := g.transport.cut[n.id]
+			g.transport.mu.Unlock()
+			if role, _, _ := n.status(); role == raftLeader && !cut {
+				if found >= 0 {
+					return false
+				}
+				found = i
+			}
+		}
+		return found >= 0
+	})
+	return found
+}
+
+// setCut cuts a member off the group or reconnects it.
+func (g *testGroup) setCut(i int, cut bool) {
+	g.transport.mu.Lock()
+	defer g.transport.mu.Unlock()
+	g.transport.cut[g.members[i]] = cut
+}
+
+// set proposes a SET through a member and checks that it is applied.
+func (g *testGroup) set(t *testing.T, i int, key, value string) {
+	t.Helper()
+	replies, err := g.nodes[i].propose("default", [][]string{{CommandSET, key, value}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 1 || replies[0].String() != "OK" {
+		t.Fatalf("unexpected replies %v", replies)
+	}
+}
+
+// waitForKey waits until every member with a position in members has applied the key.
+func (g *testGroup) waitForKey(t *testing.T, key, value string, members ...int) {
+	t.Helper()
+	for _, i := range members {
+		waitFor(t, fmt.Sprintf("%s on %s", key, g.members[i]), func() bool {
+			got, ok, _ := g.dbs[i].Get(key)
+			return ok && got == value
+		})
+	}
+}
+
+func TestRaftReplicatesWrites(t *testing.T) {
+	g := startTestGroup(t, 3)
+	leader := g.leader(t)
+	g.set(t, leader, "a", "1")
+	g.waitForKey(t, "a", "1", 0, 1, 2)
+
+	// A batch is applied atomically and replies like EXEC
+	replies, err := g.nodes[leader].propose("default", [][]string{{CommandSET, "b", "2"}, {CommandGET, "b"}, {CommandSET, "a", "again"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 3 || replies[1].String() != "2" || replies[2].Type() != resp.Error {
+		t.Errorf("unexpected batch replies %v", replies)
+	}
+
+	follower := (leader + 1) % 3
+	_, err = g.nodes[follower].propose("default", [][]string{{CommandSET, "c", "3"}})
+	var notLeader *notLeaderError
+	if !errors.As(err, &notLeader) || notLeader.leader != g.members[leader] {
+		t.Errorf("expected the follower to point at %s, got %v", g.members[leader], err)
+	}
+}
+
+func TestRaftFailover(t *testing.T) {
+	g := startTestGroup(t, 5)
+	old := g.leader(t)
+	g.set(t, old, "before", "failover")
+	_, oldTerm, _ := g.nodes[old].status()
+
+	// The remaining majority elects a new leader and keeps accepting writes
+	g.setCut(old, true)
+	lost := make(chan error, 1)
+	go func() {
+		_, err := g.nodes[old].propose("default", [][]string{{CommandSET, "lost", "write"}})
+		lost <- err
+	}()
+	leader := g.leader(t)
+	if _, term, _ := g.nodes[leader].status(); term <= oldTerm {
+		t.Errorf("expected a new term after %d, got %d", oldTerm, term)
+	}
+	g.set(t, leader, "after", "failover")
+
+	// The old leader steps down once it is back, drops its uncommitted write and catches up
+	g.setCut(old, false)
+	select {
+	case err := <-lost:
+		if !errors.Is(err, errLeadershipLost) {
+			t.Errorf("expected the write of the cut off leader to be lost, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the write of the cut off leader never failed")
+	}
+	g.waitForKey(t, "after", "failover", 0, 1, 2, 3, 4)
+	for i, database := range g.dbs {
+		if _, ok, _ := database.Get("lost"); ok {
+			t.Errorf("expected the uncommitted write to be dropped on %s", g.members[i])
+		}
+	}
+}
+
+func TestRaftInstallsSnapshot(t *testing.T) {
+	g := startTestGroup(t, 3)
+	for _, n := range g.nodes {
+		n.mu.Lock()
+		n.compactAt = 8
+		n.mu.Unlock()
+	}
+	leader := g.leader(t)
+	lagging := (leader + 1) % 3
+	g.setCut(lagging, true)
+	for i := 0; i < 20; i++ {
+		g.set(t, leader, fmt.Sprintf("key%02d", i), fmt.Sprint(i))
+	}
+	waitFor(t, "the log to be compacted", func() bool {
+		g.nodes[leader].mu.Lock()
+		defer g.nodes[leader].mu.Unlock()
+		return g.nodes[leader].snapshotIndex > 0
+	})
+
+	// The lagging member is missing compacted entries, so it receives the B-tree file
+	g.setCut(lagging, false)
+	g.waitForKey(t, "key00", "0", lagging)
+	g.waitForKey(t, "key19", "19", lagging)
+	g.nodes[lagging].mu.Lock()
+	installed := g.nodes[lagging].snapshotIndex
+	g.nodes[lagging].mu.Unlock()
+	if installed == 0 {
+		t.Error("expected the lagging member to install a snapshot")
+	}
+}
+
+func TestRaftStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "member.raft")
+	s, state, entries, err := openRaftStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != (raftState{}) || len(entries) != 0 {
+		t.Fatalf("expected empty storage, got %+v and %d entries", state, len(entries))
+	}
+	if err := s.saveState(raftState{Term: 3, VotedFor: "a", SnapshotIndex: 1, SnapshotTerm: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.append([]raftEntry{{Term: 1, Index: 1}, {Term: 2, Index: 2}, {Term: 3, Index: 3, Commands: [][]string{{"SET", "k", "v"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+	// A crash in the middle of an append leaves a torn line behind
+	f, err := os.OpenFile(path+".log", os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"term":3,"ind`)
+	f.Close()
+
+	s, state, entries, err = openRaftStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if state.Term != 3 || state.VotedFor != "a" {
+		t.Errorf("unexpected state %+v", state)
+	}
+	// The entry up to the snapshot is skipped, and so is the torn line
+	if len(entries) != 2 || entries[0].Index != 2 || entries[1].Commands[0][2] != "v" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+func TestRaftRestartKeepsAppliedIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "member.db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close(path) })
+	var mu sync.Mutex
+	applied := make(map[uint64]int)
+	apply := func(tx *db.Tx, e raftEntry) []resp.Value {
+		mu.Lock()
+		applied[e.Index]++
+		mu.Unlock()
+		return applyRaftEntry(tx, e)
+	}
+	// start runs the single member of a group until stop is called
+	start := func() (*testGroup, func()) {
+		g := &testGroup{transport: &memTransport{nodes: make(map[string]*raftNode), cut: make(map[string]bool)}, members: []string{"member0"}}
+		n, err := newRaftNode("member0", g.members, memEndpoint{t: g.transport, from: "member0"}, database, apply, path+".raft")
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.transport.nodes["member0"] = n
+		g.nodes, g.dbs = []*raftNode{n}, []*db.DB{database}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			n.run(ctx)
+			close(done)
+		}()
+		return g, func() {
+			cancel()
+			<-done
+		}
+	}
+
+	g, stop := start()
+	g.leader(t)
+	g.set(t, 0, "a", "1")
+	g.set(t, 0, "b", "2")
+	g.nodes[0].mu.Lock()
+	last := g.nodes[0].lastApplied
+	g.nodes[0].mu.Unlock()
+	stop()
+
+	// The restarted member resumes after the entries it applied instead of applying them again
+	g, stop = start()
+	defer stop()
+	g.nodes[0].mu.Lock()
+	restored := g.nodes[0].lastApplied
+	g.nodes[0].mu.Unlock()
+	if restored != last {
+		t.Errorf("expected the member to resume at index %d, got %d", last, restored)
+	}
+	g.leader(t)
+	g.set(t, 0, "c", "3")
+	mu.Lock()
+	defer mu.Unlock()
+	for index, count := range applied {
+		if count != 1 {
+			t.Errorf("entry %d was applied %d times", index, count)
+		}
+	}
+}
+
+func TestRaftRedirectsClients(t *testing.T) {
+	g := startTestGroup(t, 3)
+	leader := g.leader(t)
+	follower := (leader + 1) % 3
+	waitFor(t, "the follower to know the leader", func() bool {
+		_, _, known := g.nodes[follower].status()
+		return known == g.members[leader]
+	})
+	consensus.mu.Lock()
+	consensus.node = g.nodes[follower]
+	consensus.mu.Unlock()
+	t.Cleanup(stopConsensus)
+
+	c := dialTestClient(t, startTestServer(t))
+	if v := c.do(t, "SET", "k", "v"); v.Type() != resp.Error || v.String() != fmt.Sprintf("MOVED %d %s", keySlot("k"), g.members[leader]) {
+		t.Errorf("expected a redirection to the leader, got %q", v.String())
+	}
+	if v := c.do(t, "GET", "k"); v.Type() == resp.Error {
+		t.Errorf("expected the follower to serve reads, got %q", v.String())
+	}
+	if v := c.do(t, "INFO", "raft").String(); !containsAll(v, "raft_role:follower", "raft_leader:"+g.members[leader]) {
+		t.Errorf("unexpected INFO raft %q", v)
+	}
+
+	// RAFT commands reach the member over the client port
+	reply, err := newTCPTransport().requestVote(context.Background(), c.conn.RemoteAddr().String(), &voteRequest{Term: 0, Candidate: "nobody"})
+	if err != nil || reply.Granted {
+		t.Errorf("expected a stale vote request to be refused, got %+v, %v", reply, err)
+	}
+
+	g.setCut(leader, true)
+	g.setCut(follower, true) // Neither side of the partition has a majority
+	waitFor(t, "the follower to lose the leader", func() bool {
+		_, _, known := g.nodes[follower].status()
+		return known == ""
+	})
+	if v := c.do(t, "SET", "k", "v"); v.Type() != resp.Error || v.String() != "READONLY You can't write against a read only replica." {
+		t.Errorf("expected the write to be refused without a leader, got %q", v.String())
+	}
+}
+
+// containsAll reports whether s contains every one of the substrings.
+func containsAll(s string, substrings ...string) bool {
+	for _, sub := range substrings {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
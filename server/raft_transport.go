@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// raftConn is a connection to another member, used for one RPC at a time.
+type raftConn struct {
+	conn net.Conn
+	rd   *resp.Reader
+}
+
+// tcpTransport sends the RPCs of a member as RAFT commands to the client port of the other members,
+// with the arguments and the reply encoded as JSON. Connections are kept for reuse.
+type tcpTransport struct {
+	mu   sync.Mutex             // Mutex to protect idle.
+	idle map[string][]*raftConn // Idle connections by member address.
+}
+
+// newTCPTransport creates a transport without connections; they are opened on first use.
+func newTCPTransport() *tcpTransport {
+	return &tcpTransport{idle: make(map[string][]*raftConn)}
+}
+
+// requestVote sends a RequestVote RPC.
+func (t *tcpTransport) requestVote(ctx context.Context, peer string, req *voteRequest) (*voteReply, error) {
+	reply := &voteReply{}
+	return reply, t.call(ctx, peer, "VOTE", req, reply)
+}
+
+// appendEntries sends an AppendEntries RPC.
+func (t *tcpTransport) appendEntries(ctx context.Context, peer string, req *appendRequest) (*appendReply, error) {
+	reply := &appendReply{}
+	return reply, t.call(ctx, peer, "APPEND", req, reply)
+}
+
+// installSnapshot sends an InstallSnapshot RPC.
+func (t *tcpTransport) installSnapshot(ctx context.Context, peer string, req *snapshotRequest) (*snapshotReply, error) {
+	reply := &snapshotReply{}
+	return reply, t.call(ctx, peer, "SNAPSHOT", req, reply)
+}
+
+// call sends a RAFT command to a member and decodes its reply. A connection that fails is dropped.
+func (t *tcpTransport) call(ctx context.Context, peer, subcommand string, req, reply any) error {
+	arg, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	c, err := t.get(ctx, peer)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(raftRPCTimeout)
+	}
+	c.conn.SetDeadline(deadline)
+	v, err := c.roundTrip(CommandRAFT, subcommand, string(arg))
+	if err != nil {
+		c.conn.Close()
+		return err
+	}
+	t.put(peer, c)
+	return json.Unmarshal(v.Bytes(), reply)
+}
+
+// get returns an idle connection to a member, or opens one, authenticating with masterauth if it is set.
+func (t *tcpTransport) get(ctx context.Context, peer string) (*raftConn, error) {
+	t.mu.Lock()
+	if idle := t.idle[peer]; len(idle) > 0 {
+		c := idle[len(idle)-1]
+		t.idle[peer] = idle[:len(idle)-1]
+		t.mu.Unlock()
+		return c, nil
+	}
+	t.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: raftRPCTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", peer)
+	if err != nil {
+		return nil, err
+	}
+	c := &raftConn{conn: conn, rd: resp.NewReader(bufio.NewReader(conn))}
+	replication.mu.Lock()
+	auth := replication.masterAuth
+	replication.mu.Unlock()
+	if auth != "" {
+		conn.SetDeadline(time.Now().Add(raftRPCTimeout))
+		if _, err := c.roundTrip(CommandAUTH, auth); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put keeps a connection for reuse.
+func (t *tcpTransport) put(peer string, c *raftConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.idle[peer] = append(t.idle[peer], c)
+}
+
+// roundTrip sends a command and reads its reply, turning an error reply into an error.
+func (c *raftConn) roundTrip(args ...string) (resp.Value, error) {
+	if _, err := c.conn.Write(encodeCommand(args...)); err != nil {
+		return resp.Value{}, err
+	}
+	v, _, err := c.rd.ReadValue()
+	if err != nil {
+		return resp.Value{}, err
+	}
+	if v.Type() == resp.Error {
+		return resp.Value{}, fmt.Errorf("%s: %s", strings.Join(args[:min(2, len(args))], " "), v.String())
+	}
+	return v, nil
+}
+
+// raftCommand executes a RAFT command: an RPC sent by another member of the group, answered with a JSON reply.
+func raftCommand(c RAFTcommand) resp.Value {
+	node := consensusNode()
+	if node == nil {
+		return resp.ErrorValue(fmt.Errorf("ERR This instance has cluster support disabled"))
+	}
+	var reply any
+	switch c.subcommand {
+	case "VOTE":
+		var req voteRequest
+		if err := json.Unmarshal([]byte(c.arg), &req); err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR invalid RAFT VOTE request: %v", err))
+		}
+		reply = node.handleVote(&req)
+	case "APPEND":
+		var req appendRequest
+		if err := json.Unmarshal([]byte(c.arg), &req); err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR invalid RAFT APPEND request: %v", err))
+		}
+		reply = node.handleAppend(&req)
+	case "SNAPSHOT":
+		var req snapshotRequest
+		if err := json.Unmarshal([]byte(c.arg), &req); err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR invalid RAFT SNAPSHOT request: %v", err))
+		}
+		reply = node.handleSnapshot(&req)
+	}
+	b, err := json.Marshal(reply)
+	if err != nil {
+		return resp.ErrorValue(fmt.Errorf("ERR %v", err))
+	}
+	return resp.BytesValue(b)
+}
@@ -0,0 +1,634 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	db "database/database"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	replBacklogSize = 1 << 20          // Bytes of the replication stream kept at least for followers that reconnect
+	replPingPeriod  = 10 * time.Second // How often a leader pings its followers through the stream
+	replAckPeriod   = time.Second      // How often a follower acknowledges the offset it applied
+	replTimeout     = 60 * time.Second // Silence after which a replication link is considered broken
+	replRetryPeriod = time.Second      // Wait before a follower reconnects to its leader
+)
+
+// follower is a follower connected to this server, as reported by ROLE and INFO replication.
+type follower struct {
+	addr      string    // IP address of the follower.
+	port      int       // Port the follower listens on, announced with REPLCONF listening-port.
+	ackOffset int64     // Replication offset the follower acknowledged last.
+	lastAck   time.Time // When the follower acknowledged last.
+}
+
+// replicaLink is the connection of a follower to its leader.
+type replicaLink struct {
+	host   string             // Host of the leader.
+	port   int                // Port of the leader.
+	cancel context.CancelFunc // Stops the link.
+	done   chan struct{}      // Closed once the link stopped.
+	state  string             // State as reported by ROLE: connect, connecting, sync or connected.
+	lastIO time.Time          // When data was last received from the leader.
+}
+
+// replication holds the replication state. The server is a leader, streaming every change applied
+// to its database to its followers, until REPLICAOF makes it a follower of another server.
+// The stream is made of RESP commands; offsets count its bytes since the stream was created.
+var replication = struct {
+	mu            sync.Mutex             // Mutex to protect the fields below.
+	replID        string                 // ID of the stream, shared by a leader and its followers.
+	offset        int64                  // Bytes of the stream produced or received so far.
+	backlog       []byte                 // The most recent bytes of the stream, at least replBacklogSize once that many exist.
+	appended      chan struct{}          // Closed and replaced whenever the stream grows.
+	followers     map[*session]*follower // Followers connected to this server.
+	listeningPort int                    // Port this server listens on, announced to its leader.
+	masterAuth    string                 // Password used to authenticate with the leader.
+	leader        *replicaLink           // Link to the leader; nil while the server is a leader.
+}{
+	replID:    newReplID(),
+	appended:  make(chan struct{}),
+	followers: make(map[*session]*follower),
+}
+
+// newReplID returns a random replication ID of 40 hexadecimal characters, like Redis.
+func newReplID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// encodeCommand encodes a command as a RESP array of bulk strings, the form used in the replication stream.
+func encodeCommand(args ...string) []byte {
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.StringValue(arg)
+	}
+	b, _ := resp.ArrayValue(values).MarshalRESP()
+	return b
+}
+
+// propagate adds a change applied to the database to the replication stream. It is registered as the
+// apply function of the database, so it runs under the write lock in the order the changes are applied.
+// A follower forwards the stream of its leader as received instead.
+func propagate(event db.Event) {
+	replication.mu.Lock()
+	isFollower := replication.leader != nil
+	replication.mu.Unlock()
+	if isFollower {
+		return
+	}
+	switch event.Op {
+	case db.OpPut:
+		appendStream(encodeCommand(CommandSET, event.Key, event.Value))
+	case db.OpDel:
+		appendStream(encodeCommand(CommandDEL, event.Key))
+	}
+}
+
+// appendStream adds bytes to the replication stream and wakes up the followers waiting for them.
+// The backlog is trimmed back to replBacklogSize once it holds twice as much.
+func appendStream(b []byte) {
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	replication.backlog = append(replication.backlog, b...)
+	if len(replication.backlog) > 2*replBacklogSize {
+		replication.backlog = append([]byte(nil), replication.backlog[len(replication.backlog)-replBacklogSize:]...)
+	}
+	replication.offset += int64(len(b))
+	close(replication.appended)
+	replication.appended = make(chan struct{})
+}
+
+// streamFrom returns the bytes of the stream after offset and a channel closed once more are appended.
+// It reports false if the bytes at offset are no longer, or not yet, in the backlog.
+func streamFrom(offset int64) ([]byte, <-chan struct{}, bool) {
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	first := replication.offset - int64(len(replication.backlog))
+	if offset < first || offset > replication.offset {
+		return nil, nil, false
+	}
+	return append([]byte(nil), replication.backlog[offset-first:]...), replication.appended, true
+}
+
+// pingFollowers adds a PING to the stream at every ping period while followers are connected,
+// so they can tell a quiet leader from a broken link. It returns when the context is canceled.
+func pingFollowers(ctx context.Context) {
+	ticker := time.NewTicker(replPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			replication.mu.Lock()
+			ping := len(replication.followers) > 0 && replication.leader == nil
+			replication.mu.Unlock()
+			if ping {
+				appendStream(encodeCommand(CommandPING))
+			}
+		}
+	}
+}
+
+// replconf executes a REPLCONF command sent by a follower. ACK records the offset the follower applied
+// and, like in Redis, gets no reply.
+func (s *session) replconf(c REPLCONFcommand) {
+	switch strings.ToLower(c.args[0]) {
+	case "listening-port":
+		port, err := strconv.Atoi(c.args[1])
+		if err != nil || port < 0 || port > 65535 {
+			s.reply(resp.ErrorValue(fmt.Errorf("ERR Invalid listening port")))
+			return
+		}
+		s.replicaPort = port
+	case "ack":
+		offset, err := strconv.ParseInt(c.args[1], 10, 64)
+		if err != nil {
+			return
+		}
+		replication.mu.Lock()
+		if f := replication.followers[s]; f != nil {
+			f.ackOffset = offset
+			f.lastAck = time.Now()
+		}
+		replication.mu.Unlock()
+		return
+	}
+	s.reply(resp.SimpleStringValue("OK"))
+}
+
+// psync executes a PSYNC command and turns the connection into a follower connection.
+// If the follower asks for an offset of this stream that is still in the backlog, the stream continues
+// from there. Otherwise the follower gets a snapshot of the database, made of SET commands in a bulk string,
+// followed by the stream from the offset the snapshot was taken at.
+func (s *session) psync(c PSYNCcommand) {
+	var replID string
+	var start int64
+	var snapshot *bytes.Buffer
+	err := s.db.Update(func(tx *db.Tx) error {
+		// No change can be applied while the write lock is held, so the snapshot matches the offset.
+		replication.mu.Lock()
+		defer replication.mu.Unlock()
+		replID, start = replication.replID, replication.offset
+		first := replication.offset - int64(len(replication.backlog))
+		if c.replID == replID && c.offset-1 >= first && c.offset-1 <= replication.offset {
+			start = c.offset - 1
+		} else {
+			snapshot = &bytes.Buffer{}
+			err := tx.ForEach(func(key, value string) error {
+				snapshot.Write(encodeCommand(CommandSET, key, value))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		addr, _, _ := net.SplitHostPort(s.conn.RemoteAddr().String())
+		replication.followers[s] = &follower{addr: addr, port: s.replicaPort, ackOffset: start, lastAck: time.Now()}
+		return nil
+	})
+	if err != nil {
+		s.reply(resp.ErrorValue(fmt.Errorf("ERR %v", err)))
+		return
+	}
+	s.replica.Store(true)
+
+	if snapshot == nil {
+		s.log.Info("follower continues replication", "offset", start)
+		s.reply(resp.SimpleStringValue("CONTINUE " + replID))
+	} else {
+		s.log.Info("full resynchronization of follower", "offset", start, "snapshot_bytes", snapshot.Len())
+		s.reply(resp.SimpleStringValue(fmt.Sprintf("FULLRESYNC %s %d", replID, start)))
+		b, _ := resp.BytesValue(snapshot.Bytes()).MarshalRESP()
+		if !s.send(b, classReplica) {
+			return
+		}
+	}
+	go s.feedFollower(start)
+}
+
+// feedFollower sends the replication stream from offset to the follower until the session is closed.
+// A follower that falls behind the backlog is disconnected and has to resynchronize.
+func (s *session) feedFollower(offset int64) {
+	for {
+		chunk, appended, ok := streamFrom(offset)
+		if !ok {
+			s.log.Warn("follower fell behind the replication backlog", "offset", offset)
+			s.kill()
+			return
+		}
+		if len(chunk) > 0 {
+			if !s.send(chunk, classReplica) {
+				return
+			}
+			offset += int64(len(chunk))
+			continue
+		}
+		select {
+		case <-appended:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// removeFollower forgets a follower whose connection is closed.
+func removeFollower(s *session) {
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	delete(replication.followers, s)
+}
+
+// replicaofCommand executes a REPLICAOF command: REPLICAOF NO ONE turns a follower into a leader,
+// REPLICAOF host port makes the server a follower of that leader.
+func replicaofCommand(database *db.DB, c REPLICAOFcommand) resp.Value {
+	if consensusNode() != nil {
+		return resp.ErrorValue(fmt.Errorf("ERR REPLICAOF not allowed in a Raft group"))
+	}
+	if strings.EqualFold(c.host, "no") && strings.EqualFold(c.port, "one") {
+		if stopReplication() {
+			replication.mu.Lock()
+			replication.replID = newReplID() // The stream this server produces from now on is a new one
+			replication.mu.Unlock()
+			logger().Info("replication stopped, serving as leader")
+		}
+		return resp.SimpleStringValue("OK")
+	}
+	port, err := strconv.Atoi(c.port)
+	if err != nil || port <= 0 || port > 65535 {
+		return resp.ErrorValue(fmt.Errorf("ERR Invalid master port"))
+	}
+	replication.mu.Lock()
+	link := replication.leader
+	replication.mu.Unlock()
+	if link != nil && link.host == c.host && link.port == port {
+		return resp.SimpleStringValue("OK Already connected to specified master")
+	}
+	stopReplication()
+	startReplication(database, c.host, port)
+	return resp.SimpleStringValue("OK")
+}
+
+// startReplication makes the server a follower of the leader at host and port.
+func startReplication(database *db.DB, host string, port int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	link := &replicaLink{host: host, port: port, cancel: cancel, done: make(chan struct{}), state: "connect"}
+	replication.mu.Lock()
+	replication.leader = link
+	replication.mu.Unlock()
+	logger().Info("replicating from leader", "leader", net.JoinHostPort(host, strconv.Itoa(port)))
+	go link.run(ctx, database)
+}
+
+// stopReplication stops the link to the leader and waits for it to end.
+// The server stays a follower until then, so no change of the leader is applied twice to the stream.
+// It reports whether the server was a follower.
+func stopReplication() bool {
+	replication.mu.Lock()
+	link := replication.leader
+	replication.mu.Unlock()
+	if link == nil {
+		return false
+	}
+	link.cancel()
+	<-link.done
+	replication.mu.Lock()
+	if replication.leader == link {
+		replication.leader = nil
+	}
+	replication.mu.Unlock()
+	return true
+}
+
+// setState records the state of the link.
+func (link *replicaLink) setState(state string) {
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	link.state = state
+	link.lastIO = time.Now()
+}
+
+// run keeps the link to the leader up until the context is canceled, reconnecting after failures.
+func (link *replicaLink) run(ctx context.Context, database *db.DB) {
+	defer close(link.done)
+	addr := net.JoinHostPort(link.host, strconv.Itoa(link.port))
+	for {
+		err := link.sync(ctx, database)
+		if ctx.Err() != nil {
+			return
+		}
+		logger().Warn("replication link lost", "leader", addr, "err", err)
+		link.setState("connect")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replRetryPeriod):
+		}
+	}
+}
+
+// sync connects to the leader, synchronizes with it and applies its stream until the connection fails.
+func (link *replicaLink) sync(ctx context.Context, database *db.DB) error {
+	link.setState("connecting")
+	dialer := net.Dialer{Timeout: replTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(link.host, strconv.Itoa(link.port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	rd := resp.NewReader(bufio.NewReader(conn))
+
+	call := func(args ...string) (string, error) {
+		conn.SetDeadline(time.Now().Add(replTimeout))
+		if _, err := conn.Write(encodeCommand(args...)); err != nil {
+			return "", err
+		}
+		v, _, err := rd.ReadValue()
+		if err != nil {
+			return "", err
+		}
+		if v.Type() == resp.Error {
+			return "", fmt.Errorf("%s: %s", args[0], v.String())
+		}
+		return v.String(), nil
+	}
+
+	replication.mu.Lock()
+	replID, offset, auth, port := replication.replID, replication.offset, replication.masterAuth, replication.listeningPort
+	replication.mu.Unlock()
+	if auth != "" {
+		if _, err := call(CommandAUTH, auth); err != nil {
+			return err
+		}
+	}
+	if _, err := call(CommandPING); err != nil {
+		return err
+	}
+	if _, err := call(CommandREPLCONF, "listening-port", strconv.Itoa(port)); err != nil {
+		return err
+	}
+	reply, err := call(CommandPSYNC, replID, strconv.FormatInt(offset+1, 10))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid PSYNC reply %q", reply)
+		}
+		link.setState("sync")
+		v, _, err := rd.ReadValue()
+		if err != nil {
+			return err
+		}
+		if err := loadSnapshot(database, v.Bytes(), fields[1], offset); err != nil {
+			return err
+		}
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+	default:
+		return fmt.Errorf("invalid PSYNC reply %q", reply)
+	}
+	link.setState("connected")
+	logger().Info("replication link up", "leader", conn.RemoteAddr().String())
+
+	// Acknowledge the applied offset periodically, which is all the follower writes from now on
+	stopAcks := make(chan struct{})
+	defer close(stopAcks)
+	go func() {
+		ticker := time.NewTicker(replAckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopAcks:
+				return
+			case <-ticker.C:
+				replication.mu.Lock()
+				offset := replication.offset
+				replication.mu.Unlock()
+				conn.SetWriteDeadline(time.Now().Add(replTimeout))
+				conn.Write(encodeCommand(CommandREPLCONF, "ACK", strconv.FormatInt(offset, 10)))
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(replTimeout))
+		v, _, err := rd.ReadValue()
+		if err != nil {
+			return err
+		}
+		if err := applyStream(database, v); err != nil {
+			return err
+		}
+		link.setState("connected")
+	}
+}
+
+// loadSnapshot replaces the contents of the database with a snapshot received from the leader
+// and continues the stream of the leader from offset. Followers of this server are disconnected,
+// since the stream they follow ended.
+func loadSnapshot(database *db.DB, snapshot []byte, replID string, offset int64) error {
+	keys := 0
+	err := database.Update(func(tx *db.Tx) error {
+		if err := tx.Clear(); err != nil {
+			return err
+		}
+		touchAllKeys()
+		flushAllAOF()
+		rd := resp.NewReader(bytes.NewReader(snapshot))
+		for {
+			v, _, err := rd.ReadValue()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			args := v.Array()
+			if len(args) != 3 || !strings.EqualFold(args[0].String(), CommandSET) {
+				return fmt.Errorf("invalid snapshot entry %q", v.String())
+			}
+			if err := tx.Put(args[1].String(), args[2].String()); err != nil {
+				return err
+			}
+			keys++
+		}
+
+		replication.mu.Lock()
+		defer replication.mu.Unlock()
+		replication.replID = replID
+		replication.offset = offset
+		replication.backlog = nil
+		for s := range replication.followers {
+			s.kill()
+		}
+		return nil
+	})
+	if err == nil {
+		logger().Info("loaded snapshot from leader", "keys", keys, "offset", offset)
+	}
+	return err
+}
+
+// applyStream applies a command of the leader's stream to the database and forwards it to the stream
+// of this server, so offsets match the leader's and followers of this server can continue from it.
+func applyStream(database *db.DB, v resp.Value) error {
+	b, err := v.MarshalRESP()
+	if err != nil {
+		return err
+	}
+	args := v.Array()
+	if len(args) == 0 {
+		return fmt.Errorf("invalid replication stream entry %q", v.String())
+	}
+	switch name := strings.ToUpper(args[0].String()); {
+	case name == CommandSET && len(args) == 3:
+		return database.Update(func(tx *db.Tx) error {
+			key := args[1].String()
+			if _, exists, _ := tx.Get(key); exists {
+				tx.Del(key)
+			}
+			if err := tx.Put(key, args[2].String()); err != nil {
+				logger().Warn("applying replicated SET", "key", key, "err", err)
+			}
+			appendStream(b)
+			return nil
+		})
+	case name == CommandDEL && len(args) == 2:
+		return database.Update(func(tx *db.Tx) error {
+			if err := tx.Del(args[1].String()); err != nil {
+				logger().Warn("applying replicated DEL", "key", args[1].String(), "err", err)
+			}
+			appendStream(b)
+			return nil
+		})
+	case name == CommandPING:
+		appendStream(b)
+		return nil
+	}
+	return fmt.Errorf("invalid replication stream entry %q", v.String())
+}
+
+// readOnlyError returns the error a follower replies to commands that modify keys.
+func readOnlyError(cmd command) error {
+	switch cmd.(type) {
+	case SETcommand, DELcommand:
+		replication.mu.Lock()
+		defer replication.mu.Unlock()
+		if replication.leader != nil {
+			return fmt.Errorf("READONLY You can't write against a read only replica.")
+		}
+	}
+	return nil
+}
+
+// setMasterAuth applies a new masterauth value, used the next time the follower connects to its leader.
+func setMasterAuth(value string) error {
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	replication.masterAuth = value
+	return nil
+}
+
+// roleCommand executes a ROLE command, replying in the Redis format.
+func roleCommand() resp.Value {
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	if link := replication.leader; link != nil {
+		return resp.ArrayValue([]resp.Value{
+			resp.StringValue("slave"),
+			resp.StringValue(link.host),
+			resp.IntegerValue(link.port),
+			resp.StringValue(link.state),
+			resp.IntegerValue(int(replication.offset)),
+		})
+	}
+	followers := make([]resp.Value, 0, len(replication.followers))
+	for _, f := range sortedFollowers() {
+		followers = append(followers, resp.ArrayValue([]resp.Value{
+			resp.StringValue(f.addr),
+			resp.StringValue(strconv.Itoa(f.port)),
+			resp.StringValue(strconv.FormatInt(f.ackOffset, 10)),
+		}))
+	}
+	return resp.ArrayValue([]resp.Value{
+		resp.StringValue("master"),
+		resp.IntegerValue(int(replication.offset)),
+		resp.ArrayValue(followers),
+	})
+}
+
+// sortedFollowers returns the followers ordered by session ID; the caller holds the mutex.
+func sortedFollowers() []*follower {
+	sessions := make([]*session, 0, len(replication.followers))
+	for s := range replication.followers {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].id < sessions[j].id })
+	followers := make([]*follower, len(sessions))
+	for i, s := range sessions {
+		followers[i] = replication.followers[s]
+	}
+	return followers
+}
+
+// replicationInfo reports the Replication section: the role of the server, its leader or followers, and the stream.
+func replicationInfo(db.Stats) []string {
+	replication.mu.Lock()
+	defer replication.mu.Unlock()
+	var fields []string
+	if link := replication.leader; link != nil {
+		status, syncing := "down", 0
+		switch link.state {
+		case "connected":
+			status = "up"
+		case "sync":
+			syncing = 1
+		}
+		fields = append(fields,
+			"role:slave",
+			"master_host:"+link.host,
+			fmt.Sprintf("master_port:%d", link.port),
+			"master_link_status:"+status,
+			fmt.Sprintf("master_last_io_seconds_ago:%d", int(time.Since(link.lastIO).Seconds())),
+			fmt.Sprintf("master_sync_in_progress:%d", syncing),
+			fmt.Sprintf("slave_repl_offset:%d", replication.offset),
+			"slave_read_only:1",
+		)
+	} else {
+		fields = append(fields, "role:master")
+	}
+	fields = append(fields, fmt.Sprintf("connected_slaves:%d", len(replication.followers)))
+	for i, f := range sortedFollowers() {
+		fields = append(fields, fmt.Sprintf("slave%d:ip=%s,port=%d,state=online,offset=%d,lag=%d",
+			i, f.addr, f.port, f.ackOffset, int(time.Since(f.lastAck).Seconds())))
+	}
+	first := replication.offset - int64(len(replication.backlog))
+	return append(fields,
+		"master_replid:"+replication.replID,
+		fmt.Sprintf("master_repl_offset:%d", replication.offset),
+		"repl_backlog_active:1",
+		fmt.Sprintf("repl_backlog_size:%d", replBacklogSize),
+		fmt.Sprintf("repl_backlog_first_byte_offset:%d", first+1),
+		fmt.Sprintf("repl_backlog_histlen:%d", len(replication.backlog)),
+	)
+}
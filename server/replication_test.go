@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// startReplicaProcess runs a follower of leader in a child process, through TestReplicaProcess,
+// and returns the address it serves on. The child exits once the test closes its standard input.
+func startReplicaProcess(t *testing.T, leader string) string {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestReplicaProcess$")
+	cmd.Env = append(os.Environ(),
+		"REPLICA_LEADER="+leader,
+		"REPLICA_DB_PATH="+filepath.Join(t.TempDir(), "replica"),
+	)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stdin.Close()
+		done := make(chan struct{})
+		go func() {
+			cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+			<-done
+		}
+	})
+	addr, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading the follower address: %v", err)
+	}
+	go io.Copy(io.Discard, stdout)
+	return strings.TrimSpace(addr)
+}
+
+// TestReplicaProcess is the follower process started by startReplicaProcess; it is skipped otherwise.
+func TestReplicaProcess(t *testing.T) {
+	leader := os.Getenv("REPLICA_LEADER")
+	if leader == "" {
+		t.Skip("only runs as the follower process of TestReplication")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println(l.Addr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		cancel()
+	}()
+	cfg := Config{Listeners: []net.Listener{l}, DBPath: os.Getenv("REPLICA_DB_PATH"), ReplicaOf: leader}
+	if err := Client(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a follower process")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Client(ctx, Config{Listeners: []net.Listener{l}, DBPath: filepath.Join(t.TempDir(), "leader")})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	})
+
+	leader := dialTestClient(t, l.Addr().String())
+	for _, kv := range [][2]string{{"a", "1"}, {"b", "2"}} {
+		if v := leader.do(t, "SET", kv[0], kv[1]); v.String() != "OK" {
+			t.Fatalf("SET %s: %q", kv[0], v.String())
+		}
+	}
+
+	followerAddr := startReplicaProcess(t, l.Addr().String())
+	follower := dialTestClient(t, followerAddr)
+	get := func(key string) string {
+		return follower.do(t, "GET", key).String()
+	}
+
+	// The keys set before the follower connected arrive with the full synchronization
+	waitFor(t, "the full synchronization", func() bool { return get("a") == "1" })
+	if got := get("b"); got != "2" {
+		t.Errorf("expected b to be synchronized, got %q", got)
+	}
+
+	// Later changes arrive through the stream
+	leader.do(t, "SET", "c", "3")
+	leader.do(t, "DEL", "a")
+	waitFor(t, "the incremental changes", func() bool { return get("c") == "3" && get("a") == "" })
+
+	// A change replicated to a key watched on the follower aborts the transaction watching it
+	watcher := dialTestClient(t, followerAddr)
+	watcher.do(t, "WATCH", "w")
+	watcher.do(t, "MULTI")
+	watcher.do(t, "GET", "w")
+	leader.do(t, "SET", "w", "1")
+	waitFor(t, "the watched key", func() bool { return get("w") == "1" })
+	if v := watcher.do(t, "EXEC"); !v.IsNull() {
+		t.Errorf("expected a replicated SET to abort EXEC on the follower, got %v", v)
+	}
+
+	if v := follower.do(t, "SET", "x", "1"); v.Type() != resp.Error || !strings.HasPrefix(v.String(), "READONLY") {
+		t.Errorf("expected the follower to refuse writes, got %q", v.String())
+	}
+
+	role := leader.do(t, "ROLE").Array()
+	if len(role) != 3 || role[0].String() != "master" || len(role[2].Array()) != 1 {
+		t.Errorf("unexpected leader role %v", role)
+	}
+	role = follower.do(t, "ROLE").Array()
+	if len(role) != 5 || role[0].String() != "slave" || role[3].String() != "connected" {
+		t.Errorf("unexpected follower role %v", role)
+	}
+	// The follower acknowledges the offset it applied, which catches up with the leader's
+	waitFor(t, "the follower acknowledgement", func() bool {
+		role := leader.do(t, "ROLE").Array()
+		followers := role[2].Array()
+		return len(followers) == 1 && followers[0].Array()[2].String() == fmt.Sprint(role[1].Integer())
+	})
+
+	info := follower.do(t, "INFO", "replication").String()
+	for _, field := range []string{"role:slave", "master_link_status:up", "slave_read_only:1"} {
+		if !strings.Contains(info, field) {
+			t.Errorf("expected %q in the follower INFO, got %q", field, info)
+		}
+	}
+	if info := leader.do(t, "INFO", "replication").String(); !strings.Contains(info, "role:master") || !strings.Contains(info, "connected_slaves:1") {
+		t.Errorf("unexpected leader INFO %q", info)
+	}
+
+	// REPLICAOF NO ONE promotes the follower, which accepts writes again
+	if v := follower.do(t, "REPLICAOF", "NO", "ONE"); v.String() != "OK" {
+		t.Fatalf("REPLICAOF NO ONE: %q", v.String())
+	}
+	if v := follower.do(t, "SET", "x", "1"); v.String() != "OK" {
+		t.Errorf("expected the promoted follower to accept writes, got %q", v.String())
+	}
+	if role := follower.do(t, "ROLE").Array(); role[0].String() != "master" {
+		t.Errorf("expected the promoted follower to be a leader, got %v", role)
+	}
+}
+
+func TestReplicationReadOnlyInsideTransactions(t *testing.T) {
+	replication.mu.Lock()
+	replication.leader = &replicaLink{host: "127.0.0.1", port: 1, state: "connect"}
+	replication.mu.Unlock()
+	defer func() {
+		replication.mu.Lock()
+		replication.leader = nil
+		replication.mu.Unlock()
+	}()
+	c := dialTestClient(t, startTestServer(t))
+
+	c.do(t, "MULTI")
+	c.do(t, "SET", "k", "v")
+	reply := c.do(t, "EXEC").Array()
+	if len(reply) != 1 || !strings.HasPrefix(reply[0].String(), "READONLY") {
+		t.Errorf("expected SET to be refused inside EXEC, got %v", reply)
+	}
+	if v := c.do(t, "MULTI"); v.String() != "OK" {
+		t.Fatal(v.String())
+	}
+	if v := c.do(t, "REPLCONF", "ACK", "0"); v.Type() != resp.Error {
+		t.Errorf("expected REPLCONF to be refused inside MULTI, got %q", v.String())
+	}
+}
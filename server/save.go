@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bufio"
+	db "database/database"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// persistence tracks the backups of the database written by SAVE and BGSAVE.
+var persistence = struct {
+	mu           sync.Mutex    // Mutex to protect the fields below.
+	saving       bool          // Whether a save is running.
+	lastSave     time.Time     // When the last successful save finished, or when the server started.
+	lastErr      error         // Error of the last save; nil if it succeeded.
+	lastDuration time.Duration // How long the last save took.
+	dir          string        // Directory backups are written to, the dir parameter.
+	filename     string        // Name of the backup file in dir, the dbfilename parameter.
+	dbPath       string        // Path of the database file, which backups must not overwrite; empty before the server starts.
+}{
+	lastSave: time.Now(),
+	filename: "dump.db",
+}
+
+// setBackupDir applies a new dir value: the directory SAVE and BGSAVE write backups to, which must exist.
+func setBackupDir(value string) error {
+	fi, err := os.Stat(value)
+	if err != nil || !fi.IsDir() {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument 'dir') - No such directory: %s", value)
+	}
+	persistence.mu.Lock()
+	defer persistence.mu.Unlock()
+	if err := checkBackupPath(filepath.Join(value, persistence.filename)); err != nil {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument 'dir') - %v", err)
+	}
+	persistence.dir = value
+	return nil
+}
+
+// setBackupFilename applies a new dbfilename value: the name of the backup file in dir.
+func setBackupFilename(value string) error {
+	if value == "" || strings.ContainsRune(value, os.PathSeparator) || value != filepath.Base(value) {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument 'dbfilename') - dbfilename can't be a path, just a filename")
+	}
+	persistence.mu.Lock()
+	defer persistence.mu.Unlock()
+	if err := checkBackupPath(filepath.Join(persistence.dir, value)); err != nil {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument 'dbfilename') - %v", err)
+	}
+	persistence.filename = value
+	return nil
+}
+
+// setDatabasePath records the path of the database file served, so backups cannot overwrite it.
+func setDatabasePath(path string) {
+	persistence.mu.Lock()
+	defer persistence.mu.Unlock()
+	persistence.dbPath = path
+}
+
+// databaseFileSuffixes are the suffixes of the files kept next to the database file: the append-only file,
+// the temporary files of its rewrite, of COMPACT and of a bulk load, the Raft state and log with their
+// temporary files, and the cluster nodes file.
+var databaseFileSuffixes = []string{
+	aofSuffix, aofSuffix + aofRewriteSuffix,
+	db.CompactSuffix, db.LoadSuffix,
+	raftSuffix + raftStateSuffix, raftSuffix + raftStateSuffix + syncTempSuffix,
+	raftSuffix + raftLogSuffix, raftSuffix + raftLogSuffix + syncTempSuffix,
+	clusterNodesSuffix, clusterNodesSuffix + syncTempSuffix,
+}
+
+// checkBackupPath returns an error if a backup written to path would replace the database file or one of
+// the files kept next to it. The caller holds the persistence lock.
+func checkBackupPath(path string) error {
+	dbPath := persistence.dbPath
+	if dbPath == "" {
+		return nil
+	}
+	for _, suffix := range append([]string{""}, databaseFileSuffixes...) {
+		if samePath(path, dbPath+suffix) {
+			return fmt.Errorf("the backup file %s would overwrite %s", path, dbPath+suffix)
+		}
+	}
+	return nil
+}
+
+// samePath reports whether two paths name the same file, either by resolving to the same absolute path
+// or by linking to the same existing file.
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA == nil && errB == nil && absA == absB {
+		return true
+	}
+	fa, errA := os.Stat(a)
+	fb, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(fa, fb)
+}
+
+// backupPath returns the path of the backup file, in dir and named by dbfilename.
+func backupPath() string {
+	persistence.mu.Lock()
+	defer persistence.mu.Unlock()
+	return filepath.Join(persistence.dir, persistence.filename)
+}
+
+// beginSave marks a save as running, or reports that one already is.
+func beginSave() error {
+	persistence.mu.Lock()
+	defer persistence.mu.Unlock()
+	if persistence.saving {
+		return fmt.Errorf("ERR Background save already in progress")
+	}
+	persistence.saving = true
+	return nil
+}
+
+// endSave records the outcome of a save.
+func endSave(start time.Time, err error) {
+	persistence.mu.Lock()
+	defer persistence.mu.Unlock()
+	persistence.saving = false
+	persistence.lastErr = err
+	persistence.lastDuration = time.Since(start)
+	if err == nil {
+		persistence.lastSave = time.Now()
+	}
+}
+
+// save writes a backup of the database to the backup file. The backup goes to a temporary file first,
+// which is synced and renamed over the backup file, so a failed save leaves the previous backup intact.
+// A backup file that would replace the database file or the files next to it is refused.
+func save(database *db.DB) error {
+	persistence.mu.Lock()
+	path := filepath.Join(persistence.dir, persistence.filename)
+	err := checkBackupPath(path)
+	persistence.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d.db", os.Getpid()))
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = database.Backup(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// saveCommand executes a SAVE command: the backup is written before the reply, while the other
+// clients keep running their commands.
+func saveCommand(database *db.DB) resp.Value {
+	if err := beginSave(); err != nil {
+		return resp.ErrorValue(err)
+	}
+	start := time.Now()
+	err := save(database)
+	endSave(start, err)
+	if err != nil {
+		logger().Warn("saving the database failed", "path", backupPath(), "err", err)
+		return resp.ErrorValue(fmt.Errorf("ERR %v", err))
+	}
+	logger().Info("database saved", "path", backupPath(), "duration", time.Since(start))
+	return resp.SimpleStringValue("OK")
+}
+
+// bgsaveCommand executes a BGSAVE command: the backup is written in the background, and its outcome
+// is reported by LASTSAVE and the persistence section of INFO.
+func bgsaveCommand(database *db.DB) resp.Value {
+	if err := beginSave(); err != nil {
+		return resp.ErrorValue(err)
+	}
+	go func() {
+		start := time.Now()
+		err := save(database)
+		endSave(start, err)
+		if err != nil {
+			logger().Warn("background saving failed", "path", backupPath(), "err", err)
+			return
+		}
+		logger().Info("background saving terminated with success", "path", backupPath(), "duration", time.Since(start))
+	}()
+	return resp.SimpleStringValue("Background saving started")
+}
+
+// lastsaveCommand executes a LASTSAVE command, replying with the Unix time of the last successful save.
+func lastsaveCommand() resp.Value {
+	persistence.mu.Lock()
+	defer persistence.mu.Unlock()
+	return resp.IntegerValue(int(persistence.lastSave.Unix()))
+}
+
+// saveInfo reports the state of the backups for the persistence section of INFO.
+func saveInfo() []string {
+	persistence.mu.Lock()
+	defer persistence.mu.Unlock()
+	status := "ok"
+	if persistence.lastErr != nil {
+		status = "err"
+	}
+	inProgress := 0
+	if persistence.saving {
+		inProgress = 1
+	}
+	return []string{
+		"rdb_bgsave_in_progress:" + strconv.Itoa(inProgress),
+		fmt.Sprintf("rdb_last_save_time:%d", persistence.lastSave.Unix()),
+		"rdb_last_bgsave_status:" + status,
+		fmt.Sprintf("rdb_last_bgsave_time_sec:%d", int(persistence.lastDuration.Seconds())),
+	}
+}
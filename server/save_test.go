@@ -0,0 +1,125 @@
+package server
+
+import (
+	db "database/database"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+func TestSaveAndBgsave(t *testing.T) {
+	dir := t.TempDir()
+	setConfig(t, "dir", dir, os.TempDir())
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	for i := 0; i < 50; i++ {
+		c.do(t, "SET", fmt.Sprint("key", i), fmt.Sprint(i))
+	}
+
+	before := c.do(t, "LASTSAVE").Integer()
+	if v := c.do(t, "SAVE"); v.String() != "OK" {
+		t.Fatalf("unexpected SAVE reply %q", v.String())
+	}
+	if v := c.do(t, "LASTSAVE").Integer(); v < before {
+		t.Errorf("expected LASTSAVE to move forward from %d, got %d", before, v)
+	}
+	checkBackup(t, filepath.Join(dir, "dump.db"), 50)
+
+	// BGSAVE replies right away and writes the backup under the configured name
+	setConfig(t, "dbfilename", "background.db", "dump.db")
+	c.do(t, "SET", "key50", "50")
+	if v := c.do(t, "BGSAVE"); v.String() != "Background saving started" {
+		t.Fatalf("unexpected BGSAVE reply %q", v.String())
+	}
+	waitFor(t, "the background save", func() bool {
+		return containsAll(c.do(t, "INFO", "persistence").String(), "rdb_bgsave_in_progress:0", "rdb_last_bgsave_status:ok")
+	})
+	checkBackup(t, filepath.Join(dir, "background.db"), 51)
+
+	if v := c.do(t, "CONFIG", "SET", "dbfilename", "../escape.db"); v.Type() != resp.Error {
+		t.Errorf("expected a path to be refused as dbfilename, got %q", v.String())
+	}
+	if v := c.do(t, "CONFIG", "SET", "dir", filepath.Join(dir, "missing")); v.Type() != resp.Error {
+		t.Errorf("expected a missing directory to be refused, got %q", v.String())
+	}
+	c.do(t, "MULTI")
+	if v := c.do(t, "SAVE"); v.Type() != resp.Error {
+		t.Errorf("expected SAVE to be refused in a transaction, got %q", v.String())
+	}
+	c.do(t, "DISCARD")
+}
+
+func TestSaveInProgress(t *testing.T) {
+	if err := beginSave(); err != nil {
+		t.Fatal(err)
+	}
+	if v := bgsaveCommand(nil); v.String() != "ERR Background save already in progress" {
+		t.Errorf("expected a second save to be refused, got %q", v.String())
+	}
+	endSave(time.Now(), nil)
+}
+
+// checkBackup opens a backup file as a database and checks that it holds the expected number of keys.
+func checkBackup(t *testing.T, path string, keys int) {
+	t.Helper()
+	backup, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close(path)
+	n := 0
+	backup.ForEach(func(key, value string) error {
+		n++
+		return nil
+	})
+	if n != keys {
+		t.Errorf("expected %d keys in %s, got %d", keys, path, n)
+	}
+}
+
+func TestSaveRefusesDatabaseFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db")
+	database, err := db.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close(path)
+	database.Put("key", "value")
+	setConfig(t, "dir", dir, os.TempDir())
+	setDatabasePath(path)
+	t.Cleanup(func() { setDatabasePath("") })
+
+	// The backup file may be neither the database file nor a file kept next to it
+	for _, name := range []string{"db", "db.aof", "db.compact", "db.raft.state", "db.raft.state.tmp", "db.raft.log", "db.nodes"} {
+		if err := configSet("dbfilename", name); err == nil {
+			configSet("dbfilename", "dump.db")
+			t.Errorf("expected dbfilename %s to be refused", name)
+		}
+	}
+	if v := saveCommand(database); v.String() != "OK" {
+		t.Fatalf("unexpected SAVE reply %q", v.String())
+	}
+	checkBackup(t, filepath.Join(dir, "dump.db"), 1)
+
+	// A dir changed to the directory of the database after dbfilename is checked too
+	other := t.TempDir()
+	setConfig(t, "dir", other, dir)
+	setConfig(t, "dbfilename", "db", "dump.db")
+	if err := configSet("dir", dir); err == nil {
+		t.Error("expected a dir holding the database file under dbfilename to be refused")
+	}
+
+	// SAVE checks again, in case the database moved under the backup file
+	setDatabasePath(filepath.Join(other, "db"))
+	if v := saveCommand(database); v.Type() != resp.Error {
+		t.Errorf("expected SAVE over the database file to be refused, got %q", v.String())
+	}
+	if value, ok, err := database.Get("key"); err != nil || !ok || value != "value" {
+		t.Errorf("expected the database to keep its contents, got %q, %v, %v", value, ok, err)
+	}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const scriptTimeout = 5 * time.Second // Longest a script may hold the database write lock
+
+// scripts caches the bodies of the scripts loaded with SCRIPT LOAD or run with EVAL, by SHA1 digest.
+var scripts = struct {
+	mu     sync.RWMutex      // Mutex to protect bodies.
+	bodies map[string]string // Script bodies by lower-case hex SHA1 digest.
+}{
+	bodies: make(map[string]string),
+}
+
+// loadScript caches a script body and returns its SHA1 digest.
+func loadScript(body string) string {
+	sum := sha1.Sum([]byte(body))
+	sha := hex.EncodeToString(sum[:])
+	scripts.mu.Lock()
+	defer scripts.mu.Unlock()
+	scripts.bodies[sha] = body
+	return sha
+}
+
+// scriptCommand executes a SCRIPT LOAD, EXISTS or FLUSH command and returns the reply.
+func scriptCommand(c SCRIPTcommand) resp.Value {
+	switch c.subcommand {
+	case "LOAD":
+		L := lua.NewState(lua.Options{SkipOpenLibs: true})
+		defer L.Close()
+		if _, err := L.LoadString(c.args[0]); err != nil {
+			return resp.ErrorValue(fmt.Errorf("ERR Error compiling script: %v", err))
+		}
+		return resp.StringValue(loadScript(c.args[0]))
+	case "EXISTS":
+		scripts.mu.RLock()
+		defer scripts.mu.RUnlock()
+		values := make([]resp.Value, len(c.args))
+		for i, sha := range c.args {
+			_, ok := scripts.bodies[strings.ToLower(sha)]
+			values[i] = resp.BoolValue(ok)
+		}
+		return resp.ArrayValue(values)
+	}
+	// FLUSH
+	scripts.mu.Lock()
+	defer scripts.mu.Unlock()
+	scripts.bodies = make(map[string]string)
+	return resp.SimpleStringValue("OK")
+}
+
+// evalSHA runs a cached script by its SHA1 digest.
+func (s *session) evalSHA(st store, c EVALSHAcommand) resp.Value {
+	scripts.mu.RLock()
+	body, ok := scripts.bodies[strings.ToLower(c.sha)]
+	scripts.mu.RUnlock()
+	if !ok {
+		return resp.ErrorValue(fmt.Errorf("NOSCRIPT No matching script. Please use EVAL."))
+	}
+	return s.eval(st, body, c.keys, c.args)
+}
+
+// eval runs a Lua script against st with the KEYS and ARGV tables set up like Redis does.
+// The caller holds the database write lock, so everything the script does through
+// redis.call and redis.pcall is atomic.
+func (s *session) eval(st store, body string, keys, args []string) resp.Value {
+	L := newScriptState()
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+
+	L.SetGlobal("KEYS", stringsToTable(L, keys))
+	L.SetGlobal("ARGV", stringsToTable(L, args))
+	redis := L.NewTable()
+	L.SetField(redis, "call", L.NewFunction(func(L *lua.LState) int { return s.scriptCall(L, st, true) }))
+	L.SetField(redis, "pcall", L.NewFunction(func(L *lua.LState) int { return s.scriptCall(L, st, false) }))
+	L.SetField(redis, "status_reply", L.NewFunction(func(L *lua.LState) int {
+		L.Push(replyTable(L, "ok", L.CheckString(1)))
+		return 1
+	}))
+	L.SetField(redis, "error_reply", L.NewFunction(func(L *lua.LState) int {
+		L.Push(replyTable(L, "err", L.CheckString(1)))
+		return 1
+	}))
+	L.SetGlobal("redis", redis)
+
+	// Like SCRIPT LOAD, only a script that compiles is cached
+	fn, err := L.LoadString(body)
+	if err != nil {
+		return resp.ErrorValue(fmt.Errorf("ERR Error compiling script: %v", err))
+	}
+	loadScript(body)
+	L.Push(fn)
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		return resp.ErrorValue(fmt.Errorf("ERR Error running script: %v", err))
+	}
+	if L.GetTop() == 0 {
+		return resp.NullValue()
+	}
+	return luaToResp(L.Get(-1))
+}
+
+// scriptCall implements redis.call (raise is true) and redis.pcall (raise is false).
+// The command goes through the same parser and dispatcher as commands sent by clients.
+func (s *session) scriptCall(L *lua.LState, st store, raise bool) int {
+	values := make([]resp.Value, L.GetTop())
+	for i := range values {
+		arg := L.Get(i + 1)
+		switch arg.Type() {
+		case lua.LTString, lua.LTNumber:
+			values[i] = resp.StringValue(arg.String())
+		default:
+			L.RaiseError("Lua redis() command arguments must be strings or integers")
+			return 0
+		}
+	}
+
+	var reply resp.Value
+	cmd, err := parseArray(values)
+	switch {
+	case err != nil:
+		reply = resp.ErrorValue(fmt.Errorf("ERR %v", err))
+	case !allowedInScript(cmd):
+		reply = resp.ErrorValue(fmt.Errorf("ERR This Redis command is not allowed from script"))
+	default:
+		if err := checkPermission(s.user, commandName(values), cmd); err != nil {
+			reply = resp.ErrorValue(err)
+			break
+		}
+		feedMonitors("lua", values)
+		reply = s.execute(st, cmd)
+	}
+
+	if reply.Type() == resp.Error && raise {
+		L.RaiseError("%s", reply.String())
+		return 0
+	}
+	L.Push(respToLua(L, reply))
+	return 1
+}
+
+// allowedInScript reports whether a command may be called from a script.
+// Commands that change the connection state or run scripts themselves are refused.
+func allowedInScript(cmd command) bool {
+	if isTransactionCommand(cmd) || allowedWhileSubscribed(cmd) {
+		_, ping := cmd.(PINGcommand)
+		return ping
+	}
+	switch cmd.(type) {
+	case EVALcommand, EVALSHAcommand, SCRIPTcommand, AUTHcommand, ACLcommand, CLIENTcommand, MONITORcommand,
+		REPLICAOFcommand, ROLEcommand, PSYNCcommand, REPLCONFcommand, RAFTcommand, CLUSTERcommand, ASKINGcommand,
+		MIGRATEcommand, SAVEcommand, BGSAVEcommand, COMPACTcommand, BGREWRITEAOFcommand:
+		return false
+	}
+	return true
+}
+
+// newScriptState creates a Lua interpreter with only the side-effect free standard libraries.
+func newScriptState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// Scripts must not load code from disk.
+	for _, name := range []string{"dofile", "loadfile", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+// stringsToTable converts a slice of strings into a Lua array.
+func stringsToTable(L *lua.LState, values []string) *lua.LTable {
+	table := L.NewTable()
+	for _, value := range values {
+		table.Append(lua.LString(value))
+	}
+	return table
+}
+
+// replyTable builds the {ok=...} or {err=...} table used for status and error replies.
+func replyTable(L *lua.LState, field, message string) *lua.LTable {
+	table := L.NewTable()
+	L.SetField(table, field, lua.LString(message))
+	return table
+}
+
+// respToLua converts a command reply into a Lua value following the Redis conversion rules.
+func respToLua(L *lua.LState, v resp.Value) lua.LValue {
+	switch v.Type() {
+	case resp.Integer:
+		return lua.LNumber(v.Integer())
+	case resp.SimpleString:
+		return replyTable(L, "ok", v.String())
+	case resp.Error:
+		return replyTable(L, "err", v.String())
+	case resp.Array:
+		table := L.NewTable()
+		for _, item := range v.Array() {
+			table.Append(respToLua(L, item))
+		}
+		return table
+	}
+	if v.IsNull() {
+		return lua.LFalse
+	}
+	return lua.LString(v.String())
+}
+
+// luaToResp converts the value returned by a script into a reply following the Redis conversion rules.
+func luaToResp(v lua.LValue) resp.Value {
+	switch value := v.(type) {
+	case lua.LNumber:
+		return resp.IntegerValue(int(value))
+	case lua.LString:
+		return resp.StringValue(string(value))
+	case lua.LBool:
+		if value {
+			return resp.IntegerValue(1)
+		}
+		return resp.NullValue()
+	case *lua.LTable:
+		if ok := value.RawGetString("ok"); ok.Type() == lua.LTString {
+			return resp.SimpleStringValue(ok.String())
+		}
+		if err := value.RawGetString("err"); err.Type() == lua.LTString {
+			return resp.ErrorValue(fmt.Errorf("%s", err.String()))
+		}
+		// Like Redis, the array stops at the first nil element.
+		var values []resp.Value
+		for i := 1; ; i++ {
+			item := value.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			values = append(values, luaToResp(item))
+		}
+		return resp.ArrayValue(values)
+	}
+	return resp.NullValue()
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+// evalArgs parses an EVAL-style command line and runs it through the session like a client would.
+func evalArgs(t *testing.T, s *session, args ...string) resp.Value {
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.StringValue(arg)
+	}
+	cmd, err := parseArray(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.run(cmd)
+}
+
+func TestEvalCallsCommands(t *testing.T) {
+	database := openTestDB(t)
+	s := newSession(nil, database)
+
+	script := `redis.call("SET", KEYS[1], ARGV[1]) return redis.call("GET", KEYS[1])`
+	if reply := evalArgs(t, s, "EVAL", script, "1", "greeting", "hello"); reply.String() != "hello" {
+		t.Errorf("unexpected EVAL reply %v", reply)
+	}
+	if value, _, _ := database.Get("greeting"); value != "hello" {
+		t.Errorf("script should have stored the value, got %q", value)
+	}
+}
+
+func TestEvalConversions(t *testing.T) {
+	s := newSession(nil, openTestDB(t))
+
+	reply := evalArgs(t, s, "EVAL", `return {1, "two", {ok="fine"}, false, 5}`, "0")
+	items := reply.Array()
+	if len(items) != 5 || items[0].Integer() != 1 || items[1].String() != "two" ||
+		items[2].Type() != resp.SimpleString || !items[3].IsNull() {
+		t.Errorf("unexpected conversion %v", items)
+	}
+
+	// redis.call raises errors while redis.pcall returns them as a table.
+	if reply := evalArgs(t, s, "EVAL", `return redis.call("GET")`, "0"); reply.Type() != resp.Error {
+		t.Errorf("redis.call should raise the command error, got %v", reply)
+	}
+	reply = evalArgs(t, s, "EVAL", `local r = redis.pcall("GET") return r.err ~= nil`, "0")
+	if reply.Integer() != 1 {
+		t.Errorf("redis.pcall should return the error as a table, got %v", reply)
+	}
+	if reply := evalArgs(t, s, "EVAL", `return redis.call("MULTI")`, "0"); reply.Type() != resp.Error {
+		t.Error("transaction commands should not be allowed from scripts")
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	s := newSession(nil, openTestDB(t))
+
+	sha := evalArgs(t, s, "SCRIPT", "LOAD", "return ARGV[1]").String()
+	if len(sha) != 40 {
+		t.Fatalf("unexpected digest %q", sha)
+	}
+	if reply := evalArgs(t, s, "EVALSHA", sha, "0", "cached"); reply.String() != "cached" {
+		t.Errorf("unexpected EVALSHA reply %v", reply)
+	}
+	exists := evalArgs(t, s, "SCRIPT", "EXISTS", sha, "0000").Array()
+	if exists[0].Integer() != 1 || exists[1].Integer() != 0 {
+		t.Errorf("unexpected SCRIPT EXISTS reply %v", exists)
+	}
+
+	evalArgs(t, s, "SCRIPT", "FLUSH")
+	if reply := evalArgs(t, s, "EVALSHA", sha, "0"); reply.Type() != resp.Error {
+		t.Error("EVALSHA should fail after SCRIPT FLUSH")
+	}
+	if reply := evalArgs(t, s, "SCRIPT", "LOAD", "return ("); reply.Type() != resp.Error {
+		t.Error("SCRIPT LOAD should reject scripts that do not compile")
+	}
+
+	// EVAL caches the scripts it runs, but not one that does not compile
+	evalArgs(t, s, "EVAL", "return 1", "0")
+	if reply := evalArgs(t, s, "EVAL", "return (", "0"); reply.Type() != resp.Error {
+		t.Error("EVAL should reject scripts that do not compile")
+	}
+	exists = evalArgs(t, s, "SCRIPT", "EXISTS", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", "728acb63e2aaef0ee859ece5db586bff5d800d1e").Array()
+	if exists[0].Integer() != 1 || exists[1].Integer() != 0 {
+		t.Errorf("expected only the script that compiles to be cached, got %v", exists)
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	db "database/database"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+const (
+	outputBufferSize   = 1024             // Maximum number of replies queued for a client before it is considered slow
+	clientWriteTimeout = 60 * time.Second // Time a write may block before the client is closed, unless the idle timeout is set
+)
+
+// session holds the per-connection state of a client.
+// All replies are queued on a bounded output buffer and written to the connection
+// by a dedicated goroutine, so publishers never block on a slow subscriber.
+// The bytes queued but not yet written are checked against the output buffer limits.
+type session struct {
+	conn        net.Conn            // The underlying client connection.
+	db          *db.DB              // The database the client operates on.
+	out         chan []byte         // Bounded output buffer of encoded replies drained by writeLoop.
+	outBytes    atomic.Int64        // Bytes queued on the output buffer and not yet written.
+	softOver    atomic.Int64        // Unix nanoseconds since which the soft output buffer limit is exceeded; 0 if it is not.
+	done        chan struct{}       // Closed when the session is torn down.
+	once        sync.Once           // Guards closing done.
+	channels    map[string]struct{} // Channels the client is subscribed to.
+	patterns    map[string]struct{} // Patterns the client is subscribed to.
+	multi       bool                // Whether commands are being queued since MULTI.
+	multiErr    bool                // Whether a command failed to queue, which aborts EXEC.
+	queued      []command           // Commands queued for EXEC.
+	watching    map[string]struct{} // Keys watched for optimistic locking.
+	dirty       atomic.Bool         // Set when a watched key is modified.
+	monitoring  atomic.Bool         // Set once the client ran MONITOR.
+	replica     atomic.Bool         // Set once the client ran PSYNC and receives the replication stream.
+	replicaPort int                 // Port the follower listens on, announced with REPLCONF listening-port.
+	asking      bool                // Set by ASKING for the next command, which may then access an importing slot.
+	user        string              // The ACL user the client is authenticated as; empty until AUTH.
+	id          int64               // Unique ID of the connection, as reported by CLIENT ID.
+	created     time.Time           // When the connection was accepted.
+	statsMu     sync.Mutex          // Guards stats.
+	stats       clientStats         // What CLIENT LIST reports about the client.
+	log         *slog.Logger        // Logger with the connection ID attached.
+}
+
+// newSession creates the state for a freshly accepted connection.
+func newSession(conn net.Conn, database *db.DB) *session {
+	id := clients.nextID.Add(1)
+	return &session{
+		conn:     conn,
+		db:       database,
+		out:      make(chan []byte, outputBufferSize),
+		done:     make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		watching: make(map[string]struct{}),
+		user:     initialUser(),
+		id:       id,
+		created:  time.Now(),
+		stats:    clientStats{lastActive: time.Now(), multi: -1},
+		log:      logger().With("conn", id),
+	}
+}
+
+// reply queues a command reply for the client, waiting for room in the output buffer.
+// It returns false if the session was closed in the meantime or the normal class output buffer limit was exceeded.
+func (s *session) reply(v resp.Value) bool {
+	b, err := v.MarshalRESP()
+	if err != nil {
+		return false
+	}
+	return s.send(b, classNormal)
+}
+
+// send queues encoded bytes for the client, waiting for room in the output buffer, and accounts them
+// against the output buffer limit of the class. Replies use it through reply, the replication stream directly.
+func (s *session) send(b []byte, class string) bool {
+	if !s.account(b, class) {
+		return false
+	}
+	select {
+	case s.out <- b:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// push queues an out-of-band message such as a published message or a monitored command without blocking.
+// If the output buffer is full or the pubsub class output buffer limit is exceeded,
+// the client is too slow to keep up, so it is disconnected.
+func (s *session) push(v resp.Value) bool {
+	b, err := v.MarshalRESP()
+	if err != nil || !s.account(b, classPubSub) {
+		return false
+	}
+	select {
+	case s.out <- b:
+		return true
+	case <-s.done:
+		return false
+	default:
+		s.kill()
+		return false
+	}
+}
+
+// account adds the size of an encoded reply to the queued bytes, checking the output buffer limit of the class.
+// The session is killed if the hard limit is reached, or the soft limit stays exceeded for longer than allowed.
+func (s *session) account(b []byte, class string) bool {
+	queued := s.outBytes.Add(int64(len(b)))
+	limit := outputLimit(class)
+	if limit.hard > 0 && queued >= limit.hard {
+		s.kill()
+		return false
+	}
+	if limit.soft > 0 && queued >= limit.soft {
+		now := time.Now().UnixNano()
+		since := s.softOver.Load()
+		if since == 0 {
+			s.softOver.Store(now)
+		} else if time.Duration(now-since) >= limit.softSeconds {
+			s.kill()
+			return false
+		}
+	} else {
+		s.softOver.Store(0)
+	}
+	return true
+}
+
+// drain waits until the replies queued so far are written to the connection, for at most the timeout.
+func (s *session) drain(timeout time.Duration) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for s.outBytes.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// kill tears down the session and closes the connection, unblocking any pending read.
+func (s *session) kill() {
+	s.once.Do(func() {
+		close(s.done)
+		s.conn.Close()
+	})
+}
+
+// subscriptions returns the number of channels and patterns the client is subscribed to.
+func (s *session) subscriptions() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// writeLoop drains the output buffer to the connection until the session is closed.
+// Writes are buffered and flushed whenever the output buffer runs empty. A client that stops reading
+// is closed once a write blocks for the idle timeout, or for clientWriteTimeout if there is none.
+func (s *session) writeLoop() {
+	wr := bufio.NewWriter(s.conn)
+	var unflushed int64 // Bytes handed to wr since the last flush
+	setDeadline := func() {
+		timeout := idleTimeout()
+		if timeout == 0 {
+			timeout = clientWriteTimeout
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	for {
+		select {
+		case b := <-s.out:
+			setDeadline()
+			if _, err := wr.Write(b); err != nil {
+				s.kill()
+				return
+			}
+			unflushed += int64(len(b))
+			if len(s.out) == 0 {
+				setDeadline()
+				if err := wr.Flush(); err != nil {
+					s.kill()
+					return
+				}
+				s.outBytes.Add(-unflushed)
+				unflushed = 0
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tidwall/resp"
+)
+
+// Limits of what a slow log entry keeps of a command, matching Redis.
+const (
+	slowlogMaxArgs   = 32  // Arguments kept per entry; the rest are summarized in a last argument
+	slowlogMaxArgLen = 128 // Bytes kept per argument; the rest are summarized in a suffix
+)
+
+// slowlogEntry is a command that took longer than slowlog-log-slower-than.
+type slowlogEntry struct {
+	id       int64         // Unique, increasing ID of the entry.
+	time     time.Time     // When the command started.
+	duration time.Duration // How long the command took.
+	args     []string      // The command and its arguments, possibly truncated.
+	addr     string        // Address of the client that sent the command.
+	name     string        // Name of the client set with CLIENT SETNAME.
+}
+
+// slowlog holds the most recent slow commands, bounded by slowlog-max-len. The entries form a ring that
+// grows up to maxLen; once it is full, every new entry overwrites the oldest one at head.
+var slowlog = struct {
+	mu        sync.Mutex     // Mutex to protect the fields below.
+	threshold time.Duration  // Commands taking at least this long are logged; negative disables the log.
+	maxLen    int            // Maximum number of entries kept.
+	entries   []slowlogEntry // Logged entries, oldest first from head on.
+	head      int            // Position of the oldest entry; 0 until the ring is full.
+	nextID    int64          // ID of the next entry.
+}{
+	threshold: 10 * time.Millisecond,
+	maxLen:    128,
+}
+
+// logSlowCommand adds the command to the slow log if it took at least slowlog-log-slower-than.
+func (s *session) logSlowCommand(values []resp.Value, start time.Time, d time.Duration) {
+	slowlog.mu.Lock()
+	threshold := slowlog.threshold
+	slowlog.mu.Unlock()
+	if threshold < 0 || d < threshold || len(values) == 0 {
+		return
+	}
+
+	args := make([]string, 0, len(values))
+	for i, value := range values {
+		if i == slowlogMaxArgs-1 && len(values) > slowlogMaxArgs {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(values)-i))
+			break
+		}
+		arg := value.String()
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		args = append(args, arg)
+	}
+	entry := slowlogEntry{time: start, duration: d, args: args, addr: s.conn.RemoteAddr().String(), name: s.clientName()}
+
+	slowlog.mu.Lock()
+	defer slowlog.mu.Unlock()
+	entry.id = slowlog.nextID
+	slowlog.nextID++
+	switch {
+	case len(slowlog.entries) < slowlog.maxLen:
+		slowlog.entries = append(slowlog.entries, entry)
+	case slowlog.maxLen > 0:
+		slowlog.entries[slowlog.head] = entry
+		slowlog.head = (slowlog.head + 1) % len(slowlog.entries)
+	}
+}
+
+// slowlogAt returns the entry at position i from the oldest; the caller holds the mutex.
+func slowlogAt(i int) slowlogEntry {
+	return slowlog.entries[(slowlog.head+i)%len(slowlog.entries)]
+}
+
+// trimSlowlog drops the oldest entries beyond slowlog-max-len and puts the ring back in order from position
+// 0, so it can grow again; the caller holds the mutex.
+func trimSlowlog() {
+	keep := min(len(slowlog.entries), slowlog.maxLen)
+	entries := make([]slowlogEntry, 0, keep)
+	for i := len(slowlog.entries) - keep; i < len(slowlog.entries); i++ {
+		entries = append(entries, slowlogAt(i))
+	}
+	slowlog.entries, slowlog.head = entries, 0
+}
+
+// setSlowlogThreshold applies a new slowlog-log-slower-than value, in microseconds.
+// Zero logs every command and a negative value disables the log.
+func setSlowlogThreshold(value string) error {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET 'slowlog-log-slower-than'", value)
+	}
+	slowlog.mu.Lock()
+	defer slowlog.mu.Unlock()
+	slowlog.threshold = time.Duration(n) * time.Microsecond
+	return nil
+}
+
+// setSlowlogMaxLen applies a new slowlog-max-len value, dropping the oldest entries beyond it.
+func setSlowlogMaxLen(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET 'slowlog-max-len'", value)
+	}
+	slowlog.mu.Lock()
+	defer slowlog.mu.Unlock()
+	slowlog.maxLen = n
+	trimSlowlog()
+	return nil
+}
+
+// slowlogCommand executes a SLOWLOG GET, LEN or RESET command and returns the reply.
+func slowlogCommand(c SLOWLOGcommand) resp.Value {
+	slowlog.mu.Lock()
+	defer slowlog.mu.Unlock()
+	switch c.subcommand {
+	case "LEN":
+		return resp.IntegerValue(len(slowlog.entries))
+	case "RESET":
+		slowlog.entries, slowlog.head = nil, 0
+		return resp.SimpleStringValue("OK")
+	}
+	// GET: the newest entries first, 10 unless a count is given; -1 returns all of them
+	count := 10
+	if len(c.args) == 1 {
+		n, err := strconv.Atoi(c.args[0])
+		if err != nil || n < -1 {
+			return resp.ErrorValue(fmt.Errorf("ERR count should be greater than or equal to -1"))
+		}
+		count = n
+	}
+	if count == -1 || count > len(slowlog.entries) {
+		count = len(slowlog.entries)
+	}
+	values := make([]resp.Value, 0, count)
+	for i := len(slowlog.entries) - 1; i >= len(slowlog.entries)-count; i-- {
+		entry := slowlogAt(i)
+		args := make([]resp.Value, len(entry.args))
+		for j, arg := range entry.args {
+			args[j] = resp.StringValue(arg)
+		}
+		values = append(values, resp.ArrayValue([]resp.Value{
+			resp.IntegerValue(int(entry.id)),
+			resp.IntegerValue(int(entry.time.Unix())),
+			resp.IntegerValue(int(entry.duration.Microseconds())),
+			resp.ArrayValue(args),
+			resp.StringValue(entry.addr),
+			resp.StringValue(entry.name),
+		}))
+	}
+	return resp.ArrayValue(values)
+}
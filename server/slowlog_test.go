@@ -0,0 +1,84 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/resp"
+)
+
+func TestSlowlog(t *testing.T) {
+	addr := startTestServer(t)
+	c := dialTestClient(t, addr)
+	c.do(t, "SLOWLOG", "RESET")
+	t.Cleanup(func() { c.do(t, "SLOWLOG", "RESET") })
+
+	// With a threshold of 0 every command is logged, including SLOWLOG itself.
+	setConfig(t, "slowlog-log-slower-than", "0", "10000")
+	c.do(t, "CLIENT", "SETNAME", "slow")
+	c.do(t, "SET", "key", strings.Repeat("v", 200))
+	if n := c.do(t, "SLOWLOG", "LEN").Integer(); n != 2 {
+		t.Fatalf("expected 2 entries, got %d", n)
+	}
+
+	// SLOWLOG LEN was logged after it replied, so SET is the second newest entry.
+	entries := c.do(t, "SLOWLOG", "GET", "2").Array()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	entry := entries[1].Array()
+	if len(entry) != 6 {
+		t.Fatalf("unexpected entry %v", entry)
+	}
+	args := entry[3].Array()
+	if len(args) != 3 || args[0].String() != "SET" || args[2].String() != strings.Repeat("v", 128)+"... (72 more bytes)" {
+		t.Errorf("unexpected arguments %v", args)
+	}
+	if entry[4].String() != c.conn.LocalAddr().String() || entry[5].String() != "slow" {
+		t.Errorf("unexpected client %q %q", entry[4].String(), entry[5].String())
+	}
+
+	// The log is bounded by slowlog-max-len, and the newest entries come first.
+	setConfig(t, "slowlog-max-len", "2", "128")
+	entries = c.do(t, "SLOWLOG", "GET", "-1").Array()
+	if len(entries) != 2 || entries[0].Array()[3].Array()[0].String() != "SLOWLOG" {
+		t.Errorf("unexpected entries %v", entries)
+	}
+	// Once the log is full, every entry replaces the oldest one, and a larger slowlog-max-len keeps them in order
+	for _, key := range []string{"a", "b", "c"} {
+		c.do(t, "SET", key, "1")
+	}
+	if got := slowlogKeys(c.do(t, "SLOWLOG", "GET", "-1")); got != "c b" {
+		t.Errorf("expected the two newest entries, got %q", got)
+	}
+	setConfig(t, "slowlog-max-len", "4", "128")
+	c.do(t, "SET", "d", "1")
+	if got := slowlogKeys(c.do(t, "SLOWLOG", "GET", "-1")); got != "d SLOWLOG c" {
+		t.Errorf("expected the entries kept in order after growing the log, got %q", got)
+	}
+	if v := c.do(t, "SLOWLOG", "GET", "-2"); v.Type() != resp.Error {
+		t.Error("expected a negative count to be rejected")
+	}
+
+	setConfig(t, "slowlog-log-slower-than", "-1", "10000")
+	c.do(t, "SLOWLOG", "RESET")
+	c.do(t, "PING")
+	if n := c.do(t, "SLOWLOG", "LEN").Integer(); n != 0 {
+		t.Errorf("expected a disabled log to stay empty, got %d entries", n)
+	}
+}
+
+// slowlogKeys returns the keys of the SET entries of a SLOWLOG GET reply, and the command of the others,
+// newest first.
+func slowlogKeys(v resp.Value) string {
+	var keys []string
+	for _, entry := range v.Array() {
+		args := entry.Array()[3].Array()
+		if args[0].String() == "SET" {
+			keys = append(keys, args[1].String())
+		} else {
+			keys = append(keys, args[0].String())
+		}
+	}
+	return strings.Join(keys, " ")
+}
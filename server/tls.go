@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tlsConfig builds the TLS settings of the TLS listener from the certificate, key and CA files.
+// With a CA file, client certificates are verified against it when presented,
+// and TLSAuthClients makes presenting one mandatory.
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.TLSCAFile == "" {
+		if cfg.TLSAuthClients {
+			return nil, errors.New("TLS client authentication requires a CA file")
+		}
+		return tlsConfig, nil
+	}
+	pem, err := os.ReadFile(cfg.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading TLS CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.TLSAuthClients {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// certificateUser returns the ACL user named by the common name of a verified client certificate,
+// or "" if there is no such certificate or no enabled user with that name.
+func certificateUser(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	name := state.VerifiedChains[0][0].Subject.CommonName
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	if u, ok := acl.users[name]; ok && u.enabled {
+		return name
+	}
+	return ""
+}
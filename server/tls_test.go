@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate and key generated for a test, with their PEM files.
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCertificate creates a certificate for the common name, signed by parent or self-signed if parent is nil.
+func newTestCertificate(t *testing.T, dir, name string, parent *testCertificate, isCA bool) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         isCA,
+
+		BasicConstraintsValid: true,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tc := &testCertificate{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	writePEM(t, tc.certFile, "CERTIFICATE", der)
+	writePEM(t, tc.keyFile, "EC PRIVATE KEY", keyDER)
+	return tc
+}
+
+// writePEM writes a single PEM block to a file.
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLSServer serves a test database on a loopback TLS listener built from cfg.
+func startTLSServer(t *testing.T, cfg Config) string {
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		l.Close()
+	})
+	go serve(ctx, l, openTestDB(t))
+	return l.Addr().String()
+}
+
+// tlsRoundTrip sends an inline command over TLS and returns the first reply line.
+func tlsRoundTrip(addr string, clientConfig *tls.Config, command string) (string, error) {
+	conn, err := tls.Dial("tcp", addr, clientConfig)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(command + "\r\n")); err != nil {
+		return "", err
+	}
+	rd := bufio.NewReader(conn)
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "$") {
+		line, err = rd.ReadString('\n')
+	}
+	return strings.TrimSpace(line), err
+}
+
+func TestTLSClientCertificateSelectsUser(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, dir, "test-ca", nil, true)
+	serverCert := newTestCertificate(t, dir, "server", ca, false)
+	clientCert := newTestCertificate(t, dir, "tlsuser", ca, false)
+
+	if err := setUser("tlsuser", []string{"on", "nopass", "+@all", "allkeys"}); err != nil {
+		t.Fatal(err)
+	}
+	defer removeUser("tlsuser")
+
+	addr := startTLSServer(t, Config{
+		TLSCertFile:    serverCert.certFile,
+		TLSKeyFile:     serverCert.keyFile,
+		TLSCAFile:      ca.certFile,
+		TLSAuthClients: true,
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	keyPair, err := tls.LoadX509KeyPair(clientCert.certFile, clientCert.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := tlsRoundTrip(addr, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{keyPair}}, "ACL WHOAMI")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "tlsuser" {
+		t.Errorf("expected the certificate CN to select the ACL user, got %q", reply)
+	}
+
+	// Without a client certificate the handshake is refused.
+	if _, err := tlsRoundTrip(addr, &tls.Config{RootCAs: roots}, "PING"); err == nil {
+		t.Error("expected a connection without client certificate to fail")
+	}
+}
+
+func TestTLSWithoutClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, dir, "test-ca", nil, true)
+	serverCert := newTestCertificate(t, dir, "server", ca, false)
+
+	addr := startTLSServer(t, Config{TLSCertFile: serverCert.certFile, TLSKeyFile: serverCert.keyFile})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	reply, err := tlsRoundTrip(addr, &tls.Config{RootCAs: roots}, "PING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != "+PONG" {
+		t.Errorf("unexpected reply %q", reply)
+	}
+}
+
+func TestTLSConfigRequiresCAForClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	serverCert := newTestCertificate(t, dir, "server", nil, false)
+	_, err := Config{TLSCertFile: serverCert.certFile, TLSKeyFile: serverCert.keyFile, TLSAuthClients: true}.tlsConfig()
+	if err == nil {
+		t.Error("expected client authentication without CA file to be rejected")
+	}
+}
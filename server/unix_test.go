@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+	listeners, err := Config{UnixSocket: path, UnixSocketPerm: 0700}.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("expected only the Unix socket listener, got %d listeners", len(listeners))
+	}
+	// The private directory the socket was created in is gone once it is renamed into place
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("expected only the socket file next to it, got %v, %v", entries, err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0700 {
+		t.Errorf("unexpected socket file mode %v", fi.Mode())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serve(ctx, listeners[0], openTestDB(t))
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(line) != "+PONG" {
+		t.Errorf("unexpected reply %q", line)
+	}
+
+	cancel()
+	closeListeners(listeners)
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the socket file to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestUnixSocketReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crashed server that left its socket file behind.
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listeners, err := Config{UnixSocket: path}.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeListeners(listeners)
+}
+
+func TestUnixSocketRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Config{UnixSocket: path}).listen(); err == nil {
+		t.Fatal("expected listening on a regular file to fail")
+	}
+	if b, err := os.ReadFile(path); err != nil || string(b) != "keep me" {
+		t.Errorf("expected the file to be left alone, got %q, %v", b, err)
+	}
+}